@@ -0,0 +1,528 @@
+//
+// bibjson.go converts elements to and from BibJSON, see http://okfnlabs.org/bibjson/
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BibJSONCollection is a BibJSON collection of records
+type BibJSONCollection struct {
+	Metadata *BibJSONMetadata `json:"metadata,omitempty"`
+	Records  []BibJSONRecord  `json:"records"`
+}
+
+// BibJSONMetadata describes a collection
+type BibJSONMetadata struct {
+	Collection  string `json:"collection,omitempty"`
+	Label       string `json:"label,omitempty"`
+	Description string `json:"description,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Records     int    `json:"records,omitempty"`
+}
+
+// BibJSONRecord is a BibJSON record, a bibliographic entry. Most keys
+// are BibTeX tag names with string values, the exceptions are listed in
+// bibjsonPeople, bibjsonIdentifierTags, bibjsonLinks and bibjsonJournal.
+// A tag named like one of the record's own keys, e.g. a techreport's
+// type, is kept under its name prefixed with bibtex_, e.g. bibtex_type.
+type BibJSONRecord map[string]interface{}
+
+// BibJSONPerson is an author or editor, Name holds the whole name in
+// BibTeX's "von Last, Jr, First" form
+type BibJSONPerson struct {
+	Name      string `json:"name,omitempty"`
+	FirstName string `json:"firstname,omitempty"`
+	LastName  string `json:"lastname,omitempty"`
+	ID        string `json:"id,omitempty"`
+}
+
+// BibJSONIdentifier is a typed identifier, e.g. a DOI
+type BibJSONIdentifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	URL  string `json:"url,omitempty"`
+}
+
+// BibJSONLink is a link to the work
+type BibJSONLink struct {
+	URL    string `json:"url"`
+	Anchor string `json:"anchor,omitempty"`
+}
+
+var (
+	// bibjsonPeople are tags holding names, lists of BibJSONPerson in BibJSON
+	bibjsonPeople = []string{"author", "editor"}
+
+	// bibjsonIdentifierTags are tags holding identifiers of the same
+	// type, a list of BibJSONIdentifier in BibJSON along with eprint
+	bibjsonIdentifierTags = []string{"doi", "isbn", "issn", "lccn", "pmcid", "pmid"}
+
+	// bibjsonEprintTypes are identifier types held in BibLaTeX's eprint
+	// tag, named by its eprinttype tag
+	bibjsonEprintTypes = map[string]bool{
+		"arxiv":       true,
+		"jstor":       true,
+		"hdl":         true,
+		"googlebooks": true,
+		"eprint":      true,
+	}
+
+	// bibjsonIdentifierURLs prefix identifiers to give their URL
+	bibjsonIdentifierURLs = map[string]string{
+		"doi":   "https://doi.org/",
+		"pmid":  "https://www.ncbi.nlm.nih.gov/pubmed/",
+		"arxiv": "https://arxiv.org/abs/",
+		"hdl":   "https://hdl.handle.net/",
+	}
+
+	// bibjsonLinks are tags holding URLs, lists of BibJSONLink in BibJSON
+	bibjsonLinks = []string{"url"}
+
+	// bibjsonJournal are the tags naming a journal, an object in BibJSON
+	bibjsonJournal = []string{"journal", "journaltitle"}
+
+	// bibjsonReserved are the keys of a record that aren't tags
+	bibjsonReserved = map[string]bool{
+		"type":       true,
+		"id":         true,
+		"citekey":    true,
+		"identifier": true,
+		"link":       true,
+		"journal":    true,
+	}
+
+	// bibjsonJournalTags are the keys of a journal object that become tags
+	bibjsonJournalTags = map[string]string{
+		"volume": "volume",
+		"number": "number",
+		"issue":  "number",
+		"pages":  "pages",
+	}
+)
+
+// bibjsonTagPrefix prefixes the key of a tag named like a reserved key
+const bibjsonTagPrefix = "bibtex_"
+
+// bibjsonKey returns the record key of a tag, escaping names that would
+// collide with the record's own keys, those skipped as internal to
+// BibJSON and those that look escaped already
+func bibjsonKey(name string) string {
+	if bibjsonReserved[name] == true || strings.HasPrefix(name, "_") || strings.HasPrefix(name, bibjsonTagPrefix) {
+		return bibjsonTagPrefix + name
+	}
+	return name
+}
+
+// isBibJSONPeople checks if a tag holds names
+func isBibJSONPeople(name string) bool {
+	for _, people := range bibjsonPeople {
+		if name == people {
+			return true
+		}
+	}
+	return false
+}
+
+// ToBibJSONRecord converts an element to a BibJSON record. Values have
+// their macros expanded and outer delimiters removed, LaTeX in them is
+// left as is. The citation key becomes the id.
+func ToBibJSONRecord(element *Element) BibJSONRecord {
+	record := make(BibJSONRecord)
+	record["type"] = element.Type
+	if element.CiteKey != "" {
+		record["id"] = element.CiteKey
+	}
+	var (
+		identifiers []*BibJSONIdentifier
+		links       []*BibJSONLink
+	)
+	done := make(map[string]bool)
+	for _, name := range bibjsonPeople {
+		var people []*BibJSONPerson
+		for _, person := range element.Names(name) {
+			people = append(people, &BibJSONPerson{
+				Name:      person.String(),
+				FirstName: person.First,
+				LastName:  strings.TrimSpace(person.Von + " " + person.Last),
+			})
+		}
+		if people != nil {
+			record[name] = people
+		}
+		done[name] = true
+	}
+	// Identifiers in a fixed order
+	for _, name := range bibjsonIdentifierTags {
+		done[name] = true
+		val, ok := element.expanded(name)
+		if ok == false || strings.TrimSpace(val) == "" {
+			continue
+		}
+		idType := name
+		id := strings.TrimSpace(val)
+		if idType == "doi" {
+			id = strings.TrimSpace(doiURL.ReplaceAllString(id, ""))
+		}
+		identifier := &BibJSONIdentifier{Type: idType, ID: id}
+		if prefix, ok := bibjsonIdentifierURLs[idType]; ok == true {
+			identifier.URL = prefix + id
+		}
+		identifiers = append(identifiers, identifier)
+	}
+	// BibLaTeX's eprint is an identifier of the eprinttype
+	if eprint, ok := element.expanded("eprint"); ok == true {
+		idType := "eprint"
+		if eprintType, ok := element.expanded("eprinttype"); ok == true && bibjsonEprintTypes[strings.ToLower(eprintType)] == true {
+			idType = strings.ToLower(eprintType)
+			done["eprinttype"] = true
+		}
+		identifier := &BibJSONIdentifier{Type: idType, ID: eprint}
+		if prefix, ok := bibjsonIdentifierURLs[idType]; ok == true {
+			identifier.URL = prefix + eprint
+		}
+		identifiers = append(identifiers, identifier)
+		done["eprint"] = true
+	}
+	if identifiers != nil {
+		record["identifier"] = identifiers
+	}
+	for _, name := range bibjsonLinks {
+		if val, ok := element.expanded(name); ok == true {
+			links = append(links, &BibJSONLink{URL: val})
+		}
+		done[name] = true
+	}
+	if links != nil {
+		record["link"] = links
+	}
+	for _, name := range bibjsonJournal {
+		// The first journal tag found is the journal, others are kept as tags
+		if _, taken := record["journal"]; taken == true {
+			continue
+		}
+		if val, ok := element.expanded(name); ok == true {
+			record["journal"] = map[string]string{"name": val}
+			done[name] = true
+		}
+	}
+	for _, field := range element.Fields() {
+		name := strings.ToLower(field.Name)
+		if done[name] == true {
+			continue
+		}
+		done[name] = true
+		record[bibjsonKey(name)], _ = element.expanded(name)
+	}
+	return record
+}
+
+// ToBibJSON converts elements to a BibJSON collection. @string entries
+// are left out as their macros are expanded in the values, resolve the
+// elements first to expand the macros they define.
+func ToBibJSON(elements []*Element) *BibJSONCollection {
+	collection := &BibJSONCollection{Records: []BibJSONRecord{}}
+	for _, element := range elements {
+		if element.Type == "string" {
+			continue
+		}
+		collection.Records = append(collection.Records, ToBibJSONRecord(element))
+	}
+	return collection
+}
+
+// bibjsonGeneric converts a value to the types encoding/json decodes
+// into, so records made by ToBibJSONRecord convert back as they are
+func bibjsonGeneric(val interface{}) interface{} {
+	switch val.(type) {
+	case nil, string, float64, bool, []interface{}, map[string]interface{}:
+		return val
+	}
+	var generic interface{}
+	src, err := json.Marshal(val)
+	if err == nil {
+		err = json.Unmarshal(src, &generic)
+	}
+	if err != nil {
+		return val
+	}
+	return generic
+}
+
+// bibjsonValue returns a BibJSON value as the raw value of a tag, text
+// is wrapped in curly brackets and numbers are left bare
+func bibjsonValue(val string) string {
+	if isNumber(val) == true {
+		return val
+	}
+	return "{" + val + "}"
+}
+
+// bibjsonString converts a scalar, or an object with a name, to a string
+func bibjsonString(val interface{}) (string, bool) {
+	switch v := val.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case map[string]interface{}:
+		if name, ok := v["name"].(string); ok == true {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// bibjsonPerson converts an author or editor, an object or a string, to
+// a name in BibTeX's form
+func bibjsonPerson(val interface{}) (string, bool) {
+	switch v := val.(type) {
+	case string:
+		return v, true
+	case map[string]interface{}:
+		if name, ok := v["name"].(string); ok == true && name != "" {
+			return name, true
+		}
+		first, _ := v["firstname"].(string)
+		last, _ := v["lastname"].(string)
+		if last != "" {
+			return (&Person{First: first, Last: last}).String(), true
+		}
+	}
+	return "", false
+}
+
+// FromBibJSONRecord converts a BibJSON record to an element. A record
+// without a type is a misc entry. Keys starting with an underscore are
+// internal to BibJSON and skipped. Values that can't be converted, or
+// have unbalanced curly brackets BibTeX couldn't read back, are skipped
+// and returned as an ErrorList along with the element.
+func FromBibJSONRecord(record BibJSONRecord) (*Element, error) {
+	var errList ErrorList
+
+	element := new(Element)
+	element.Tags = make(map[string]string)
+	elementType, _ := record["type"].(string)
+	if elementType == "" {
+		elementType = "misc"
+	}
+	element.setType(elementType)
+	for _, key := range []string{"id", "citekey"} {
+		if id, ok := record[key].(string); ok == true && id != "" {
+			element.CiteKey = id
+			element.Keys = []string{id}
+			break
+		}
+	}
+	// ignored reports a value that was skipped
+	ignored := func(key string) {
+		errList = append(errList, elementError(element, fmt.Sprintf("can't convert %s", key)))
+	}
+	// value converts text to the raw value of a tag, text with unbalanced
+	// curly brackets can't be read back as BibTeX and is reported
+	value := func(key string, val string) (string, bool) {
+		if balanced([]string{val}) == false {
+			errList = append(errList, elementError(element, fmt.Sprintf("unbalanced curly brackets in %s", key)))
+			return "", false
+		}
+		return bibjsonValue(val), true
+	}
+
+	var keys []string
+	for key := range record {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	// set adds a tag unless it was given at the top level of the record
+	set := func(key string, name string, val string) {
+		if _, ok := element.Get(name); ok == true {
+			return
+		}
+		if raw, ok := value(key, val); ok == true {
+			element.Set(name, raw)
+		}
+	}
+	for _, key := range keys {
+		val := bibjsonGeneric(record[key])
+		name := strings.ToLower(key)
+		switch {
+		case name == "type" || name == "id" || name == "citekey" || strings.HasPrefix(name, "_"):
+		case isBibJSONPeople(name):
+			list, ok := val.([]interface{})
+			if ok == false {
+				list = []interface{}{val}
+			}
+			var names []string
+			for _, item := range list {
+				if person, ok := bibjsonPerson(item); ok == true {
+					names = append(names, person)
+				} else {
+					ignored(key)
+				}
+			}
+			if names != nil {
+				if raw, ok := value(key, strings.Join(names, " and ")); ok == true {
+					element.Set(name, raw)
+				}
+			}
+		case name == "identifier":
+			list, _ := val.([]interface{})
+			for _, item := range list {
+				identifier, _ := item.(map[string]interface{})
+				idType, _ := identifier["type"].(string)
+				id, _ := identifier["id"].(string)
+				if idType == "" || id == "" {
+					ignored(key)
+					continue
+				}
+				idType = strings.ToLower(idType)
+				switch {
+				case bibjsonEprintTypes[idType] == true:
+					set(key, "eprint", id)
+					if idType != "eprint" {
+						set(key, "eprinttype", idType)
+					}
+				default:
+					// Identifier types are named after their tags
+					set(key, idType, id)
+				}
+			}
+		case name == "link":
+			list, _ := val.([]interface{})
+			for i, item := range list {
+				link, _ := item.(map[string]interface{})
+				url, _ := link["url"].(string)
+				if url == "" || i > 0 {
+					ignored(key)
+					continue
+				}
+				set(key, "url", url)
+			}
+		case name == "journal":
+			journal, ok := val.(map[string]interface{})
+			if ok == false {
+				if s, ok := bibjsonString(val); ok == true {
+					if raw, ok := value(key, s); ok == true {
+						element.Set("journal", raw)
+					}
+				} else {
+					ignored(key)
+				}
+				continue
+			}
+			if journalName, ok := journal["name"].(string); ok == true {
+				if raw, ok := value(key, journalName); ok == true {
+					element.Set("journal", raw)
+				}
+			}
+			for jKey, tag := range bibjsonJournalTags {
+				if s, ok := bibjsonString(journal[jKey]); ok == true {
+					set(key, tag, s)
+				}
+			}
+		default:
+			name = strings.TrimPrefix(name, bibjsonTagPrefix)
+			if list, ok := val.([]interface{}); ok == true {
+				// e.g. a list of keywords
+				var items []string
+				for _, item := range list {
+					if s, ok := bibjsonString(item); ok == true {
+						items = append(items, s)
+					}
+				}
+				if len(items) != len(list) {
+					ignored(key)
+					continue
+				}
+				if raw, ok := value(key, strings.Join(items, ", ")); ok == true {
+					element.Set(name, raw)
+				}
+				continue
+			}
+			if s, ok := bibjsonString(val); ok == true {
+				if raw, ok := value(key, s); ok == true {
+					element.Set(name, raw)
+				}
+				continue
+			}
+			ignored(key)
+		}
+	}
+	if len(errList) > 0 {
+		return element, errList
+	}
+	return element, nil
+}
+
+// FromBibJSON converts the records of a BibJSON collection to elements,
+// in order. Problems converting the records are returned as an
+// ErrorList along with the elements.
+func FromBibJSON(collection *BibJSONCollection) ([]*Element, error) {
+	var (
+		elements []*Element
+		errList  ErrorList
+	)
+
+	for _, record := range collection.Records {
+		element, err := FromBibJSONRecord(record)
+		if e, ok := err.(ErrorList); ok == true {
+			errList = append(errList, e...)
+		}
+		elements = append(elements, element)
+	}
+	if len(errList) > 0 {
+		return elements, errList
+	}
+	return elements, nil
+}
+
+// ParseBibJSON reads a BibJSON collection. As jq pipelines often give
+// just the records, an array of records or a single record is read as
+// a collection of them.
+func ParseBibJSON(src []byte) (*BibJSONCollection, error) {
+	var probe interface{}
+	if err := json.Unmarshal(src, &probe); err != nil {
+		return nil, err
+	}
+	collection := new(BibJSONCollection)
+	switch v := probe.(type) {
+	case []interface{}:
+		if err := json.Unmarshal(src, &collection.Records); err != nil {
+			return nil, err
+		}
+	case map[string]interface{}:
+		if _, ok := v["records"]; ok == true {
+			if err := json.Unmarshal(src, collection); err != nil {
+				return nil, err
+			}
+			break
+		}
+		collection.Records = []BibJSONRecord{BibJSONRecord(v)}
+	default:
+		return nil, errors.New("expected a BibJSON collection, list of records or record")
+	}
+	return collection, nil
+}
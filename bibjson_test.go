@@ -0,0 +1,333 @@
+//
+// bibjson_test.go tests converting elements to and from BibJSON
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+// TestToBibJSON checks the mapping of tags to BibJSON
+func TestToBibJSON(t *testing.T) {
+	elements, err := Parse([]byte(`@string{rsd = "R. S. Doiel"}
+
+@article{doiel2016,
+    author = rsd # " and von Neumann, Jr, John",
+    title = {Turtles in the {Applied} Sciences},
+    journal = {Turtle Journal},
+    year = 2016,
+    doi = {https://doi.org/10.1000/182},
+    isbn = {0-306-40615-2},
+    eprint = {1234.5678},
+    eprinttype = {arXiv},
+    url = {http://example.org/turtles},
+}`))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	Resolve(elements)
+	src, err := json.Marshal(ToBibJSON(elements))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	expected := `{"records":[{"author":[{"name":"Doiel, R. S.","firstname":"R. S.","lastname":"Doiel"},{"name":"von Neumann, Jr, John","firstname":"John","lastname":"von Neumann"}],` +
+		`"id":"doiel2016",` +
+		`"identifier":[{"type":"doi","id":"10.1000/182","url":"https://doi.org/10.1000/182"},{"type":"isbn","id":"0-306-40615-2"},{"type":"arxiv","id":"1234.5678","url":"https://arxiv.org/abs/1234.5678"}],` +
+		`"journal":{"name":"Turtle Journal"},` +
+		`"link":[{"url":"http://example.org/turtles"}],` +
+		`"title":"Turtles in the {Applied} Sciences","type":"article","year":"2016"}]}`
+	if string(src) != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, src)
+	}
+
+	// A record converts straight back without going through JSON
+	element, err := FromBibJSONRecord(ToBibJSONRecord(elements[1]))
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	for name, val := range map[string]string{"author": "{Doiel, R. S. and von Neumann, Jr, John}", "doi": "{10.1000/182}", "eprinttype": "{arxiv}", "journal": "{Turtle Journal}", "year": "2016"} {
+		if found, _ := element.Get(name); found != val {
+			t.Errorf("expected %s = %s, found %q", name, val, found)
+		}
+	}
+}
+
+// TestFromBibJSON checks BibJSON from elsewhere converts to elements
+func TestFromBibJSON(t *testing.T) {
+	src := []byte(`[
+  {
+    "type": "article",
+    "id": "smith2020",
+    "_id": "e3b0c442",
+    "title": "Snails and their shells",
+    "author": [{"firstname": "Jane", "lastname": "Smith"}, "Jones, Fred"],
+    "journal": {"name": "Mollusc Letters", "volume": "12", "issue": 3, "pages": "1--10"},
+    "identifier": [{"type": "DOI", "id": "10.1000/999"}, {"type": "hdl", "id": "2027/abc"}],
+    "link": [{"url": "http://example.org/snails", "anchor": "home"}],
+    "keyword": ["snails", "shells"],
+    "year": 2020,
+    "pages": "2--11"
+  },
+  {
+    "title": "Untyped",
+    "editor": {"name": "Ann Editor"},
+    "citations": [{"id": "smith2020"}]
+  }
+]`)
+	collection, err := ParseBibJSON(src)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	elements, err := FromBibJSON(collection)
+	if len(elements) != 2 {
+		t.Errorf("expected 2 elements, found %d", len(elements))
+		t.FailNow()
+	}
+	if errList, ok := err.(ErrorList); ok == false || len(errList) != 1 || errList[0].Error() != "can't convert citations in @misc" {
+		t.Errorf("expected the citations to be reported, found %v", err)
+	}
+	if elements[0].Type != "article" || elements[0].CiteKey != "smith2020" {
+		t.Errorf("expected article smith2020, found %s %s", elements[0].Type, elements[0].CiteKey)
+	}
+	expected := map[string]string{
+		"title":   "{Snails and their shells}",
+		"author":  "{Smith, Jane and Jones, Fred}",
+		"journal": "{Mollusc Letters}",
+		"volume":  "12",
+		"number":  "3",
+		"pages":   "{2--11}",
+		"doi":     "{10.1000/999}",
+		"eprint":  "{2027/abc}",
+		"url":     "{http://example.org/snails}",
+		"keyword": "{snails, shells}",
+		"year":    "2020",
+		"_id":     "",
+		"citekey": "",
+	}
+	for name, val := range expected {
+		found, _ := elements[0].Get(name)
+		if found != val {
+			t.Errorf("expected %s = %s, found %q", name, val, found)
+		}
+	}
+	if elements[1].Type != "misc" {
+		t.Errorf("expected a misc entry, found %s", elements[1].Type)
+	}
+	if val, _ := elements[1].Get("editor"); val != "{Ann Editor}" {
+		t.Errorf("expected the editor, found %q", val)
+	}
+
+	// A collection and a single record
+	for _, src := range []string{`{"metadata": {"collection": "snails"}, "records": [{"id": "a1"}]}`, `{"id": "a1"}`} {
+		collection, err := ParseBibJSON([]byte(src))
+		if err != nil || len(collection.Records) != 1 {
+			t.Errorf("expected one record in %s, found %v", src, err)
+		}
+	}
+	if _, err := ParseBibJSON([]byte(`"a1"`)); err == nil {
+		t.Errorf("expected an error for a string")
+	}
+}
+
+// TestBibJSONReservedTags checks tags named like a record's own keys,
+// e.g. the type of a report or thesis, survive a round trip
+func TestBibJSONReservedTags(t *testing.T) {
+	elements, err := Parse([]byte(`@techreport{tr1,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    institution = {Turtle Institute},
+    year = 2016,
+    type = {Technical Note},
+    id = {X-1},
+    journal = {Turtle Journal},
+    journaltitle = {Turtle Notes},
+}
+
+@phdthesis{th1,
+    author = {R. S. Doiel},
+    title = {Turtles all the way down},
+    school = {Turtle University},
+    year = 2017,
+    type = {Habilitation},
+    bibtex_note = {escaped already},
+}`))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	src, err := json.Marshal(ToBibJSON(elements))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	for _, expected := range []string{
+		`"bibtex_id":"X-1","bibtex_type":"Technical Note","id":"tr1"`,
+		`"type":"techreport"`,
+		`"bibtex_bibtex_note":"escaped already","bibtex_type":"Habilitation","id":"th1"`,
+		`"type":"phdthesis"`,
+	} {
+		if strings.Contains(string(src), expected) == false {
+			t.Errorf("expected %s in\n%s", expected, src)
+		}
+	}
+
+	collection, err := ParseBibJSON(src)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	converted, err := FromBibJSON(collection)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	if len(converted) != 2 {
+		t.Errorf("expected 2 elements, found %d", len(converted))
+		t.FailNow()
+	}
+	for i, element := range elements {
+		back := converted[i]
+		if back.Type != element.Type || back.CiteKey != element.CiteKey {
+			t.Errorf("expected %s %s, found %s %s", element.Type, element.CiteKey, back.Type, back.CiteKey)
+		}
+		for _, name := range []string{"type", "id", "bibtex_note", "journal", "journaltitle"} {
+			val, _ := element.Get(name)
+			found, _ := back.Get(name)
+			if found != val {
+				t.Errorf("%s: expected %s = %s, found %q", element.CiteKey, name, val, found)
+			}
+		}
+	}
+}
+
+// TestBibJSONBraces checks values with unbalanced curly brackets are
+// reported and what is converted can be parsed again
+func TestBibJSONBraces(t *testing.T) {
+	collection, err := ParseBibJSON([]byte(`{"records": [{
+    "id": "b1",
+    "type": "article",
+    "title": "a}b",
+    "note": "{\\\"O}sterreich",
+    "author": [{"name": "R. {S. Doiel"}],
+    "journal": {"name": "Turtles{"}
+}]}`))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	elements, err := FromBibJSON(collection)
+	errList, ok := err.(ErrorList)
+	if ok == false || len(errList) != 3 {
+		t.Errorf("expected 3 unbalanced values reported, found %v", err)
+	}
+	if len(elements) != 1 {
+		t.Errorf("expected 1 element, found %d", len(elements))
+		t.FailNow()
+	}
+	if val, _ := elements[0].Get("note"); val != `{{\"O}sterreich}` {
+		t.Errorf("expected the note to be kept, found %q", val)
+	}
+	parsed, err := Parse([]byte(elements[0].String()))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	if len(parsed) != 1 || Equal(parsed[0], elements[0]) == false {
+		t.Errorf("expected to parse\n%s\nback, found %s", elements[0], parsed)
+	}
+}
+
+// TestBibJSONSamples checks the samples convert to BibJSON and back
+func TestBibJSONSamples(t *testing.T) {
+	fnames := []string{"sample1.bib", "sample2.bib", "sample3a.bib", "sample3b.bib", "lint.bib"}
+	for _, fname := range fnames {
+		src, err := ioutil.ReadFile(path.Join("testdata", fname))
+		if err != nil {
+			t.Errorf("%s", err)
+			t.FailNow()
+		}
+		elements, _ := ParseWithOptions(src, Options{Recover: true})
+		Resolve(elements)
+		data, err := json.Marshal(ToBibJSON(elements))
+		if err != nil {
+			t.Errorf("%s: %s", fname, err)
+			continue
+		}
+		collection, err := ParseBibJSON(data)
+		if err != nil {
+			t.Errorf("%s: %s", fname, err)
+			continue
+		}
+		converted, err := FromBibJSON(collection)
+		if err != nil {
+			t.Errorf("%s: %s", fname, err)
+		}
+		i := 0
+		for _, element := range elements {
+			if element.Type == "string" {
+				continue
+			}
+			if i >= len(converted) {
+				t.Errorf("%s: expected %s to be converted", fname, element.CiteKey)
+				break
+			}
+			back := converted[i]
+			i++
+			if back.Type != element.Type || back.CiteKey != element.CiteKey {
+				t.Errorf("%s: expected %s %s, found %s %s", fname, element.Type, element.CiteKey, back.Type, back.CiteKey)
+			}
+			for _, field := range element.Fields() {
+				val, _ := element.expanded(field.Name)
+				found, ok := back.expanded(field.Name)
+				if ok == false {
+					t.Errorf("%s: %s lost %s", fname, element.CiteKey, field.Name)
+					continue
+				}
+				switch {
+				case isBibJSONPeople(field.Name):
+					if len(ParseNames(val)) != len(ParseNames(found)) {
+						t.Errorf("%s: %s expected the names %s, found %s", fname, element.CiteKey, val, found)
+					}
+					for j, person := range ParseNames(val) {
+						if j < len(ParseNames(found)) && *person != *ParseNames(found)[j] {
+							t.Errorf("%s: %s expected %+v, found %+v", fname, element.CiteKey, person, ParseNames(found)[j])
+						}
+					}
+				case field.Name == "doi":
+					if normalizeDOI(val) != normalizeDOI(found) {
+						t.Errorf("%s: %s expected doi %s, found %s", fname, element.CiteKey, val, found)
+					}
+				case field.Name == "eprinttype":
+					if strings.EqualFold(val, found) == false {
+						t.Errorf("%s: %s expected eprinttype %s, found %s", fname, element.CiteKey, val, found)
+					}
+				default:
+					if val != found {
+						t.Errorf("%s: %s expected %s = %q, found %q", fname, element.CiteKey, field.Name, val, found)
+					}
+				}
+			}
+		}
+	}
+}
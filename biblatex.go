@@ -0,0 +1,344 @@
+//
+// biblatex.go holds the BibLaTeX data model and converts between it and BibTeX
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// BibLaTeXSchema holds the entry types of the BibLaTeX data model
+	BibLaTeXSchema = &Schema{
+		Name: "biblatex",
+		Types: map[string]*TagTypes{
+			"article": &TagTypes{
+				Required: []string{"author", "title", "journaltitle", "date/year"},
+				Optional: []string{"translator", "annotator", "commentator", "subtitle", "titleaddon", "editor", "editora", "editorb", "editorc", "journalsubtitle", "journaltitleaddon", "issuetitle", "issuesubtitle", "issuetitleaddon", "language", "origlanguage", "series", "volume", "number", "eid", "issue", "month", "pages", "version", "issn"},
+			},
+			"book": &TagTypes{
+				Required: []string{"author", "title", "date/year"},
+				Optional: []string{"editor", "editora", "editorb", "editorc", "translator", "annotator", "commentator", "introduction", "foreword", "afterword", "subtitle", "titleaddon", "maintitle", "mainsubtitle", "maintitleaddon", "language", "origlanguage", "volume", "part", "edition", "volumes", "series", "number", "publisher", "location", "chapter", "pages", "pagetotal", "isbn"},
+			},
+			"mvbook": &TagTypes{
+				Required: []string{"author", "title", "date/year"},
+				Optional: []string{"editor", "editora", "editorb", "editorc", "translator", "annotator", "commentator", "introduction", "foreword", "afterword", "subtitle", "titleaddon", "language", "origlanguage", "edition", "volumes", "series", "number", "publisher", "location", "pagetotal", "isbn"},
+			},
+			"inbook": &TagTypes{
+				Required: []string{"author", "title", "booktitle", "date/year"},
+				Optional: []string{"bookauthor", "editor", "editora", "editorb", "editorc", "translator", "annotator", "commentator", "introduction", "foreword", "afterword", "subtitle", "titleaddon", "maintitle", "mainsubtitle", "maintitleaddon", "booksubtitle", "booktitleaddon", "language", "origlanguage", "volume", "part", "edition", "volumes", "series", "number", "publisher", "location", "chapter", "pages", "isbn"},
+			},
+			"booklet": &TagTypes{
+				Required: []string{"author/editor", "title", "date/year"},
+				Optional: []string{"subtitle", "titleaddon", "language", "howpublished", "type", "location", "chapter", "pages", "pagetotal"},
+			},
+			"collection": &TagTypes{
+				Required: []string{"editor", "title", "date/year"},
+				Optional: []string{"editora", "editorb", "editorc", "translator", "annotator", "commentator", "introduction", "foreword", "afterword", "subtitle", "titleaddon", "maintitle", "mainsubtitle", "maintitleaddon", "language", "origlanguage", "volume", "part", "edition", "volumes", "series", "number", "publisher", "location", "chapter", "pages", "pagetotal", "isbn"},
+			},
+			"mvcollection": &TagTypes{
+				Required: []string{"editor", "title", "date/year"},
+				Optional: []string{"editora", "editorb", "editorc", "translator", "annotator", "commentator", "introduction", "foreword", "afterword", "subtitle", "titleaddon", "language", "origlanguage", "edition", "volumes", "series", "number", "publisher", "location", "pagetotal", "isbn"},
+			},
+			"incollection": &TagTypes{
+				Required: []string{"author", "title", "booktitle", "date/year"},
+				Optional: []string{"editor", "editora", "editorb", "editorc", "translator", "annotator", "commentator", "introduction", "foreword", "afterword", "subtitle", "titleaddon", "maintitle", "mainsubtitle", "maintitleaddon", "booksubtitle", "booktitleaddon", "language", "origlanguage", "volume", "part", "edition", "volumes", "series", "number", "publisher", "location", "chapter", "pages", "isbn"},
+			},
+			"dataset": &TagTypes{
+				Required: []string{"author/editor", "title", "date/year"},
+				Optional: []string{"subtitle", "titleaddon", "language", "edition", "type", "series", "number", "version", "organization", "publisher", "location"},
+			},
+			"manual": &TagTypes{
+				Required: []string{"author/editor", "title", "date/year"},
+				Optional: []string{"subtitle", "titleaddon", "language", "edition", "type", "series", "number", "version", "organization", "publisher", "location", "chapter", "pages", "pagetotal", "isbn"},
+			},
+			"misc": &TagTypes{
+				Required: []string{"author/editor", "title", "date/year"},
+				Optional: []string{"subtitle", "titleaddon", "language", "howpublished", "type", "version", "organization", "location"},
+			},
+			"online": &TagTypes{
+				Required: []string{"author/editor", "title", "date/year", "doi/eprint/url"},
+				Optional: []string{"subtitle", "titleaddon", "language", "version", "organization"},
+			},
+			"patent": &TagTypes{
+				Required: []string{"author", "title", "number", "date/year"},
+				Optional: []string{"holder", "subtitle", "titleaddon", "type", "version", "location"},
+			},
+			"periodical": &TagTypes{
+				Required: []string{"editor", "title", "date/year"},
+				Optional: []string{"editora", "editorb", "editorc", "subtitle", "titleaddon", "issuetitle", "issuesubtitle", "issuetitleaddon", "language", "series", "volume", "number", "issue", "issn"},
+			},
+			"proceedings": &TagTypes{
+				Required: []string{"title", "date/year"},
+				Optional: []string{"editor", "subtitle", "titleaddon", "maintitle", "mainsubtitle", "maintitleaddon", "eventtitle", "eventtitleaddon", "eventdate", "venue", "language", "volume", "part", "volumes", "series", "number", "organization", "publisher", "location", "chapter", "pages", "pagetotal", "isbn"},
+			},
+			"mvproceedings": &TagTypes{
+				Required: []string{"title", "date/year"},
+				Optional: []string{"editor", "subtitle", "titleaddon", "eventtitle", "eventtitleaddon", "eventdate", "venue", "language", "volumes", "series", "number", "organization", "publisher", "location", "pagetotal", "isbn"},
+			},
+			"inproceedings": &TagTypes{
+				Required: []string{"author", "title", "booktitle", "date/year"},
+				Optional: []string{"editor", "subtitle", "titleaddon", "maintitle", "mainsubtitle", "maintitleaddon", "booksubtitle", "booktitleaddon", "eventtitle", "eventtitleaddon", "eventdate", "venue", "language", "volume", "part", "volumes", "series", "number", "organization", "publisher", "location", "chapter", "pages", "isbn"},
+			},
+			"reference": &TagTypes{
+				Required: []string{"editor", "title", "date/year"},
+				Optional: []string{"editora", "editorb", "editorc", "translator", "subtitle", "titleaddon", "maintitle", "language", "volume", "part", "edition", "volumes", "series", "number", "publisher", "location", "pagetotal", "isbn"},
+			},
+			"inreference": &TagTypes{
+				Required: []string{"author", "title", "booktitle", "date/year"},
+				Optional: []string{"editor", "editora", "editorb", "editorc", "translator", "subtitle", "titleaddon", "maintitle", "booksubtitle", "booktitleaddon", "language", "volume", "part", "edition", "volumes", "series", "number", "publisher", "location", "chapter", "pages", "isbn"},
+			},
+			"report": &TagTypes{
+				Required: []string{"author", "title", "type", "institution", "date/year"},
+				Optional: []string{"subtitle", "titleaddon", "language", "number", "version", "location", "chapter", "pages", "pagetotal", "isrn"},
+			},
+			"software": &TagTypes{
+				Required: []string{"author/editor", "title", "date/year"},
+				Optional: []string{"subtitle", "titleaddon", "language", "howpublished", "type", "version", "organization", "location"},
+			},
+			"thesis": &TagTypes{
+				Required: []string{"author", "title", "type", "institution", "date/year"},
+				Optional: []string{"subtitle", "titleaddon", "language", "location", "chapter", "pages", "pagetotal", "isbn"},
+			},
+			"unpublished": &TagTypes{
+				Required: []string{"author", "title", "date/year"},
+				Optional: []string{"subtitle", "titleaddon", "type", "eventtitle", "eventtitleaddon", "eventdate", "venue", "language", "howpublished", "location", "isbn"},
+			},
+			"set": &TagTypes{
+				Required: []string{"entryset"},
+			},
+			"xdata": &TagTypes{},
+		},
+		Aliases: map[string]string{
+			"bookinbook":     "inbook",
+			"suppbook":       "inbook",
+			"suppcollection": "incollection",
+			"suppperiodical": "article",
+			"mvreference":    "reference",
+			"conference":     "inproceedings",
+			"electronic":     "online",
+			"www":            "online",
+			"mastersthesis":  "thesis",
+			"phdthesis":      "thesis",
+			"techreport":     "report",
+		},
+		Common: []string{"abstract", "addendum", "annotation", "crossref", "date", "doi", "entryset", "eprint", "eprintclass", "eprinttype", "execute", "file", "gender", "ids", "indexsorttitle", "indextitle", "isan", "ismn", "iswc", "keywords", "label", "langid", "langidopts", "library", "month", "note", "options", "origdate", "origlocation", "origpublisher", "origtitle", "presort", "pubstate", "related", "relatedoptions", "relatedstring", "relatedtype", "shortauthor", "shorteditor", "shorthand", "shorthandintro", "shortjournal", "shortseries", "shorttitle", "sortkey", "sortname", "sortshorthand", "sorttitle", "sortyear", "url", "urldate", "xdata", "xref", "year"},
+	}
+
+	// bibtexToBibLaTeXTypes maps BibTeX types to BibLaTeX, with the type
+	// tag they imply
+	bibtexToBibLaTeXTypes = map[string][]string{
+		"conference":    {"inproceedings", ""},
+		"mastersthesis": {"thesis", "mathesis"},
+		"masterthesis":  {"thesis", "mathesis"},
+		"phdthesis":     {"thesis", "phdthesis"},
+		"techreport":    {"report", "techreport"},
+	}
+
+	// bibLaTeXToBibTeXTypes maps BibLaTeX types BibTeX lacks to the
+	// closest BibTeX type
+	bibLaTeXToBibTeXTypes = map[string]string{
+		"mvbook":         "book",
+		"bookinbook":     "inbook",
+		"suppbook":       "inbook",
+		"collection":     "book",
+		"mvcollection":   "book",
+		"suppcollection": "incollection",
+		"reference":      "book",
+		"mvreference":    "book",
+		"inreference":    "incollection",
+		"mvproceedings":  "proceedings",
+		"periodical":     "misc",
+		"suppperiodical": "article",
+		"online":         "misc",
+		"electronic":     "misc",
+		"www":            "misc",
+		"dataset":        "misc",
+		"software":       "misc",
+		"patent":         "misc",
+		"report":         "techreport",
+	}
+
+	// bibtexToBibLaTeXTags maps BibTeX tag names to BibLaTeX
+	bibtexToBibLaTeXTags = map[string]string{
+		"journal": "journaltitle",
+		"address": "location",
+		"school":  "institution",
+		"annote":  "annotation",
+	}
+
+	// monthOrder lists the month macros in calendar order
+	monthOrder = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+
+	// isoDate matches the start of a BibLaTeX date, e.g. 2016-03-01/2016-04
+	isoDate = regexp.MustCompile(`^(-?\d{4})(-(\d{2}))?(-\d{2})?`)
+)
+
+// monthNumber returns the two digit number of a raw month value, e.g.
+// jan, "January" or {3}
+func monthNumber(raw string) (string, bool) {
+	val := strings.TrimSpace(raw)
+	if macro, ok := fixMonth(val); ok == true {
+		val = macro
+	} else if _, text, _, ok := singleLiteral(val); ok == true {
+		val = strings.TrimSpace(text)
+	}
+	for i, month := range monthOrder {
+		if strings.EqualFold(val, month) == true {
+			return fmt.Sprintf("%02d", i+1), true
+		}
+	}
+	if isNumber(val) == true && len(val) <= 2 {
+		n := 0
+		fmt.Sscanf(val, "%d", &n)
+		if n >= 1 && n <= 12 {
+			return fmt.Sprintf("%02d", n), true
+		}
+	}
+	return "", false
+}
+
+// literalText returns the text of a raw value that is a number or a
+// single quoted or braced part
+func literalText(raw string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if isNumber(raw) == true {
+		return raw, true
+	}
+	if _, text, _, ok := singleLiteral(raw); ok == true {
+		return strings.TrimSpace(text), true
+	}
+	return "", false
+}
+
+// ToBibLaTeX returns a copy of an element converted to the BibLaTeX data
+// model. Types BibLaTeX names differently are renamed, e.g. phdthesis
+// becomes thesis with type phdthesis, journal becomes journaltitle,
+// school becomes institution, address becomes location and year and
+// month are combined into date. Tags keep their place and values.
+func ToBibLaTeX(element *Element) *Element {
+	elem := Clone(element)
+	if mapping, ok := bibtexToBibLaTeXTypes[elem.Type]; ok == true {
+		elem.setType(mapping[0])
+		if _, ok := elem.Get("type"); ok == false && mapping[1] != "" {
+			elem.Set("type", "{"+mapping[1]+"}")
+		}
+	}
+	for _, field := range elem.Fields() {
+		if name, ok := bibtexToBibLaTeXTags[strings.ToLower(field.Name)]; ok == true {
+			elem.Rename(field.Name, name)
+		}
+	}
+	if _, ok := elem.Get("date"); ok == true {
+		return elem
+	}
+	rawYear, ok := elem.Get("year")
+	if ok == false {
+		return elem
+	}
+	year, ok := literalText(rawYear)
+	if ok == false || len(year) != 4 || isNumber(year) == false {
+		return elem
+	}
+	date := year
+	if rawMonth, ok := elem.Get("month"); ok == true {
+		month, ok := monthNumber(rawMonth)
+		if ok == false {
+			// Keep the year as is rather than lose the month
+			return elem
+		}
+		date = year + "-" + month
+		elem.Delete("month")
+	}
+	elem.Rename("year", "date")
+	elem.Set("date", "{"+date+"}")
+	return elem
+}
+
+// ToBibTeX returns a copy of an element converted to the classic BibTeX
+// data model. Types BibTeX lacks become the closest BibTeX type, e.g.
+// online becomes misc, and date is split into year and month. A date
+// holding more than BibTeX can, e.g. a day or a range, is kept next to
+// year and month so nothing is lost. For the dates ToBibLaTeX writes it
+// undoes ToBibLaTeX.
+func ToBibTeX(element *Element) *Element {
+	elem := Clone(element)
+	rawType, _ := elem.Get("type")
+	thesisType, _ := literalText(rawType)
+	switch {
+	case elem.Type == "thesis" && strings.EqualFold(thesisType, "phdthesis"):
+		elem.setType("phdthesis")
+		elem.Delete("type")
+	case elem.Type == "thesis":
+		elem.setType("mastersthesis")
+		if strings.EqualFold(thesisType, "mathesis") == true {
+			elem.Delete("type")
+		}
+	case elem.Type == "report" && strings.EqualFold(thesisType, "techreport"):
+		elem.setType("techreport")
+		elem.Delete("type")
+	default:
+		if name, ok := bibLaTeXToBibTeXTypes[elem.Type]; ok == true {
+			elem.setType(name)
+		}
+	}
+	for _, field := range elem.Fields() {
+		for bibtexName, biblatexName := range bibtexToBibLaTeXTags {
+			if strings.EqualFold(field.Name, biblatexName) == false {
+				continue
+			}
+			// BibTeX uses institution for reports and school for theses
+			if biblatexName == "institution" && strings.HasSuffix(elem.Type, "thesis") == false {
+				continue
+			}
+			elem.Rename(field.Name, bibtexName)
+		}
+	}
+	rawDate, ok := elem.Get("date")
+	if ok == false {
+		return elem
+	}
+	date, _ := literalText(rawDate)
+	m := isoDate.FindStringSubmatch(date)
+	if m == nil {
+		return elem
+	}
+	if _, ok := elem.Get("year"); ok == true {
+		return elem
+	}
+	month := ""
+	if m[3] != "" {
+		n := 0
+		fmt.Sscanf(m[3], "%d", &n)
+		if n >= 1 && n <= 12 {
+			month = monthOrder[n-1]
+		}
+	}
+	_, hasMonth := elem.Get("month")
+	// Only a year, or a year and month, fits in BibTeX's tags
+	if m[0] == date && m[4] == "" && (m[3] == "" || (month != "" && hasMonth == false)) {
+		elem.Rename("date", "year")
+	}
+	elem.Set("year", m[1])
+	if hasMonth == false && month != "" {
+		elem.Set("month", month)
+	}
+	return elem
+}
@@ -0,0 +1,232 @@
+//
+// biblatex_test.go tests the BibLaTeX schema and conversions
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestBibLaTeXSchema checks BibLaTeX types validate and aliases resolve
+func TestBibLaTeXSchema(t *testing.T) {
+	schema, err := SchemaByName("BibLaTeX")
+	if err != nil || schema != BibLaTeXSchema {
+		t.Errorf("expected the BibLaTeX schema, %v", err)
+		t.FailNow()
+	}
+	if _, err := SchemaByName("mla"); err == nil {
+		t.Errorf("expected an error for an unknown schema")
+	}
+	src := `@online{o1,
+    author = {R. S. Doiel},
+    title = {Turtles online},
+    date = {2016-03},
+    urldate = {2016-04-01}
+}
+
+@software{s1,
+    author = {R. S. Doiel},
+    title = {bibtex},
+    year = 2016,
+    url = {https://github.com/rsdoiel/bibtex},
+    version = {0.0.10}
+}
+
+@phdthesis{p1,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    type = {phdthesis},
+    institution = {Turtle University},
+    date = 2016
+}
+`
+	elements, err := Parse([]byte(src))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	expected := []string{"missing-required:doi/eprint/url", "", ""}
+	for i, element := range elements {
+		if result := findingKinds(element.Validate(BibLaTeXSchema)); result != expected[i] {
+			t.Errorf("%d: expected %q, found %q", i, expected[i], result)
+		}
+	}
+	if findings := elements[1].Validate(BibTeXSchema); len(findings) != 1 || findings[0].Kind != UnknownType {
+		t.Errorf("expected software to be unknown to BibTeX, found %s", findings)
+	}
+	names := strings.Join(BibLaTeXSchema.TypeNames(), ",")
+	for _, name := range []string{"online", "dataset", "software", "mvbook", "patent", "thesis", "phdthesis"} {
+		if strings.Contains(","+names+",", ","+name+",") == false {
+			t.Errorf("expected %s in %s", name, names)
+		}
+	}
+}
+
+// TestToBibLaTeX checks tags and types are converted in place
+func TestToBibLaTeX(t *testing.T) {
+	src := `@article{a1,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    journal = {Turtle Journal},
+    year = 2016,
+    month = mar,
+    address = {Pasadena}
+}
+
+@phdthesis{p1,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    school = {Turtle University},
+    year = "2016"
+}
+`
+	elements, err := Parse([]byte(src))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	expected := `@article{a1,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    journaltitle = {Turtle Journal},
+    date = {2016-03},
+    location = {Pasadena},
+}
+@thesis{p1,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    institution = {Turtle University},
+    date = {2016},
+    type = {phdthesis},
+}
+`
+	var result []string
+	for _, element := range elements {
+		result = append(result, ToBibLaTeX(element).String())
+	}
+	if strings.Join(result, "") != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, strings.Join(result, ""))
+	}
+	if _, ok := elements[0].Get("journal"); ok == false {
+		t.Errorf("expected the original element to be left as is")
+	}
+
+	// and back again
+	for i, element := range elements {
+		back := ToBibTeX(ToBibLaTeX(element))
+		if back.Type != element.Type {
+			t.Errorf("%d: expected type %s, found %s", i, element.Type, back.Type)
+		}
+		for _, name := range []string{"journal", "address", "school", "month"} {
+			before, _ := element.Get(name)
+			after, _ := back.Get(name)
+			if before != after {
+				t.Errorf("%d: expected %s %q, found %q", i, name, before, after)
+			}
+		}
+		if year, _ := back.Get("year"); year != "2016" {
+			t.Errorf("%d: expected year 2016, found %q", i, year)
+		}
+	}
+}
+
+// TestToBibTeX checks BibLaTeX only types and dates are mapped
+func TestToBibTeX(t *testing.T) {
+	elements, err := Parse([]byte(`@online{o1,
+    author = {R. S. Doiel},
+    title = {Turtles online},
+    date = {2016-11-05},
+    url = {https://example.org}
+}
+
+@report{r1,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    type = {techreport},
+    institution = {Turtle Institute},
+    date = {2016/2017}
+}
+
+@article{a1,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    journal = {Turtle Journal},
+    date = {2016-03-01/2016-04}
+}
+
+@article{a2,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    journal = {Turtle Journal},
+    date = {2016-03-01}
+}
+
+@misc{m1,
+    title = {Turtles},
+    date = {2016-04}
+}`))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	expected := `@misc{o1,
+    author = {R. S. Doiel},
+    title = {Turtles online},
+    date = {2016-11-05},
+    url = {https://example.org},
+    year = 2016,
+    month = nov,
+}
+@techreport{r1,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    institution = {Turtle Institute},
+    date = {2016/2017},
+    year = 2016,
+}
+@article{a1,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    journal = {Turtle Journal},
+    date = {2016-03-01/2016-04},
+    year = 2016,
+    month = mar,
+}
+@article{a2,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    journal = {Turtle Journal},
+    date = {2016-03-01},
+    year = 2016,
+    month = mar,
+}
+@misc{m1,
+    title = {Turtles},
+    year = 2016,
+    month = apr,
+}
+`
+	var result string
+	for _, element := range elements {
+		result += ToBibTeX(element).String()
+	}
+	if result != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, result)
+	}
+}
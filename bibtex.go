@@ -23,7 +23,7 @@ import (
 	"bytes"
 	"encoding/xml"
 	"fmt"
-	"sort"
+	"io"
 	"strings"
 
 	// My Library packages
@@ -35,30 +35,47 @@ const (
 	Version = "v0.0.10"
 
 	// DefaultInclude list
-	DefaultInclude = "comment,string,article,book,booklet,inbook,incollection,inproceedings,conference,manual,masterthesis,misc,phdthesis,proceedings,techreport,unpublished"
-
-	// A template for printing an element
-	ElementTmplSrc = `
-@{{- .Type -}}{
-    {{-range .Keys}}
-	{{ . -}},
-	{{end}}
-	{{-range $key, $val := .Tags}}
-		{{- $key -}} = {{- $val -}},
-	{{end}}
+	DefaultInclude = "comment,preamble,string,article,book,booklet,inbook,incollection,inproceedings,conference,manual,mastersthesis,masterthesis,misc,phdthesis,proceedings,techreport,unpublished"
+
+	// ElementTmplSrc is a template for printing an element with an Encoder,
+	// it renders the same layout as the Encoder's default.
+	ElementTmplSrc = `@{{ .Type }}{{ "{" }}{{ .Key }}
+{{- if .Key }},{{ end }}
+{{ range .Fields }}{{ $.Indent }}{{ .Name }} = {{ .Value }},
+{{ end -}}
+{{ range .Malformed }}{{ $.Indent }}{{ . }},
+{{ end -}}
 }
 `
 )
 
-// Generic Element
+// Generic Element, see MarshalJSON for its JSON form
 type Element struct {
-	XMLName xml.Name          `json:"-"`
-	Type    string            `xml:"type" json:"type"`
-	Keys    []string          `xml:"keys" json:"keys"`
-	Tags    map[string]string `xml:"tags" json:"tags"`
+	XMLName xml.Name
+	// Type is the entry type in lower case, e.g. article
+	Type string `xml:"type"`
+	// CiteKey is the citation key, the bare word in the first position
+	CiteKey string `xml:"citekey"`
+	// Keys holds every bare word found in the entry, the CiteKey first if
+	// there is one. Any others are malformed tags, see Malformed.
+	Keys []string `xml:"keys"`
+	// Tags maps lower case tag names to their raw values, see Fields
+	Tags map[string]string `xml:"tags"`
+	// Resolved holds tag values with macros expanded, see Resolve
+	Resolved map[string]string `xml:"resolved"`
+
+	// fields holds the tags in the order they were parsed, see Fields
+	fields []*Field
+	// spelledType is the element type as written in the source
+	spelledType string
+	// filename and pos locate the element in its source
+	filename string
+	pos      position
 }
 type Elements []*Element
 
+// TagTypes lists the tags of an entry type. A required tag may name
+// alternatives separated by a slash, e.g. "author/editor" needs either.
 type TagTypes struct {
 	Required []string
 	Optional []string
@@ -66,93 +83,83 @@ type TagTypes struct {
 
 // Entry types
 var (
-	elementTypes = &map[string]*TagTypes{
-		"article": &TagTypes{
-			Required: []string{"author", "title", "journal", "year", "volume"},
-			Optional: []string{"number", "pages", "month", "note"},
-		},
-		"book": &TagTypes{
-			Required: []string{"author", "editor", "title", "publisher", "year"},
-			Optional: []string{"volume", "number", "series", "address", "edition", "month", "note"},
-		},
-		"booklet": &TagTypes{
-			Required: []string{"Title"},
-			Optional: []string{"author", "howpublished", "address", "month", "year", "note"},
-		},
-		"inbook": &TagTypes{
-			Required: []string{"author", "editor", "title", "chapter", "pages", "publisher", "year"},
-			Optional: []string{"volume", "number", "series", "type", "address", "edition", "month", "note"},
-		},
-		"incollection": &TagTypes{
-			Required: []string{"author", "title", "booktitle", "publisher", "year"},
-			Optional: []string{"editor", "volume", "number", "series", "type", "chapter", "pages", "address", "edition", "month", "note"},
-		},
-		"inproceedings": &TagTypes{
-			Required: []string{"author", "title", "booktitle", "year"},
-			Optional: []string{"editor", "volume", "number", "series", "pages", "address", "month", "organization", "publisher", "note"},
-		},
-		"conference": &TagTypes{
-			Required: []string{"author", "title", "booktitle", "year"},
-			Optional: []string{"editor", "volume", "number", "series", "pages", "address", "month", "organization", "publisher", "note"},
-		},
-		"manual": &TagTypes{
-			Required: []string{"title"},
-			Optional: []string{"author", "organization", "address", "edition", "month", "year", "note"},
-		},
-		"masterthesis": &TagTypes{
-			Required: []string{"author", "title", "school", "year"},
-			Optional: []string{"type", "address", "month", "note"},
-		},
-		"misc": &TagTypes{
-			Required: []string{},
-			Optional: []string{"author", "title", "howpublished", "month", "year", "note"},
-		},
-		"phdthesis": &TagTypes{
-			Required: []string{"author", "title", "school", "year"},
-			Optional: []string{"type", "address", "month", "note"},
-		},
-		"proceedings": &TagTypes{
-			Required: []string{"title", "year"},
-			Optional: []string{"editor", "volume", "series", "address", "month", "publisher", "organization", "note"},
-		},
-		"techreport": &TagTypes{
-			Required: []string{"author", "title", "institution", "year"},
-			Optional: []string{"type", "number", "address", "month", "note"},
-		},
-		"unpublished": &TagTypes{
-			Required: []string{"author", "title", "note"},
-			Optional: []string{"month", "year"},
+	// BibTeXSchema holds the entry types of classic BibTeX
+	BibTeXSchema = &Schema{
+		Name: "bibtex",
+		Types: map[string]*TagTypes{
+			"article": &TagTypes{
+				Required: []string{"author", "title", "journal", "year"},
+				Optional: []string{"volume", "number", "pages", "month", "note"},
+			},
+			"book": &TagTypes{
+				Required: []string{"author/editor", "title", "publisher", "year"},
+				Optional: []string{"volume", "number", "series", "address", "edition", "month", "note"},
+			},
+			"booklet": &TagTypes{
+				Required: []string{"title"},
+				Optional: []string{"author", "howpublished", "address", "month", "year", "note"},
+			},
+			"inbook": &TagTypes{
+				Required: []string{"author/editor", "title", "chapter/pages", "publisher", "year"},
+				Optional: []string{"volume", "number", "series", "type", "address", "edition", "month", "note"},
+			},
+			"incollection": &TagTypes{
+				Required: []string{"author", "title", "booktitle", "publisher", "year"},
+				Optional: []string{"editor", "volume", "number", "series", "type", "chapter", "pages", "address", "edition", "month", "note"},
+			},
+			"inproceedings": &TagTypes{
+				Required: []string{"author", "title", "booktitle", "year"},
+				Optional: []string{"editor", "volume", "number", "series", "pages", "address", "month", "organization", "publisher", "note"},
+			},
+			"conference": &TagTypes{
+				Required: []string{"author", "title", "booktitle", "year"},
+				Optional: []string{"editor", "volume", "number", "series", "pages", "address", "month", "organization", "publisher", "note"},
+			},
+			"manual": &TagTypes{
+				Required: []string{"title"},
+				Optional: []string{"author", "organization", "address", "edition", "month", "year", "note"},
+			},
+			"mastersthesis": &TagTypes{
+				Required: []string{"author", "title", "school", "year"},
+				Optional: []string{"type", "address", "month", "note"},
+			},
+			// masterthesis is kept as earlier releases spelled it this way
+			"masterthesis": &TagTypes{
+				Required: []string{"author", "title", "school", "year"},
+				Optional: []string{"type", "address", "month", "note"},
+			},
+			"misc": &TagTypes{
+				Required: []string{},
+				Optional: []string{"author", "title", "howpublished", "month", "year", "note"},
+			},
+			"phdthesis": &TagTypes{
+				Required: []string{"author", "title", "school", "year"},
+				Optional: []string{"type", "address", "month", "note"},
+			},
+			"proceedings": &TagTypes{
+				Required: []string{"title", "year"},
+				Optional: []string{"editor", "volume", "series", "address", "month", "publisher", "organization", "note"},
+			},
+			"techreport": &TagTypes{
+				Required: []string{"author", "title", "institution", "year"},
+				Optional: []string{"type", "number", "address", "month", "note"},
+			},
+			"unpublished": &TagTypes{
+				Required: []string{"author", "title", "note"},
+				Optional: []string{"month", "year"},
+			},
 		},
+		Common: []string{"crossref", "key", "annote", "abstract", "keywords", "doi", "isbn", "issn", "url"},
 	}
+
+	// DefaultSchema is used by Validate and the Encoder when no other
+	// schema is given, it may be replaced
+	DefaultSchema = BibTeXSchema
 )
 
-// Render a single BibTeX element
+// Render a single BibTeX element, tags are rendered in the order they were parsed
 func (element *Element) String() string {
-	var out []string
-
-	out = append(out, fmt.Sprintf("@%s{", element.Type))
-	if len(element.Keys) > 0 {
-		for i, ky := range element.Keys {
-			if len(ky) > 0 {
-				if i == 0 {
-					out = append(out, fmt.Sprintf("%s,\n", ky))
-				} else {
-					out = append(out, fmt.Sprintf("    %s,\n", ky))
-				}
-			}
-		}
-	} else {
-		out = append(out, "\n")
-	}
-
-	if len(element.Tags) > 0 {
-		for ky, val := range element.Tags {
-			out = append(out, fmt.Sprintf("    %s = %s,\n", ky, val))
-		}
-	}
-
-	out = append(out, fmt.Sprintf("}\n"))
-	return strings.Join(out, "")
+	return NewEncoder(nil).render(element)
 }
 
 //
@@ -202,39 +209,106 @@ func Bib(token *tok.Token, buf []byte) (*tok.Token, []byte) {
 	return token, buf
 }
 
-func mkElement(elementType string, buf []byte) (*Element, error) {
+// mkSyntaxError reports a problem at the start of buf, the unparsed
+// remainder of src. Offset is relative to the start of src.
+func mkSyntaxError(element *Element, src []byte, buf []byte, msg string) *SyntaxError {
+	err := new(SyntaxError)
+	err.Type = element.typeName()
+	err.Key = element.CiteKey
+	err.Offset = len(src) - len(buf)
+	pos := startPosition.advance(src[:err.Offset])
+	err.Line, err.Column = pos.line, pos.column
+	err.Snippet = mkSnippet(buf)
+	err.Msg = msg
+	return err
+}
+
+// betweenQuotes reads a double quoted value returning its text and the
+// rest of buf. Quotes in curly brackets, e.g. "G{\"o}del", don't end it.
+func betweenQuotes(buf []byte) ([]byte, []byte, error) {
+	depth := 0
+	for i := 1; i < len(buf); i++ {
+		switch buf[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth > 0 {
+				depth--
+			}
+		case '"':
+			if depth == 0 {
+				return buf[1:i], buf[i+1:], nil
+			}
+		}
+	}
+	return nil, buf, fmt.Errorf("missing closing double quote")
+}
+
+// mkElement parses the source of an entry between its curly brackets. Bare
+// words after the first position are returned as warnings, their offsets
+// are relative to the start of buf.
+func mkElement(elementType string, buf []byte) (*Element, []*SyntaxError, error) {
 	var (
-		key     []byte
-		val     []byte
-		between []byte
-		token   *tok.Token
-		err     error
-		keys    []string
-		tags    map[string]string
+		src      = buf
+		key      []byte
+		val      []byte
+		valStart int
+		segment  int
+		between  []byte
+		token    *tok.Token
+		err      error
+		keyStart int
+		keys     []string
+		warnings []*SyntaxError
 	)
 
 	element := new(Element)
-	element.Type = elementType
-	tags = make(map[string]string)
+	element.setType(elementType)
+
+	// saveTag adds a name = value pair, a repeated name is reported
+	saveTag := func() {
+		if _, ok := element.tagKey(string(key)); ok == true {
+			warning := mkSyntaxError(element, src, src[keyStart:], fmt.Sprintf("duplicate tag %q", key))
+			warning.Snippet = string(key)
+			warnings = append(warnings, warning)
+		}
+		element.addField(string(key), string(val))
+	}
+
+	// saveKey keeps a bare word, only the first position holds a citation key
+	saveKey := func() {
+		if segment == 0 {
+			element.CiteKey = string(val)
+		} else {
+			warning := mkSyntaxError(element, src, src[valStart:], fmt.Sprintf("malformed tag %q, expected name = value", val))
+			warning.Snippet = string(val)
+			warnings = append(warnings, warning)
+		}
+		keys = append(keys, string(val))
+	}
 
 	for {
 		if len(buf) == 0 {
 			if len(key) > 0 {
 				// We have a trailing key/value pair to save.
-				tags[string(key)] = string(val)
+				saveTag()
 			} else if len(val) > 0 {
 				// We have a trailing key to save.
-				keys = append(keys, string(val))
+				saveKey()
 			}
 			break
 		}
 		_, token, buf = tok.Skip2(tok.Space, buf, Bib)
+		if len(val) == 0 {
+			valStart = len(src) - len(buf) - len(token.Value)
+		}
 		switch {
 		case token.Type == tok.OpenCurlyBracket:
 			buf = tok.Backup(token, buf)
+			rest := buf
 			between, buf, err = tok.Between([]byte("{"), []byte("}"), []byte(""), buf)
 			if err != nil {
-				return element, err
+				return element, warnings, mkSyntaxError(element, src, rest, "value is missing a closing curly bracket")
 			}
 			// Non-destructively copy the quote into val
 			val = append(val, []byte("{")[0])
@@ -242,9 +316,10 @@ func mkElement(elementType string, buf []byte) (*Element, error) {
 			val = append(val, []byte("}")[0])
 		case token.Type == tok.DoubleQuote:
 			buf = tok.Backup(token, buf)
-			between, buf, err = tok.Between([]byte("\""), []byte("\""), []byte(""), buf)
+			rest := buf
+			between, buf, err = betweenQuotes(buf)
 			if err != nil {
-				return element, err
+				return element, warnings, mkSyntaxError(element, src, rest, "value is missing a closing double quote")
 			}
 			// Non-destructively copy the quote into val
 			val = append(val, []byte("\"")[0])
@@ -252,17 +327,23 @@ func mkElement(elementType string, buf []byte) (*Element, error) {
 			val = append(val, []byte("\"")[0])
 		case token.Type == tok.EqualSign:
 			key = val
+			keyStart = valStart
 			val = nil
 		case token.Type == "Comma" || len(buf) == 0:
+			if token.Type != "Comma" && token.Type != tok.Space {
+				// The last token of the entry is part of the value
+				val = append(val[:], token.Value[:]...)
+			}
 			if len(key) > 0 {
 				//make a map entry
-				tags[string(key)] = string(val)
+				saveTag()
 			} else if len(val) > 0 {
 				// append to element keys
-				keys = append(keys, string(val))
+				saveKey()
 			}
 			key = nil
 			val = nil
+			segment++
 		case token.Type == tok.Punctuation && bytes.Equal(token.Value, []byte("#")):
 			val = append(val[:], []byte(" # ")[:]...)
 		default:
@@ -272,58 +353,41 @@ func mkElement(elementType string, buf []byte) (*Element, error) {
 	if len(keys) > 0 {
 		element.Keys = keys
 	}
-	if len(tags) > 0 {
-		element.Tags = tags
-	}
-	return element, nil
+	return element, warnings, nil
 }
 
 // Parse a BibTeX file into appropriate structures
 func Parse(buf []byte) ([]*Element, error) {
+	return ParseWithOptions(buf, Options{})
+}
+
+// ParseWithOptions parses a BibTeX file with the options given. When
+// opts.Recover is set malformed entries are skipped, every good element
+// is returned and any problems are returned as an ErrorList.
+func ParseWithOptions(buf []byte, opts Options) ([]*Element, error) {
 	var (
-		lineNo      int
-		token       *tok.Token
-		elements    []*Element
-		err         error
-		skipped     []byte
-		entrySource []byte
-		LF          = []byte("\n")
+		element  *Element
+		elements []*Element
+		err      error
 	)
-	lineNo = 1
+	dec := NewDecoder(bytes.NewReader(buf))
+	dec.Options = opts
 	for {
-		if len(buf) == 0 {
+		element, err = dec.Decode()
+		if err == io.EOF {
 			break
 		}
-		skipped, token, buf = tok.Skip2(tok.Space, buf, Bib)
-		lineNo = lineNo + bytes.Count(skipped, LF)
-		if token.Type == tok.AtSign {
-			// We may have a entry key
-			token, buf = tok.Tok2(buf, Bib)
-			if token.Type == "AlphaNumeric" {
-				elementType := token.Value[:]
-				skipped, token, buf = tok.Skip2(tok.Space, buf, Bib)
-				lineNo = lineNo + bytes.Count(skipped, LF)
-				if token.Type == tok.OpenCurlyBracket {
-					// Ok it looks like we have a Bib entry now.
-					buf = tok.Backup(token, buf)
-					entrySource, buf, err = tok.Between([]byte("{"), []byte("}"), []byte(""), buf)
-					if err != nil {
-						return elements, fmt.Errorf("Problem parsing entry at %d", lineNo)
-					}
-					// OK, we have an entry, let's process it.
-					element, err := mkElement(string(elementType), entrySource)
-					if err != nil {
-						return elements, fmt.Errorf("Error parsing element at %d, %s", lineNo, err)
-					}
-					lineNo = lineNo + bytes.Count(entrySource, LF)
-					// OK, we have an element, let's append to our array...
-					elements = append(elements, element)
-				}
-			}
+		if err != nil {
+			return elements, err
 		}
+		// OK, we have an element, let's append to our array...
+		elements = append(elements, element)
+	}
+	if errList := dec.Errors(); len(errList) > 0 {
+		return elements, errList
 	}
 	if len(elements) == 0 {
-		err = fmt.Errorf("no elements found")
+		return elements, fmt.Errorf("no elements found")
 	}
 	return elements, nil
 }
@@ -363,29 +427,20 @@ func compareTagValues(val1, val2 string) bool {
 
 // Equal compares two Element structures and sees if the contents agree
 func Equal(elem1, elem2 *Element) bool {
-	if strings.Compare(elem1.Type, elem2.Type) != 0 {
+	if strings.EqualFold(elem1.Type, elem2.Type) == false {
 		return false
 	}
-	// We have differing number of keys or Tags then we're not equal
-	if len(elem1.Keys) != len(elem2.Keys) || len(elem1.Tags) != len(elem2.Tags) {
+	// The citation key is the element's identity
+	if strings.Compare(elem1.CiteKey, elem2.CiteKey) != 0 {
 		return false
 	}
-
-	// Sort and compare the keys
-	keys1 := elem1.Keys[0:]
-	keys2 := elem2.Keys[0:]
-	sort.Sort(ByKey(keys1))
-	sort.Sort(ByKey(keys2))
-
-	// We have to find
-	for i, ky := range keys1 {
-		if strings.Compare(keys2[i], ky) != 0 {
-			return false
-		}
+	// We have differing number of Tags then we're not equal
+	if len(elem1.Tags) != len(elem2.Tags) {
+		return false
 	}
 
 	for ky, val1 := range elem1.Tags {
-		if val2, ok := elem2.Tags[ky]; ok != true {
+		if val2, ok := elem2.Get(ky); ok != true {
 			return false
 		} else if compareTagValues(val1, val2) == false {
 			return false
@@ -395,6 +450,35 @@ func Equal(elem1, elem2 *Element) bool {
 	return true
 }
 
+// Malformed returns the bare words found after the first position, BibTeX
+// expects a name = value pair there
+func (element *Element) Malformed() []string {
+	var malformed []string
+	for i, ky := range element.Keys {
+		if i == 0 && ky == element.CiteKey {
+			continue
+		}
+		malformed = append(malformed, ky)
+	}
+	return malformed
+}
+
+// setType sets the element type, Type is normalized to lower case and
+// the spelling is kept for output
+func (element *Element) setType(elementType string) {
+	element.Type = strings.ToLower(elementType)
+	element.spelledType = elementType
+}
+
+// typeName returns the element type as it was written unless Type has
+// since been changed
+func (element *Element) typeName() string {
+	if strings.EqualFold(element.spelledType, element.Type) == true {
+		return element.spelledType
+	}
+	return element.Type
+}
+
 // NotEqual compares two element structures and see if the contents disagree
 func NotEqual(elem1, elem2 *Element) bool {
 	return Equal(elem1, elem2) == false
@@ -405,6 +489,8 @@ func Clone(elem *Element) *Element {
 	newElem := new(Element)
 	newElem.XMLName = elem.XMLName
 	newElem.Type = elem.Type
+	newElem.spelledType = elem.spelledType
+	newElem.CiteKey = elem.CiteKey
 	newElem.Tags = make(map[string]string)
 	for _, ky := range elem.Keys {
 		newElem.Keys = append(newElem.Keys, ky)
@@ -412,6 +498,17 @@ func Clone(elem *Element) *Element {
 	for ky, val := range elem.Tags {
 		newElem.Tags[ky] = val
 	}
+	if elem.Resolved != nil {
+		newElem.Resolved = make(map[string]string)
+		for ky, val := range elem.Resolved {
+			newElem.Resolved[ky] = val
+		}
+	}
+	for _, field := range elem.fields {
+		newElem.fields = append(newElem.fields, &Field{Name: field.Name, Value: field.Value})
+	}
+	newElem.filename = elem.filename
+	newElem.pos = elem.pos
 	return newElem
 }
 
@@ -425,43 +522,27 @@ func Contains(elemList []*Element, target *Element) bool {
 	return false
 }
 
-// Join create a new Element array by combining to Element arrays without creating duplicate entries
+// The set operations below never change the lists or elements passed in,
+// the elements returned keep the order of the lists they came from.
+
+// Join create a new Element array by combining to Element arrays without creating duplicate entries.
+// The elements of elemList1 come first followed by those only in elemList2.
 func Join(elemList1, elemList2 []*Element) []*Element {
-	var result []*Element
-	result = elemList1[0:]
-	for _, elem := range elemList2 {
-		if Contains(result, elem) == false {
-			result = append(result, elem)
-		}
-	}
-	return result
+	return JoinBy(ExactMatch, elemList1, elemList2)
 }
 
 // Diff creates a new Element Array of all the elements in elemList1 an not in elemList2
 func Diff(elemList1, elemList2 []*Element) []*Element {
-	var result []*Element
-	for _, elem := range elemList1 {
-		if Contains(elemList2, elem) == false {
-			result = append(result, elem)
-		}
-	}
-	return result
+	return DiffBy(ExactMatch, elemList1, elemList2)
 }
 
 // Intersect create a new Element Array of elements in both elemList1 and elemList2
 func Intersect(elemList1, elemList2 []*Element) []*Element {
-	var result []*Element
-	for _, elem := range elemList1 {
-		if Contains(elemList2, elem) == true {
-			result = append(result, elem)
-		}
-	}
-	return result
+	return IntersectBy(ExactMatch, elemList1, elemList2)
 }
 
-// Exclusive create a new Element Array with elements that only exist in elemList1 or elemList2
+// Exclusive create a new Element Array with elements that only exist in elemList1 or elemList2.
+// Those from elemList1 come first.
 func Exclusive(elemList1, elemList2 []*Element) []*Element {
-	A := Diff(elemList1, elemList2)
-	B := Diff(elemList2, elemList1)
-	return Join(A, B)
+	return ExclusiveBy(ExactMatch, elemList1, elemList2)
 }
@@ -22,9 +22,12 @@ package bibtex
 import (
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"path"
+	"reflect"
 	"strings"
 	"testing"
+	"testing/quick"
 
 	// My Library packages
 	"github.com/rsdoiel/tok"
@@ -75,9 +78,10 @@ func TestParse(t *testing.T) {
 		t.Errorf("%s", err)
 		t.FailNow()
 	}
-	expectedTypes := []string{"comment", "string", "misc", "article", "article"}
+	// @comment entries are returned by ParseNodes, not as elements
+	expectedTypes := []string{"string", "misc", "article", "article"}
 	if len(elements) != len(expectedTypes) {
-		t.Errorf("Expected 5 elements: %s\n", elements)
+		t.Errorf("Expected 4 elements: %s\n", elements)
 		t.FailNow()
 	}
 	for i, element := range elements {
@@ -88,26 +92,38 @@ func TestParse(t *testing.T) {
 		if element.Type != expectedTypes[i] {
 			t.Errorf("expected %s, found %s", expectedTypes[i], element.Type)
 		}
-		if element.Type == "comment" {
-			if len(element.Keys) != 3 {
-				t.Errorf("Expected 3 keys in comment entry: %s", element)
-			}
-			if strings.HasPrefix(element.Keys[0], "\"") == true {
-				t.Errorf("Expected first element to be a key: [%s]", element.Keys[0])
-			}
-			if strings.HasPrefix(element.Keys[1], "\"") == false {
-				t.Errorf("Expected second elements to be quoted strings: [%s] [%s]", element.Keys[1], element)
-			}
-			if strings.HasPrefix(element.Keys[2], "\"") == false {
-				t.Errorf("Expected third element to be quoted strings: [%s] [%s]", element.Keys[2], element)
-			}
-		} else if len(element.Tags) == 0 {
+		if len(element.Tags) == 0 {
 			t.Errorf("Expected tags in element: [%s]", element)
 		}
 
 	}
 }
 
+// TestCiteKey checks only the first bare word is taken as the citation key
+func TestCiteKey(t *testing.T) {
+	fname := path.Join("testdata", "sample1.bib")
+	src, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	elements, err := Parse(src)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	expectedKeys := []string{"", "id1", "a3", ""}
+	expectedMalformed := []string{"", "", "id3", "norweignwood,id4"}
+	for i, element := range elements {
+		if element.CiteKey != expectedKeys[i] {
+			t.Errorf("%d: expected citation key %q, found %q", i, expectedKeys[i], element.CiteKey)
+		}
+		if malformed := strings.Join(element.Malformed(), ","); malformed != expectedMalformed[i] {
+			t.Errorf("%d: expected malformed %q, found %q", i, expectedMalformed[i], malformed)
+		}
+	}
+}
+
 // TestEquality tests Equal() and NotEqual()
 func TestEquality(t *testing.T) {
 	bib1 := new(Element)
@@ -135,6 +151,12 @@ func TestEquality(t *testing.T) {
 	isTrue(NotEqual(bib1, bib2), "bib1 has an author field now", true)
 	bib2.Tags["author"] = "R. S. Doiel"
 	isTrue(Equal(bib1, bib2), "bib2 should have an author field now", true)
+
+	bib1.CiteKey = "doiel2016"
+	isTrue(NotEqual(bib1, bib2), "bib1 has a citation key now", true)
+	bib2.CiteKey = "doiel2016"
+	bib2.Keys = []string{"doiel2016", "stray"}
+	isTrue(Equal(bib1, bib2), "stray words should not change the identity", true)
 }
 
 // TestContains see if an Element is contained in an array of Elements
@@ -164,8 +186,8 @@ func TestContains(t *testing.T) {
 
 	bibList, err := Parse(src)
 	noError(err, true)
-	elem := Clone(bibList[3])
-	isTrue(Contains(bibList, elem), fmt.Sprintf("Should find bibList[3] with Contains, %s, %s", bibList, elem), true)
+	elem := Clone(bibList[2])
+	isTrue(Contains(bibList, elem), fmt.Sprintf("Should find bibList[2] with Contains, %s, %s", bibList, elem), true)
 	elem.Type = "misc"
 	isTrue(Contains(bibList, elem) == false, fmt.Sprintf("Should not find  a modified element with Contains, %s, %s", bibList, elem), true)
 }
@@ -335,7 +357,8 @@ func TestExclusive(t *testing.T) {
 	noError(err, true)
 
 	/*
-	   Should not get a missing @book like:
+	   Should get the missing @book, the @comment in sample3b.bib
+	   is not an element so is not part of the set:
 
 	   @comment{
 	   	    id0,
@@ -344,5 +367,126 @@ func TestExclusive(t *testing.T) {
 	   }
 	*/
 	elemList3 = Exclusive(elemList1, elemList2)
-	isTrue(len(elemList3) == 2, fmt.Sprintf("Exclusive (A xor B) should be len 2 -\n%s\n\n%s\n\n%s\n", elemList1, elemList2, elemList3), true)
+	isTrue(len(elemList3) == 1, fmt.Sprintf("Exclusive (A xor B) should be len 1 -\n%s\n\n%s\n\n%s\n", elemList1, elemList2, elemList3), true)
+	isTrue(elemList3[0].Type == "book", fmt.Sprintf("Exclusive (A xor B) should be the @book - %s", elemList3), true)
+}
+
+// elementList is a random list drawn from a small pool so lists overlap,
+// repeat elements and hold Equal copies that differ in delimiters
+type elementList []*Element
+
+// elementPool is shared by the generated lists
+var elementPool = mkElements(0, 12)
+
+// Generate makes a random elementList for testing/quick
+func (elementList) Generate(r *rand.Rand, size int) reflect.Value {
+	n := r.Intn(size + 1)
+	// Leave spare capacity so appending in place would show up
+	list := make(elementList, n, n+r.Intn(4))
+	for i := range list {
+		elem := elementPool[r.Intn(len(elementPool))]
+		if r.Intn(4) == 0 {
+			elem = Clone(elem)
+			title, _ := elem.Get("title")
+			elem.Set("title", "\""+title[1:len(title)-1]+"\"")
+		}
+		list[i] = elem
+	}
+	return reflect.ValueOf(list)
+}
+
+// snapshot records a list, its spare capacity and its elements as text
+func snapshot(list []*Element) string {
+	var out []string
+	for _, elem := range list[:cap(list)] {
+		if elem == nil {
+			out = append(out, "<nil>")
+		} else {
+			out = append(out, fmt.Sprintf("%p %s", elem, elem))
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// equalLists compares two lists element by element using Equal
+func equalLists(a []*Element, b []*Element) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if Equal(a[i], b[i]) == false {
+			return false
+		}
+	}
+	return true
+}
+
+// isSubsequence checks the elements of a appear in b in the same order
+func isSubsequence(a []*Element, b []*Element) bool {
+	j := 0
+	for _, elem := range a {
+		for j < len(b) && b[j] != elem {
+			j++
+		}
+		if j == len(b) {
+			return false
+		}
+		j++
+	}
+	return true
+}
+
+// TestSetAlgebra checks properties of the set operations over random lists
+func TestSetAlgebra(t *testing.T) {
+	properties := map[string]func(a elementList, b elementList) bool{
+		"Exclusive(A,B) == Diff(A∪B, A∩B)": func(a elementList, b elementList) bool {
+			return equalLists(Exclusive(a, b), Diff(Join(a, b), Intersect(a, b)))
+		},
+		"A and B are left untouched": func(a elementList, b elementList) bool {
+			before := snapshot(a) + snapshot(b)
+			Join(a, b)
+			Diff(a, b)
+			Intersect(a, b)
+			Exclusive(a, b)
+			for i := range a {
+				for j := range b {
+					Equal(a[i], b[j])
+				}
+			}
+			return snapshot(a)+snapshot(b) == before
+		},
+		"A∪B starts with A": func(a elementList, b elementList) bool {
+			result := Join(a, b)
+			return equalLists(result[:len(a)], a) && isSubsequence(result[len(a):], b)
+		},
+		"A∪A == A": func(a elementList, b elementList) bool {
+			return equalLists(Join(a, a), a)
+		},
+		"A\\B and A∩B split A in order": func(a elementList, b elementList) bool {
+			diff, intersect := Diff(a, b), Intersect(a, b)
+			return len(diff)+len(intersect) == len(a) && isSubsequence(diff, a) && isSubsequence(intersect, a)
+		},
+		"A∩B is in B and A\\B is not": func(a elementList, b elementList) bool {
+			for _, elem := range Intersect(a, b) {
+				if Contains(b, elem) == false {
+					return false
+				}
+			}
+			for _, elem := range Diff(a, b) {
+				if Contains(b, elem) == true {
+					return false
+				}
+			}
+			return true
+		},
+		"Exclusive is symmetric as a set": func(a elementList, b elementList) bool {
+			ab, ba := Exclusive(a, b), Exclusive(b, a)
+			return len(Diff(ab, ba)) == 0 && len(Diff(ba, ab)) == 0
+		},
+	}
+	for name, property := range properties {
+		if err := quick.Check(property, &quick.Config{MaxCount: 200}); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
 }
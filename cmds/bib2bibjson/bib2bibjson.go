@@ -0,0 +1,168 @@
+//
+// bib2bibjson converts BibTeX to a BibJSON collection.
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S.Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	// My package
+	"github.com/rsdoiel/bibtex"
+)
+
+var (
+	showHelp    bool
+	showVersion bool
+	showLicense bool
+
+	recoverErrors  = false
+	textName       = "asis"
+	collectionName = ""
+)
+
+func init() {
+	flag.BoolVar(&showHelp, "h", false, "display help information")
+	flag.BoolVar(&showVersion, "v", false, "display version information")
+	flag.BoolVar(&showLicense, "l", false, "display license")
+
+	flag.BoolVar(&recoverErrors, "recover", recoverErrors, "skip malformed entries reporting them on stderr")
+	flag.StringVar(&textName, "text", textName, "convert values to: asis or unicode")
+	flag.StringVar(&collectionName, "collection", collectionName, "name the collection in its metadata")
+}
+
+func main() {
+	appname := path.Base(os.Args[0])
+	flag.Parse()
+
+	if showHelp == true {
+		fmt.Printf(`
+ USAGE: %s [OPTION] [BIBFILE] [OUTFILE]
+
+ Converts a BibTeX file, or standard input, to a BibJSON collection.
+ @string macros are expanded, authors and editors become lists of
+ people, DOI, ISBN and the like a list of identifiers and url a link.
+ See http://okfnlabs.org/bibjson/ for BibJSON.
+
+ OPTIONS:
+
+`, appname)
+
+		flag.VisitAll(func(f *flag.Flag) {
+			fmt.Printf("    -%s %s\n", f.Name, f.Usage)
+		})
+
+		fmt.Printf("\n\n Version %s\n", bibtex.Version)
+		os.Exit(0)
+	}
+
+	if showVersion == true {
+		fmt.Printf(" Version %s\n", bibtex.Version)
+		os.Exit(0)
+	}
+
+	if showLicense == true {
+		fmt.Printf(`
+ %s
+ 
+ copyright (c) 2016, R. S. Doiel
+ All rights reserved.
+
+ Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+ 
+ 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+ 
+ 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+ 
+ 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+ 
+ THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+`, appname)
+		os.Exit(0)
+	}
+
+	in := os.Stdin
+	out := os.Stdout
+
+	args := flag.Args()
+	if len(args) > 0 {
+		fname := args[0]
+		args = args[1:]
+		f, err := os.Open(fname)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s, %s\n", fname, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	}
+	if len(args) > 0 {
+		fname := args[0]
+		f, err := os.Create(fname)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s, %s\n", fname, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	opts := bibtex.Options{Recover: recoverErrors}
+	if in != os.Stdin {
+		opts.Filename = in.Name()
+	}
+	switch textName {
+	case "asis":
+		opts.Text = bibtex.TextAsIs
+	case "unicode":
+		opts.Text = bibtex.TextUnicode
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown text conversion %q, try %s -h for details\n", textName, appname)
+		os.Exit(1)
+	}
+	elements, err := bibtex.ParseWithOptions(src, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		if recoverErrors == false {
+			os.Exit(1)
+		}
+	}
+	if _, err := bibtex.Resolve(elements); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	collection := bibtex.ToBibJSON(elements)
+	if collectionName != "" {
+		collection.Metadata = &bibtex.BibJSONMetadata{Collection: collectionName, Records: len(collection.Records)}
+	}
+	data, err := json.MarshalIndent(collection, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(out, "%s\n", data)
+}
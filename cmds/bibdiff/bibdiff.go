@@ -0,0 +1,225 @@
+//
+// bibdiff compares two bibliographies entry by entry, showing what was added, removed or changed.
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S.Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	// My package
+	"github.com/rsdoiel/bibtex"
+)
+
+const (
+	// Exit codes, as diff's
+	exitSame    = 0
+	exitDiffer  = 1
+	exitFailure = 2
+
+	// ANSI terminal colors
+	colorReset = "\033[0m"
+	colorBold  = "\033[1m"
+	colorRed   = "\033[31m"
+	colorGreen = "\033[32m"
+	colorCyan  = "\033[36m"
+)
+
+var (
+	showHelp    bool
+	showVersion bool
+	showLicense bool
+
+	matchName = "key"
+	asJSON    = false
+	colorWhen = "auto"
+)
+
+func init() {
+	flag.BoolVar(&showHelp, "h", false, "display help information")
+	flag.BoolVar(&showVersion, "v", false, "display version information")
+	flag.BoolVar(&showLicense, "l", false, "display license")
+
+	flag.StringVar(&matchName, "match", matchName, "match entries by: key (citation key), id (DOI or ISBN), title (title, year and first author) or exact")
+	flag.BoolVar(&asJSON, "json", asJSON, "write the differences as JSON")
+	flag.StringVar(&colorWhen, "color", colorWhen, "color the diff: auto (when writing to a terminal), always or never")
+}
+
+// readBib reads and parses a BibTeX file
+func readBib(fname string) ([]*bibtex.Element, error) {
+	src, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read %s, %s", fname, err)
+	}
+	elements, err := bibtex.Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("Can't parse %s, %s", fname, err)
+	}
+	return elements, nil
+}
+
+// useColor decides if the diff is colored
+func useColor() (bool, error) {
+	switch colorWhen {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		info, err := os.Stdout.Stat()
+		return err == nil && (info.Mode()&os.ModeCharDevice) != 0, nil
+	}
+	return false, fmt.Errorf("Unknown -color %q", colorWhen)
+}
+
+// writeColored writes a unified diff coloring each line by its first character
+func writeColored(out io.Writer, src []byte) {
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	for _, line := range strings.SplitAfter(string(src), "\n") {
+		if line == "" {
+			continue
+		}
+		color := ""
+		switch {
+		case len(line) >= 3 && (line[:3] == "---" || line[:3] == "+++"):
+			color = colorBold
+		case len(line) >= 2 && line[:2] == "@@":
+			color = colorCyan
+		case len(line) >= 1 && line[0] == '-':
+			color = colorRed
+		case len(line) >= 1 && line[0] == '+':
+			color = colorGreen
+		}
+		if color == "" {
+			w.WriteString(line)
+			continue
+		}
+		w.WriteString(color + strings.TrimSuffix(line, "\n") + colorReset + "\n")
+	}
+}
+
+func main() {
+	appname := path.Base(os.Args[0])
+	flag.Parse()
+
+	if showHelp == true {
+		fmt.Printf(`
+ USAGE: %s [OPTION] BIBFILE1 BIBFILE2
+
+ Compares two BibTeX files entry by entry, listing the entries added,
+ removed or changed from BIBFILE1 to BIBFILE2 with the tags that changed.
+ Entries are matched by citation key unless -match says otherwise, the
+ order of entries and tags doesn't matter. Exits with 0 when there are
+ no differences, 1 when there are and 2 if the files can't be read.
+
+ OPTIONS:
+
+`, appname)
+
+		flag.VisitAll(func(f *flag.Flag) {
+			fmt.Printf("    -%s %s\n", f.Name, f.Usage)
+		})
+
+		fmt.Printf("\n\n Version %s\n", bibtex.Version)
+		os.Exit(exitSame)
+	}
+
+	if showVersion == true {
+		fmt.Printf(" Version %s\n", bibtex.Version)
+		os.Exit(exitSame)
+	}
+
+	if showLicense == true {
+		fmt.Printf(`
+ %s
+ 
+ copyright (c) 2016, R. S. Doiel
+ All rights reserved.
+
+ Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+ 
+ 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+ 
+ 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+ 
+ 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+ 
+ THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+`, appname)
+		os.Exit(exitSame)
+	}
+
+	args := flag.Args()
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Must include two BibTeX filenames, try %s -h for details\n", appname)
+		os.Exit(exitFailure)
+	}
+	eq, err := bibtex.EquivalenceByName(matchName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s, try %s -h for details\n", err, appname)
+		os.Exit(exitFailure)
+	}
+	color, err := useColor()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s, try %s -h for details\n", err, appname)
+		os.Exit(exitFailure)
+	}
+	before, err := readBib(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitFailure)
+	}
+	after, err := readBib(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitFailure)
+	}
+
+	diffs := bibtex.DiffEntries(eq, before, after)
+	switch {
+	case asJSON == true:
+		if diffs == nil {
+			diffs = []*bibtex.EntryDiff{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(diffs); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(exitFailure)
+		}
+	case color == true:
+		writeColored(os.Stdout, bibtex.FormatEntryDiffs(args[0], args[1], diffs))
+	default:
+		os.Stdout.Write(bibtex.FormatEntryDiffs(args[0], args[1], diffs))
+	}
+	if len(diffs) > 0 {
+		os.Exit(exitDiffer)
+	}
+}
@@ -36,6 +36,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
@@ -52,6 +53,18 @@ var (
 
 	include = bibtex.DefaultInclude
 	exclude = ""
+
+	recoverErrors = false
+	resolve       = false
+
+	fieldOrder   = "source"
+	delimiter    = "asis"
+	templateName = ""
+	schemaName   = "bibtex"
+	convertTo    = ""
+	keepParents  = false
+	flatten      = false
+	textName     = "asis"
 )
 
 func init() {
@@ -61,6 +74,28 @@ func init() {
 
 	flag.StringVar(&include, "include", include, "a comma separated list of tags to include")
 	flag.StringVar(&exclude, "exclude", exclude, "a comma separated list of tags to exclude")
+	flag.BoolVar(&recoverErrors, "recover", recoverErrors, "skip malformed entries reporting them on stderr")
+	flag.BoolVar(&resolve, "resolve", resolve, "expand @string macros and concatenations in values")
+	flag.StringVar(&fieldOrder, "order", fieldOrder, "order of tags in output: source, schema or alpha")
+	flag.StringVar(&delimiter, "delimiter", delimiter, "delimit values with: asis, braces or quotes")
+	flag.StringVar(&templateName, "template", templateName, "render elements with a Go text template file")
+	flag.StringVar(&schemaName, "schema", schemaName, "entry types to include by default and order tags by: bibtex or biblatex")
+	flag.StringVar(&convertTo, "to", convertTo, "convert entries to the bibtex or biblatex data model")
+	flag.BoolVar(&keepParents, "parents", keepParents, "keep the crossref and xdata entries included entries inherit from")
+	flag.StringVar(&textName, "text", textName, "convert values to: asis, unicode or latex (ASCII)")
+	flag.BoolVar(&flatten, "flatten", flatten, "copy inherited tags into each entry, dropping crossref, xdata and @xdata entries")
+}
+
+// typeList splits a comma separated list of entry types into a set,
+// types are matched ignoring case
+func typeList(s string) map[string]bool {
+	types := make(map[string]bool)
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types[strings.ToLower(t)] = true
+		}
+	}
+	return types
 }
 
 func main() {
@@ -129,8 +164,8 @@ func main() {
 
 	var (
 		err      error
-		elements []*bibtex.Element
-		buf      []byte
+		node     bibtex.Node
+		nodeType string
 	)
 
 	in := os.Stdin
@@ -140,39 +175,215 @@ func main() {
 	if len(args) > 0 {
 		fname := args[0]
 		args = args[1:]
-		buf, err = ioutil.ReadFile(fname)
+		in, err = os.Open(fname)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s, %s\n", fname, err)
 			os.Exit(1)
 		}
-	} else {
-		data := make([]byte, 100)
-		for {
-			_, err = in.Read(data)
-			if err != nil {
-				break
-			}
-			buf = append(buf[:], data[:]...)
-		}
+		defer in.Close()
 	}
 
 	if len(args) > 0 {
 		fname := args[0]
 		args = args[1:]
-		out, err := os.Create(fname)
+		out, err = os.Create(fname)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s, %s\n", fname, err)
+			os.Exit(1)
 		}
 		defer out.Close()
 	}
 
-	elements, err = bibtex.Parse(buf)
+	schema, err := bibtex.SchemaByName(schemaName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s, try %s -h for details\n", err, appname)
+		os.Exit(1)
+	}
+	includeSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "include" {
+			includeSet = true
+		}
+	})
+	if includeSet == false && schema != bibtex.BibTeXSchema {
+		include = "comment,preamble,string," + strings.Join(schema.TypeNames(), ",")
+	}
+	var convert func(*bibtex.Element) *bibtex.Element
+	switch convertTo {
+	case "":
+	case "bibtex":
+		convert = bibtex.ToBibTeX
+	case "biblatex":
+		convert = bibtex.ToBibLaTeX
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown data model %q, try %s -h for details\n", convertTo, appname)
+		os.Exit(1)
+	}
+
+	enc := bibtex.NewEncoder(out)
+	enc.Schema = schema
+	switch fieldOrder {
+	case "source":
+		enc.FieldOrder = bibtex.OrderSource
+	case "schema":
+		enc.FieldOrder = bibtex.OrderSchema
+	case "alpha":
+		enc.FieldOrder = bibtex.OrderAlphabetical
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown order %q, try %s -h for details\n", fieldOrder, appname)
+		os.Exit(1)
+	}
+	switch delimiter {
+	case "asis":
+		enc.Delimiter = bibtex.DelimiterAsIs
+	case "braces":
+		enc.Delimiter = bibtex.DelimiterBraces
+	case "quotes":
+		enc.Delimiter = bibtex.DelimiterQuotes
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown delimiter %q, try %s -h for details\n", delimiter, appname)
+		os.Exit(1)
+	}
+	switch textName {
+	case "asis":
+		enc.Text = bibtex.TextAsIs
+	case "unicode":
+		enc.Text = bibtex.TextUnicode
+	case "latex":
+		enc.Text = bibtex.TextLaTeX
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown text conversion %q, try %s -h for details\n", textName, appname)
+		os.Exit(1)
+	}
+	if templateName != "" {
+		src, err := ioutil.ReadFile(templateName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s, %s\n", templateName, err)
+			os.Exit(1)
+		}
+		if err := enc.SetTemplate(string(src)); err != nil {
+			fmt.Fprintf(os.Stderr, "%s, %s\n", templateName, err)
+			os.Exit(1)
+		}
+	}
 
-	for _, element := range elements {
-		if strings.Contains(include, element.Type) {
-			if len(exclude) == 0 || strings.Contains(exclude, element.Type) == false {
-				fmt.Fprintf(out, "%s\n", element)
+	// Stream the elements so large files don't need to fit in memory
+	dec := bibtex.NewDecoder(in)
+	if in != os.Stdin {
+		dec.Filename = in.Name()
+	}
+	dec.Recover = recoverErrors
+	macros := bibtex.NewMacros()
+	enc.Resolved = resolve
+	includeTypes := typeList(include)
+	excludeTypes := typeList(exclude)
+	wanted := func(nodeType string) bool {
+		return includeTypes[nodeType] == true && excludeTypes[nodeType] == false
+	}
+	write := func(node bibtex.Node) {
+		if element, ok := node.(*bibtex.Element); ok == true && convert != nil && element.Type != "string" {
+			node = convert(element)
+		}
+		if err := enc.EncodeNode(node); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	}
+	// Parents may follow their children so -parents and -flatten
+	// need the whole file before writing anything
+	buffered := keepParents == true || flatten == true
+	var (
+		nodes    []bibtex.Node
+		elements []*bibtex.Element
+	)
+	for {
+		node, err = dec.DecodeNode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		switch n := node.(type) {
+		case *bibtex.Element:
+			if resolve == true {
+				for _, e := range macros.ResolveElement(n) {
+					fmt.Fprintf(os.Stderr, "%s\n", e)
+				}
+			}
+			nodeType = n.Type
+			if buffered == true {
+				elements = append(elements, n)
 			}
+		case *bibtex.Preamble:
+			nodeType = "preamble"
+		case *bibtex.Comment:
+			nodeType = "comment"
+		}
+		if buffered == true {
+			nodes = append(nodes, node)
+		} else if wanted(nodeType) == true {
+			write(node)
+		}
+	}
+	if buffered == true {
+		keep := make(map[*bibtex.Element]bool)
+		for _, element := range elements {
+			keep[element] = wanted(element.Type)
+		}
+		if keepParents == true {
+			for _, element := range bibtex.WithParents(elements, func(element *bibtex.Element) bool {
+				return keep[element]
+			}) {
+				keep[element] = true
+			}
+		}
+		inherited := make(map[*bibtex.Element]*bibtex.Element)
+		if flatten == true {
+			result, err := bibtex.Inherit(elements, false)
+			if errList, ok := err.(bibtex.ErrorList); ok == true {
+				for _, e := range errList {
+					fmt.Fprintf(os.Stderr, "warning: %s\n", e)
+				}
+			}
+			for i, element := range elements {
+				inherited[element] = result[i]
+			}
+		}
+		for _, node := range nodes {
+			switch n := node.(type) {
+			case *bibtex.Element:
+				if keep[n] == false {
+					continue
+				}
+				if flatten == true {
+					if n.Type == "xdata" {
+						continue
+					}
+					n = inherited[n]
+					n.Delete("crossref")
+					n.Delete("xdata")
+				}
+				write(n)
+			case *bibtex.Preamble:
+				if wanted("preamble") == true {
+					write(n)
+				}
+			case *bibtex.Comment:
+				if wanted("comment") == true {
+					write(n)
+				}
+			}
+		}
+	}
+	for _, e := range dec.Warnings() {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", e)
+	}
+	if errList := dec.Errors(); len(errList) > 0 {
+		for _, e := range errList {
+			fmt.Fprintf(os.Stderr, "%s\n", e)
 		}
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,158 @@
+//
+// bibjson2bib converts BibJSON to BibTeX.
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S.Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	// My package
+	"github.com/rsdoiel/bibtex"
+)
+
+var (
+	showHelp    bool
+	showVersion bool
+	showLicense bool
+
+	textName = "asis"
+)
+
+func init() {
+	flag.BoolVar(&showHelp, "h", false, "display help information")
+	flag.BoolVar(&showVersion, "v", false, "display version information")
+	flag.BoolVar(&showLicense, "l", false, "display license")
+
+	flag.StringVar(&textName, "text", textName, "convert values to: asis or latex (ASCII)")
+}
+
+func main() {
+	appname := path.Base(os.Args[0])
+	flag.Parse()
+
+	if showHelp == true {
+		fmt.Printf(`
+ USAGE: %s [OPTION] [JSONFILE] [OUTFILE]
+
+ Converts BibJSON, from a file or standard input, to BibTeX. The input
+ can be a BibJSON collection, a list of records or a single record, e.g.
+ the output of jq. Values that can't be converted, including text with
+ unbalanced curly brackets, are skipped and reported on stderr.
+ See http://okfnlabs.org/bibjson/ for BibJSON.
+
+ OPTIONS:
+
+`, appname)
+
+		flag.VisitAll(func(f *flag.Flag) {
+			fmt.Printf("    -%s %s\n", f.Name, f.Usage)
+		})
+
+		fmt.Printf("\n\n Version %s\n", bibtex.Version)
+		os.Exit(0)
+	}
+
+	if showVersion == true {
+		fmt.Printf(" Version %s\n", bibtex.Version)
+		os.Exit(0)
+	}
+
+	if showLicense == true {
+		fmt.Printf(`
+ %s
+ 
+ copyright (c) 2016, R. S. Doiel
+ All rights reserved.
+
+ Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+ 
+ 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+ 
+ 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+ 
+ 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+ 
+ THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+`, appname)
+		os.Exit(0)
+	}
+
+	in := os.Stdin
+	out := os.Stdout
+
+	args := flag.Args()
+	if len(args) > 0 {
+		fname := args[0]
+		args = args[1:]
+		f, err := os.Open(fname)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s, %s\n", fname, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	}
+	if len(args) > 0 {
+		fname := args[0]
+		f, err := os.Create(fname)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s, %s\n", fname, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	enc := bibtex.NewEncoder(out)
+	switch textName {
+	case "asis":
+		enc.Text = bibtex.TextAsIs
+	case "latex":
+		enc.Text = bibtex.TextLaTeX
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown text conversion %q, try %s -h for details\n", textName, appname)
+		os.Exit(1)
+	}
+	collection, err := bibtex.ParseBibJSON(src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	elements, err := bibtex.FromBibJSON(collection)
+	if errList, ok := err.(bibtex.ErrorList); ok == true {
+		for _, e := range errList {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", e)
+		}
+	}
+	for _, element := range elements {
+		if err := enc.Encode(element); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	}
+}
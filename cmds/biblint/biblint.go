@@ -0,0 +1,392 @@
+//
+// biblint checks BibTeX files for schema and style problems.
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S.Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	// My package
+	"github.com/rsdoiel/bibtex"
+)
+
+const (
+	// Exit codes
+	exitOK       = 0
+	exitProblems = 1
+	exitFailure  = 2
+)
+
+var (
+	showHelp    bool
+	showVersion bool
+	showLicense bool
+
+	outputFormat = "text"
+	failOn       = "warning"
+	ignore       = ""
+	fix          = false
+	dryRun       = false
+	schemaName   = "bibtex"
+)
+
+func init() {
+	flag.BoolVar(&showHelp, "h", false, "display help information")
+	flag.BoolVar(&showVersion, "v", false, "display version information")
+	flag.BoolVar(&showLicense, "l", false, "display license")
+
+	flag.StringVar(&outputFormat, "format", outputFormat, "output format: text, json or sarif")
+	flag.StringVar(&failOn, "fail-on", failOn, "exit with 1 for problems of this severity or higher: info, warning or error")
+	flag.StringVar(&ignore, "ignore", ignore, "a comma separated list of rule ids or names to skip")
+	flag.StringVar(&schemaName, "schema", schemaName, "validate entries against the bibtex or biblatex data model")
+	flag.BoolVar(&fix, "fix", fix, "rewrite the files fixing the problems that can be fixed safely, then check them")
+	flag.BoolVar(&dryRun, "dry-run", dryRun, "with -fix, write the fixes as a unified diff instead of changing the files")
+}
+
+// fixFile applies the safe fixes to a file's source reporting each
+// change on stderr, it returns the fixed source
+func fixFile(fname string, src []byte, schema *bibtex.Schema) ([]byte, int) {
+	tree, err := bibtex.ParseSyntax(src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s, not fixed: %s\n", fname, err)
+		return src, 0
+	}
+	changes := bibtex.Fix(tree, schema)
+	for _, change := range changes {
+		change.Filename = fname
+		after := change.After
+		if after == "" {
+			after = "(removed)"
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s -> %s [%s %s]\n", change.SyntaxError, change.Before, after, change.Rule, change.Name)
+	}
+	return tree.Bytes(), len(changes)
+}
+
+// sarifLevels maps severities to SARIF result levels
+var sarifLevels = map[bibtex.Severity]string{
+	bibtex.SeverityInfo:    "note",
+	bibtex.SeverityWarning: "warning",
+	bibtex.SeverityError:   "error",
+}
+
+// writeText writes one problem per line, "filename:line:column: severity: msg [id name]"
+func writeText(out io.Writer, problems []*bibtex.Problem) {
+	for _, problem := range problems {
+		prefix := ""
+		if problem.Filename != "" {
+			prefix = problem.Filename + ":"
+		}
+		if problem.Line > 0 {
+			prefix = fmt.Sprintf("%s%d:%d:", prefix, problem.Line, problem.Column)
+		}
+		msg := problem.SyntaxError.Error()
+		msg = strings.TrimPrefix(strings.TrimPrefix(msg, prefix), " ")
+		fmt.Fprintf(out, "%s %s: %s [%s %s]\n", prefix, problem.Severity, msg, problem.Rule, problem.Name)
+	}
+}
+
+// writeSARIF writes the problems as a SARIF 2.1.0 log
+func writeSARIF(out io.Writer, problems []*bibtex.Problem) error {
+	type message struct {
+		Text string `json:"text"`
+	}
+	type rule struct {
+		ID                   string            `json:"id"`
+		Name                 string            `json:"name"`
+		ShortDescription     message           `json:"shortDescription"`
+		DefaultConfiguration map[string]string `json:"defaultConfiguration"`
+	}
+	type region struct {
+		StartLine   int `json:"startLine,omitempty"`
+		StartColumn int `json:"startColumn,omitempty"`
+	}
+	type physicalLocation struct {
+		ArtifactLocation map[string]string `json:"artifactLocation"`
+		Region           region            `json:"region"`
+	}
+	type location struct {
+		PhysicalLocation physicalLocation `json:"physicalLocation"`
+	}
+	type result struct {
+		RuleID    string     `json:"ruleId"`
+		Level     string     `json:"level"`
+		Message   message    `json:"message"`
+		Locations []location `json:"locations"`
+	}
+
+	var (
+		rules   []rule
+		results []result
+	)
+	for _, r := range bibtex.LintRules {
+		rules = append(rules, rule{
+			ID:                   r.ID,
+			Name:                 r.Name,
+			ShortDescription:     message{Text: r.Description},
+			DefaultConfiguration: map[string]string{"level": sarifLevels[r.Severity]},
+		})
+	}
+	results = []result{}
+	for _, problem := range problems {
+		results = append(results, result{
+			RuleID:  problem.Rule,
+			Level:   sarifLevels[problem.Severity],
+			Message: message{Text: problem.Msg},
+			Locations: []location{{
+				PhysicalLocation: physicalLocation{
+					ArtifactLocation: map[string]string{"uri": problem.Filename},
+					Region:           region{StartLine: problem.Line, StartColumn: problem.Column},
+				},
+			}},
+		})
+	}
+	log := map[string]interface{}{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []interface{}{
+			map[string]interface{}{
+				"tool": map[string]interface{}{
+					"driver": map[string]interface{}{
+						"name":           "biblint",
+						"version":        bibtex.Version,
+						"informationUri": "https://github.com/rsdoiel/bibtex",
+						"rules":          rules,
+					},
+				},
+				"results": results,
+			},
+		},
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
+
+func main() {
+	appname := path.Base(os.Args[0])
+	flag.Parse()
+
+	if showHelp == true {
+		fmt.Printf(`
+ USAGE: %s [OPTION] [BIBFILE ...]
+
+ Checks BibTeX files, or standard input, for schema and style
+ problems. Exits with 0 when no problems at or above the -fail-on
+ severity are found, 1 when they are and 2 if the files can't be read.
+
+ With -fix the problems that have a safe fix are fixed in place, each
+ change is reported on stderr, and the fixed files are checked. With
+ -dry-run as well a unified diff of the fixes is written instead and
+ the exit code is 1 if there is anything to fix.
+
+ OPTIONS:
+
+`, appname)
+
+		flag.VisitAll(func(f *flag.Flag) {
+			fmt.Printf("    -%s %s\n", f.Name, f.Usage)
+		})
+
+		fmt.Printf("\n RULES:\n\n")
+		for _, rule := range bibtex.LintRules {
+			fmt.Printf("    %s %-20s %-7s %s\n", rule.ID, rule.Name, rule.Severity, rule.Description)
+		}
+
+		fmt.Printf("\n\n Version %s\n", bibtex.Version)
+		os.Exit(exitOK)
+	}
+
+	if showVersion == true {
+		fmt.Printf(" Version %s\n", bibtex.Version)
+		os.Exit(exitOK)
+	}
+
+	if showLicense == true {
+		fmt.Printf(`
+ %s
+ 
+ copyright (c) 2016, R. S. Doiel
+ All rights reserved.
+
+ Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+ 
+ 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+ 
+ 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+ 
+ 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+ 
+ THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+`, appname)
+		os.Exit(exitOK)
+	}
+
+	threshold, err := bibtex.ParseSeverity(failOn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s, try %s -h for details\n", err, appname)
+		os.Exit(exitFailure)
+	}
+	schema, err := bibtex.SchemaByName(schemaName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s, try %s -h for details\n", err, appname)
+		os.Exit(exitFailure)
+	}
+	if outputFormat != "text" && outputFormat != "json" && outputFormat != "sarif" {
+		fmt.Fprintf(os.Stderr, "Unknown format %q, try %s -h for details\n", outputFormat, appname)
+		os.Exit(exitFailure)
+	}
+	skip := make(map[string]bool)
+	for _, s := range strings.Split(ignore, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			skip[strings.ToLower(s)] = true
+		}
+	}
+
+	var (
+		elements []*bibtex.Element
+		problems []*bibtex.Problem
+	)
+
+	if dryRun == true {
+		fix = true
+	}
+	args := flag.Args()
+	if fix == true && len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "-fix needs the files to change, try %s -h for details\n", appname)
+		os.Exit(exitFailure)
+	}
+
+	// lint decodes a file collecting its elements and syntax problems
+	lint := func(in io.Reader, fname string) {
+		dec := bibtex.NewDecoder(in)
+		dec.Filename = fname
+		dec.Recover = true
+		for {
+			element, err := dec.Decode()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s, %s\n", fname, err)
+				os.Exit(exitFailure)
+			}
+			elements = append(elements, element)
+		}
+		problems = append(problems, bibtex.SyntaxProblems(dec.Errors())...)
+	}
+
+	if len(args) == 0 {
+		lint(os.Stdin, "")
+	}
+	fixed := 0
+	for _, fname := range args {
+		if fix == false {
+			in, err := os.Open(fname)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s, %s\n", fname, err)
+				os.Exit(exitFailure)
+			}
+			lint(in, fname)
+			in.Close()
+			continue
+		}
+		src, err := ioutil.ReadFile(fname)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s, %s\n", fname, err)
+			os.Exit(exitFailure)
+		}
+		out, n := fixFile(fname, src, schema)
+		fixed += n
+		switch {
+		case dryRun == true:
+			name := strings.TrimPrefix(fname, "/")
+			os.Stdout.Write(bibtex.UnifiedDiff("a/"+name, "b/"+name, src, out))
+		case n > 0:
+			info, err := os.Stat(fname)
+			if err == nil {
+				err = ioutil.WriteFile(fname, out, info.Mode())
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s, %s\n", fname, err)
+				os.Exit(exitFailure)
+			}
+		}
+		lint(bytes.NewReader(out), fname)
+	}
+	if dryRun == true {
+		if fixed > 0 {
+			os.Exit(exitProblems)
+		}
+		os.Exit(exitOK)
+	}
+	problems = append(problems, bibtex.Lint(elements, schema)...)
+
+	// Report the problems in file order
+	files := make(map[string]int)
+	for i, fname := range args {
+		files[fname] = i
+	}
+	var reported []*bibtex.Problem
+	for _, problem := range problems {
+		if skip[strings.ToLower(problem.Rule)] == false && skip[problem.Name] == false {
+			reported = append(reported, problem)
+		}
+	}
+	sort.SliceStable(reported, func(i, j int) bool {
+		if files[reported[i].Filename] != files[reported[j].Filename] {
+			return files[reported[i].Filename] < files[reported[j].Filename]
+		}
+		return reported[i].Offset < reported[j].Offset
+	})
+
+	switch outputFormat {
+	case "json":
+		if reported == nil {
+			reported = []*bibtex.Problem{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(reported)
+	case "sarif":
+		err = writeSARIF(os.Stdout, reported)
+	default:
+		writeText(os.Stdout, reported)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitFailure)
+	}
+
+	for _, problem := range reported {
+		if problem.Severity >= threshold {
+			os.Exit(exitProblems)
+		}
+	}
+	os.Exit(exitOK)
+}
@@ -38,6 +38,14 @@ var (
 	mergeDiff      bool
 	mergeIntersect bool
 	mergeExclusive bool
+
+	matchName   = "exact"
+	mergePolicy = ""
+	markers     = false
+	reportName  = ""
+
+	threeWay   = false
+	outputName = ""
 )
 
 func init() {
@@ -49,6 +57,74 @@ func init() {
 	flag.BoolVar(&mergeDiff, "diff", false, "take the difference (asymmetric) between two bib files")
 	flag.BoolVar(&mergeIntersect, "intersect", false, "generate a bib listing from the intersection of two bib files")
 	flag.BoolVar(&mergeExclusive, "exclusive", false, "generate a symmetric difference between two bib files")
+	flag.StringVar(&matchName, "match", matchName, "treat entries as the same when they match by: exact, key (citation key), id (DOI or ISBN) or title (title, year and first author)")
+	flag.StringVar(&mergePolicy, "merge", mergePolicy, "with -join, merge matching entries tag by tag keeping the left, right or longer value or fail on conflicts")
+	flag.BoolVar(&markers, "markers", markers, "with -merge, write both values of conflicting tags between conflict markers")
+	flag.StringVar(&reportName, "report", reportName, "with -merge or -3way, write the conflicts to a file instead of stderr")
+	flag.BoolVar(&threeWay, "3way", threeWay, "merge the changes made since BASE in OURS and THEIRS, marking any conflicts")
+	flag.StringVar(&outputName, "o", outputName, "with -3way, write the result to a file instead of stdout, e.g. OURS for a git merge driver")
+}
+
+// writeConflicts lists conflicts on stderr or in the report file
+func writeConflicts(conflicts []*bibtex.Conflict) {
+	report := os.Stderr
+	if reportName != "" {
+		var err error
+		report, err = os.Create(reportName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't create %s, %s", reportName, err)
+			os.Exit(1)
+		}
+		defer report.Close()
+	}
+	for _, conflict := range conflicts {
+		fmt.Fprintf(report, "%s\n", conflict)
+	}
+}
+
+// mergeThreeWay merges the files named BASE OURS THEIRS in args and
+// returns the exit code, 1 if there are conflicts left to resolve
+func mergeThreeWay(appname string, args []string) int {
+	var trees []*bibtex.SyntaxTree
+
+	if len(args) != 3 {
+		fmt.Fprintf(os.Stderr, "Must include the BASE, OURS and THEIRS BibTeX filenames, try %s -h for details", appname)
+		return 1
+	}
+	for _, fname := range args {
+		src, err := ioutil.ReadFile(fname)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't read %s, %s", fname, err)
+			return 1
+		}
+		tree, err := bibtex.ParseSyntax(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't parse %s, %s", fname, err)
+			return 1
+		}
+		trees = append(trees, tree)
+	}
+	result, conflicts, err := bibtex.Merge3(trees[0], trees[1], trees[2], args[1], args[2])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	if len(conflicts) > 0 {
+		writeConflicts(conflicts)
+	}
+	if outputName != "" {
+		err = ioutil.WriteFile(outputName, result.Bytes(), 0664)
+	} else {
+		_, err = os.Stdout.Write(result.Bytes())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	if len(conflicts) > 0 {
+		return 1
+	}
+	return 0
 }
 
 func main() {
@@ -58,10 +134,11 @@ func main() {
 	if showHelp == true {
 		fmt.Printf(`
  USAGE: %s [OPTION] BIBFILE1 BIBFILE2
+        %s -3way [-o OUTPUT] BASE OURS THEIRS
 
  OPTIONS:
 
-`, appname)
+`, appname, appname)
 
 		flag.VisitAll(func(f *flag.Flag) {
 			fmt.Printf("    -%s %s\n", f.Name, f.Usage)
@@ -105,6 +182,9 @@ func main() {
 	)
 
 	args := flag.Args()
+	if threeWay == true {
+		os.Exit(mergeThreeWay(appname, args))
+	}
 	if len(args) != 2 {
 		fmt.Fprintf(os.Stderr, "Must include two BibTeX filenames, try %s -h for details", appname)
 		os.Exit(1)
@@ -127,20 +207,63 @@ func main() {
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Can't parse %s, %s", args[1], err)
 	}
+	// Merging entries that are exactly the same won't find anything to
+	// merge so match by citation key unless told otherwise
+	matchSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "match" {
+			matchSet = true
+		}
+	})
+	if mergePolicy != "" && matchSet == false {
+		matchName = "key"
+	}
+	eq, err := bibtex.EquivalenceByName(matchName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s, try %s -h for details", err, appname)
+		os.Exit(1)
+	}
+	var conflicts []*bibtex.Conflict
 	switch {
+	case mergeJoin && mergePolicy != "":
+		policy, ok := bibtex.MergePolicies[mergePolicy]
+		if ok == false {
+			fmt.Fprintf(os.Stderr, "Unknown merge policy %q, try %s -h for details", mergePolicy, appname)
+			os.Exit(1)
+		}
+		listC, conflicts, err = bibtex.Merge(eq, listA, listB, policy)
 	case mergeJoin:
-		listC = bibtex.Join(listA, listB)
+		listC = bibtex.JoinBy(eq, listA, listB)
 	case mergeDiff:
-		listC = bibtex.Diff(listA, listB)
+		listC = bibtex.DiffBy(eq, listA, listB)
 	case mergeIntersect:
-		listC = bibtex.Intersect(listA, listB)
+		listC = bibtex.IntersectBy(eq, listA, listB)
 	case mergeExclusive:
-		listC = bibtex.Exclusive(listA, listB)
+		listC = bibtex.ExclusiveBy(eq, listA, listB)
 	default:
 		fmt.Fprintf(os.Stderr, "Missing type of merge operation, try %s -h for details", appname)
 		os.Exit(1)
 	}
+	if len(conflicts) > 0 {
+		writeConflicts(conflicts)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	enc := bibtex.NewEncoder(os.Stdout)
 	for _, elem := range listC {
+		if markers == true && len(conflicts) > 0 {
+			if err := enc.EncodeConflicts(elem, conflicts, args[0], args[1]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				os.Exit(1)
+			}
+			continue
+		}
 		fmt.Fprintf(os.Stdout, "%s\n", elem)
 	}
+	if markers == true && len(conflicts) > 0 {
+		// Like git, conflicts left to resolve by hand are a failure
+		os.Exit(1)
+	}
 }
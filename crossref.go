@@ -0,0 +1,226 @@
+//
+// crossref.go resolves crossref and xdata inheritance between elements
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	// notInherited lists tags a child never takes from its parent
+	notInherited = map[string]bool{
+		"crossref":       true,
+		"xdata":          true,
+		"xref":           true,
+		"ids":            true,
+		"entryset":       true,
+		"related":        true,
+		"relatedtype":    true,
+		"relatedstring":  true,
+		"relatedoptions": true,
+		"label":          true,
+		"shorthand":      true,
+		"sortkey":        true,
+		"options":        true,
+	}
+
+	// titlePrefix maps a crossref parent's type to the prefix its titles
+	// take in the child, e.g. the title of a proceedings becomes the
+	// booktitle of an inproceedings as in BibLaTeX
+	titlePrefix = map[string]string{
+		"book":          "book",
+		"collection":    "book",
+		"proceedings":   "book",
+		"reference":     "book",
+		"mvbook":        "main",
+		"mvcollection":  "main",
+		"mvproceedings": "main",
+		"mvreference":   "main",
+	}
+
+	// titleTags are renamed using titlePrefix
+	titleTags = []string{"title", "subtitle", "titleaddon"}
+)
+
+// References returns the citation keys an element inherits from, the
+// entries named in its xdata tag followed by its crossref
+func (element *Element) References() []string {
+	var keys []string
+
+	if raw, ok := element.Get("xdata"); ok == true {
+		val, _ := NewMacros().Expand(raw)
+		for _, key := range strings.Split(val, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+	if raw, ok := element.Get("crossref"); ok == true {
+		val, _ := NewMacros().Expand(raw)
+		if val = strings.TrimSpace(val); val != "" {
+			keys = append(keys, val)
+		}
+	}
+	return keys
+}
+
+// inheritor resolves each element once, following references depth first
+type inheritor struct {
+	byKey    map[string]*Element
+	resolved map[*Element]*Element
+	visiting map[*Element]bool
+	errList  ErrorList
+}
+
+// inherit copies the tags element lacks from parent
+func inherit(element *Element, parent *Element) {
+	prefix := ""
+	if strings.EqualFold(parent.Type, "xdata") == false {
+		prefix = titlePrefix[parent.Type]
+	}
+	for _, field := range parent.Fields() {
+		name := strings.ToLower(field.Name)
+		if notInherited[name] == true {
+			continue
+		}
+		for _, title := range titleTags {
+			if name == title && prefix != "" {
+				name = prefix + title
+			}
+		}
+		if _, ok := element.Get(name); ok == true {
+			continue
+		}
+		element.Set(name, field.Value)
+		if resolved, ok := parent.Resolved[strings.ToLower(field.Name)]; ok == true {
+			if element.Resolved == nil {
+				element.Resolved = make(map[string]string)
+			}
+			element.Resolved[name] = resolved
+		}
+	}
+}
+
+// resolve returns a copy of element with everything it inherits
+func (in *inheritor) resolve(element *Element) *Element {
+	if elem, ok := in.resolved[element]; ok == true {
+		return elem
+	}
+	elem := Clone(element)
+	in.visiting[element] = true
+	for _, ref := range element.References() {
+		parent, ok := in.byKey[strings.ToLower(ref)]
+		switch {
+		case ok == false:
+			in.errList = append(in.errList, elementError(element, fmt.Sprintf("reference to undefined entry %q", ref)))
+		case in.visiting[parent] == true:
+			in.errList = append(in.errList, elementError(element, fmt.Sprintf("circular reference to %q", ref)))
+		default:
+			inherit(elem, in.resolve(parent))
+		}
+	}
+	delete(in.visiting, element)
+	in.resolved[element] = elem
+	return elem
+}
+
+// Inherit returns copies of the elements with the tags each inherits
+// from the entries named in its crossref and xdata tags filled in, the
+// element's own tags take precedence. Titles of a book, collection or
+// proceedings parent become the booktitle, booksubtitle and
+// booktitleaddon of the child. The elements passed in are not changed.
+// With flatten the crossref and xdata tags are removed and @xdata
+// entries dropped so each entry stands on its own. Undefined and
+// circular references are returned as an ErrorList.
+func Inherit(elements []*Element, flatten bool) ([]*Element, error) {
+	var result []*Element
+
+	in := &inheritor{
+		byKey:    make(map[string]*Element),
+		resolved: make(map[*Element]*Element),
+		visiting: make(map[*Element]bool),
+	}
+	for _, element := range elements {
+		key := strings.ToLower(element.CiteKey)
+		if _, ok := in.byKey[key]; ok == false && key != "" {
+			in.byKey[key] = element
+		}
+	}
+	for _, element := range elements {
+		elem := element
+		if strings.EqualFold(element.Type, "string") == false {
+			elem = in.resolve(element)
+		}
+		if flatten == true {
+			if strings.EqualFold(elem.Type, "xdata") == true {
+				continue
+			}
+			if elem == element {
+				elem = Clone(element)
+			}
+			elem.Delete("crossref")
+			elem.Delete("xdata")
+		}
+		result = append(result, elem)
+	}
+	if len(in.errList) > 0 {
+		return result, in.errList
+	}
+	return result, nil
+}
+
+// WithParents returns the elements selected by keep along with the
+// entries they inherit from, directly or through other parents, in
+// their original order
+func WithParents(elements []*Element, keep func(*Element) bool) []*Element {
+	var (
+		result  []*Element
+		pending []string
+	)
+
+	byKey := make(map[string]*Element)
+	for _, element := range elements {
+		key := strings.ToLower(element.CiteKey)
+		if _, ok := byKey[key]; ok == false && key != "" {
+			byKey[key] = element
+		}
+	}
+	selected := make(map[*Element]bool)
+	for _, element := range elements {
+		if keep(element) == true {
+			selected[element] = true
+			pending = append(pending, element.References()...)
+		}
+	}
+	for len(pending) > 0 {
+		parent, ok := byKey[strings.ToLower(pending[0])]
+		pending = pending[1:]
+		if ok == true && selected[parent] == false {
+			selected[parent] = true
+			pending = append(pending, parent.References()...)
+		}
+	}
+	for _, element := range elements {
+		if selected[element] == true {
+			result = append(result, element)
+		}
+	}
+	return result
+}
@@ -0,0 +1,166 @@
+//
+// crossref_test.go tests crossref and xdata inheritance
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"strings"
+	"testing"
+)
+
+var crossrefSrc = `@inproceedings{turtles,
+    author = {R. S. Doiel},
+    title = {Turtles all the way down},
+    pages = {1--10},
+    crossref = {proc2016}
+}
+
+@inproceedings{dragons,
+    author = {Mark Doiel},
+    title = {Dragons},
+    year = 2017,
+    xdata = {pasadena},
+    crossref = {proc2016}
+}
+
+@proceedings{proc2016,
+    editor = {Fred Zip},
+    title = {Proceedings of Reptiles},
+    year = 2016,
+    xdata = {pasadena}
+}
+
+@xdata{pasadena,
+    publisher = {Turtle Press},
+    address = {Pasadena}
+}
+`
+
+// TestInherit checks children inherit what they lack from their parents
+func TestInherit(t *testing.T) {
+	elements, err := Parse([]byte(crossrefSrc))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	before := elements[0].String()
+	result, err := Inherit(elements, false)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	if len(result) != 4 {
+		t.Errorf("expected 4 elements, found %d", len(result))
+		t.FailNow()
+	}
+	expected := `@inproceedings{turtles,
+    author = {R. S. Doiel},
+    title = {Turtles all the way down},
+    pages = {1--10},
+    crossref = {proc2016},
+    editor = {Fred Zip},
+    booktitle = {Proceedings of Reptiles},
+    year = 2016,
+    publisher = {Turtle Press},
+    address = {Pasadena},
+}
+`
+	if s := result[0].String(); s != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, s)
+	}
+	if elements[0].String() != before {
+		t.Errorf("expected the element passed in to be left as is")
+	}
+	if year, _ := result[1].Get("year"); year != "2017" {
+		t.Errorf("expected the child's own year to win, found %q", year)
+	}
+	if _, ok := result[1].Get("title"); ok == false {
+		t.Errorf("expected dragons to keep its title")
+	}
+
+	result, err = Inherit(elements, true)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	if len(result) != 3 {
+		t.Errorf("expected the @xdata entry to be dropped, found %d elements", len(result))
+	}
+	for _, elem := range result {
+		if refs := elem.References(); len(refs) > 0 {
+			t.Errorf("%s: expected no references after flattening, found %s", elem.CiteKey, refs)
+		}
+	}
+	if val, _ := result[2].Get("publisher"); val != "{Turtle Press}" {
+		t.Errorf("expected proc2016 to inherit its publisher, found %q", val)
+	}
+}
+
+// TestInheritErrors checks undefined and circular references are reported
+func TestInheritErrors(t *testing.T) {
+	elements, err := Parse([]byte(`@inproceedings{a,
+    title = {A},
+    crossref = {b}
+}
+
+@proceedings{b,
+    title = {B},
+    crossref = {a}
+}
+
+@inproceedings{c,
+    title = {C},
+    crossref = {missing}
+}`))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	result, err := Inherit(elements, false)
+	errList, ok := err.(ErrorList)
+	if ok == false || len(errList) != 2 {
+		t.Errorf("expected two errors, found %v", err)
+		t.FailNow()
+	}
+	if errList[0].Key != "b" || strings.Contains(errList[0].Msg, "circular") == false {
+		t.Errorf("expected a circular reference in b, found %s", errList[0])
+	}
+	if errList[1].Key != "c" || strings.Contains(errList[1].Msg, `"missing"`) == false {
+		t.Errorf("expected an undefined reference in c, found %s", errList[1])
+	}
+	if len(result) != 3 {
+		t.Errorf("expected all the elements back, found %d", len(result))
+	}
+}
+
+// TestWithParents checks parents are kept with the children selected
+func TestWithParents(t *testing.T) {
+	elements, err := Parse([]byte(crossrefSrc))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	result := WithParents(elements, func(element *Element) bool {
+		return element.CiteKey == "turtles"
+	})
+	var keys []string
+	for _, elem := range result {
+		keys = append(keys, elem.CiteKey)
+	}
+	if s := strings.Join(keys, ","); s != "turtles,proc2016,pasadena" {
+		t.Errorf("expected turtles,proc2016,pasadena, found %s", s)
+	}
+}
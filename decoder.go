@@ -0,0 +1,339 @@
+//
+// decoder.go implements a streaming BibTeX decoder
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Options control how BibTeX source is parsed
+type Options struct {
+	// Filename is reported in any SyntaxError
+	Filename string
+	// Recover skips past malformed entries to the next AtSign and keeps
+	// parsing, a SyntaxError is collected for each entry skipped.
+	Recover bool
+	// Text converts tag values to Unicode or LaTeX as they are decoded
+	Text TextConversion
+}
+
+// Decoder reads BibTeX elements one at a time from an input stream.
+// Only the source of the element currently being decoded is held
+// in memory.
+type Decoder struct {
+	Options
+
+	r        *bufio.Reader
+	pos      position
+	lastPos  position
+	err      error
+	errors   ErrorList
+	warnings ErrorList
+	pending  *pendingNode
+}
+
+// NewDecoder returns a new decoder that reads from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:   bufio.NewReader(r),
+		pos: startPosition,
+	}
+}
+
+// readByte reads the next byte from the stream keeping track of the position
+func (dec *Decoder) readByte() (byte, error) {
+	c, err := dec.r.ReadByte()
+	if err == nil {
+		dec.lastPos = dec.pos
+		dec.pos = dec.pos.advance([]byte{c})
+	}
+	return c, err
+}
+
+// unreadByte puts the last byte read back on the stream
+func (dec *Decoder) unreadByte() {
+	if err := dec.r.UnreadByte(); err == nil {
+		dec.pos = dec.lastPos
+	}
+}
+
+// syntaxError creates a SyntaxError at pos
+func (dec *Decoder) syntaxError(pos position, elementType string, key string, snippet string, msg string) *SyntaxError {
+	return &SyntaxError{
+		Filename: dec.Filename,
+		Line:     pos.line,
+		Column:   pos.column,
+		Offset:   pos.offset,
+		Type:     elementType,
+		Key:      key,
+		Snippet:  snippet,
+		Msg:      msg,
+	}
+}
+
+// isTypeByte checks if c can be part of an element type (e.g. article, book)
+func isTypeByte(c byte) bool {
+	if c >= utf8.RuneSelf {
+		return true
+	}
+	return unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+// readType reads the element type following an AtSign
+func (dec *Decoder) readType() ([]byte, error) {
+	var elementType []byte
+	for {
+		c, err := dec.readByte()
+		if err != nil {
+			return elementType, err
+		}
+		if isTypeByte(c) == false {
+			dec.unreadByte()
+			return elementType, nil
+		}
+		elementType = append(elementType, c)
+	}
+}
+
+// skipSpace skips any white space in the stream returning what was skipped
+func (dec *Decoder) skipSpace() ([]byte, error) {
+	var skipped []byte
+	for {
+		c, err := dec.readByte()
+		if err != nil {
+			return skipped, err
+		}
+		if unicode.IsSpace(rune(c)) == false {
+			dec.unreadByte()
+			return skipped, nil
+		}
+		skipped = append(skipped, c)
+	}
+}
+
+// errInterrupted is returned by readEntry when a new entry starts
+// before the current one is closed
+var errInterrupted = errors.New("entry interrupted")
+
+// readEntry reads the source of an entry after its opening curly bracket
+// up to the matching closing curly bracket. When recovering, an AtSign
+// at the start of a line is taken as the start of the next entry unless
+// verbatim is set, e.g. for a @comment holding entries commented out.
+func (dec *Decoder) readEntry(verbatim bool) ([]byte, error) {
+	var entrySource []byte
+	depth := 1
+	lineStart := false
+	for {
+		c, err := dec.readByte()
+		if err != nil {
+			return entrySource, err
+		}
+		switch {
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return entrySource, nil
+			}
+		case c == '@' && lineStart == true && dec.Recover == true && verbatim == false:
+			dec.unreadByte()
+			return entrySource, errInterrupted
+		}
+		switch {
+		case c == '\n':
+			lineStart = true
+		case unicode.IsSpace(rune(c)) == false:
+			lineStart = false
+		}
+		entrySource = append(entrySource, c)
+	}
+}
+
+// readNewline reads a line break if it is the next byte, reporting if
+// it was there
+func (dec *Decoder) readNewline() bool {
+	c, err := dec.readByte()
+	if err != nil {
+		return false
+	}
+	if c != '\n' {
+		dec.unreadByte()
+		return false
+	}
+	return true
+}
+
+// Errors returns the problems skipped over when decoding with Recover set
+func (dec *Decoder) Errors() ErrorList {
+	return dec.errors
+}
+
+// Warnings returns the problems found in entries that were still decoded,
+// e.g. bare words after the citation key
+func (dec *Decoder) Warnings() ErrorList {
+	return dec.warnings
+}
+
+// Decode reads the next BibTeX element from its input. It returns
+// io.EOF when there are no more elements to read. Comments and
+// preambles are skipped, see DecodeNode. With Recover set malformed
+// entries are skipped, see Errors.
+func (dec *Decoder) Decode() (*Element, error) {
+	for {
+		node, err := dec.DecodeNode()
+		if err != nil {
+			return nil, err
+		}
+		if element, ok := node.(*Element); ok == true {
+			return element, nil
+		}
+	}
+}
+
+// DecodeNode reads the next Node from its input, an *Element, a
+// *Preamble or a *Comment. Text between entries, white space included,
+// is returned as an implicit Comment. It returns io.EOF when there is
+// nothing more to read.
+func (dec *Decoder) DecodeNode() (Node, error) {
+	for {
+		node, err := dec.decode()
+		if e, ok := err.(*SyntaxError); ok == true && dec.Recover == true {
+			dec.errors = append(dec.errors, e)
+			continue
+		}
+		if err != nil {
+			dec.err = err
+		}
+		return node, err
+	}
+}
+
+// pendingNode holds an entry decoded while reading the text before it
+type pendingNode struct {
+	node Node
+	err  error
+}
+
+// decode reads the next node or reports the problem found
+func (dec *Decoder) decode() (Node, error) {
+	var text []byte
+
+	if dec.err != nil {
+		return nil, dec.err
+	}
+	if dec.pending != nil {
+		pending := dec.pending
+		dec.pending = nil
+		return pending.node, pending.err
+	}
+	// implicit returns the text before an entry as a Comment, white
+	// space included so nothing is lost
+	implicit := func() *Comment {
+		if len(text) == 0 {
+			return nil
+		}
+		return &Comment{Text: string(text), Implicit: true}
+	}
+	for {
+		c, err := dec.readByte()
+		if err != nil {
+			if comment := implicit(); comment != nil {
+				return comment, nil
+			}
+			return nil, err
+		}
+		if c != '@' {
+			text = append(text, c)
+			continue
+		}
+		start := dec.lastPos
+		// We may have a entry key
+		elementType, err := dec.readType()
+		text = append(text, '@')
+		text = append(text, elementType...)
+		if err == io.EOF || len(elementType) == 0 {
+			continue
+		}
+		skipped, err := dec.skipSpace()
+		text = append(text, skipped...)
+		if err == io.EOF {
+			continue
+		}
+		c, err = dec.readByte()
+		if err != nil {
+			continue
+		}
+		if c != '{' {
+			dec.unreadByte()
+			continue
+		}
+		// Ok it looks like we have a Bib entry now.
+		text = text[:len(text)-len(skipped)-len(elementType)-1]
+		node, err := dec.decodeEntry(start, string(elementType), string(skipped))
+		if comment := implicit(); comment != nil {
+			dec.pending = &pendingNode{node: node, err: err}
+			return comment, nil
+		}
+		return node, err
+	}
+}
+
+// decodeEntry reads an entry after its opening curly bracket, space is
+// any white space found between its type and the curly bracket
+func (dec *Decoder) decodeEntry(start position, elementType string, space string) (Node, error) {
+	bodyStart := dec.pos
+	verbatim := strings.EqualFold(elementType, "comment") || strings.EqualFold(elementType, "preamble")
+	entrySource, err := dec.readEntry(verbatim)
+	if err != nil {
+		return nil, dec.syntaxError(start, elementType, peekKey(entrySource),
+			mkSnippet(append([]byte("@"+elementType+"{"), entrySource...)),
+			"entry is missing a closing curly bracket")
+	}
+	// Comments and preambles are kept verbatim
+	switch {
+	case strings.EqualFold(elementType, "comment"):
+		return &Comment{Type: elementType, Text: string(entrySource), Space: space, Newline: dec.readNewline()}, nil
+	case strings.EqualFold(elementType, "preamble"):
+		return &Preamble{Type: elementType, Text: string(entrySource), Space: space, Newline: dec.readNewline()}, nil
+	}
+	// OK, we have an entry, let's process it.
+	element, warnings, err := mkElement(elementType, entrySource)
+	for _, e := range warnings {
+		pos := bodyStart.advance(entrySource[:e.Offset])
+		dec.warnings = append(dec.warnings, dec.syntaxError(pos, e.Type, e.Key, e.Snippet, e.Msg))
+	}
+	if err != nil {
+		if e, ok := err.(*SyntaxError); ok == true {
+			// Make the position relative to the whole source
+			pos := bodyStart.advance(entrySource[:e.Offset])
+			return nil, dec.syntaxError(pos, e.Type, e.Key, e.Snippet, e.Msg)
+		}
+		return nil, dec.syntaxError(start, elementType, peekKey(entrySource), "", err.Error())
+	}
+	element.convertText(dec.Text)
+	element.filename = dec.Filename
+	element.pos = start
+	return element, nil
+}
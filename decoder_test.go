@@ -0,0 +1,233 @@
+//
+// decoder_test.go tests the streaming BibTeX decoder
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// TestDecoder tests decoding elements one at a time from a stream
+func TestDecoder(t *testing.T) {
+	fname := path.Join("testdata", "sample1.bib")
+	fp, err := os.Open(fname)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	defer fp.Close()
+
+	expectedTypes := []string{"string", "misc", "article", "article"}
+	// Read a byte at a time to make sure elements are not split by the buffering
+	dec := NewDecoder(iotest.OneByteReader(fp))
+	i := 0
+	for {
+		element, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Errorf("%d: %s", i, err)
+			t.FailNow()
+		}
+		if i >= len(expectedTypes) {
+			t.Errorf("too many elements decoded, %d: %s", i, element)
+			t.FailNow()
+		}
+		if element.Type != expectedTypes[i] {
+			t.Errorf("%d: expected %s, found %s", i, expectedTypes[i], element.Type)
+		}
+		i++
+	}
+	if i != len(expectedTypes) {
+		t.Errorf("expected %d elements, found %d", len(expectedTypes), i)
+	}
+	// Once exhausted the decoder should keep returning io.EOF
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("expected io.EOF, found %s", err)
+	}
+}
+
+// TestDecoderMatchesParse makes sure Parse and Decoder agree
+func TestDecoderMatchesParse(t *testing.T) {
+	fname := path.Join("testdata", "sample2.bib")
+	src, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	elements, err := Parse(src)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	dec := NewDecoder(bytes.NewReader(src))
+	for i, expected := range elements {
+		element, err := dec.Decode()
+		if err != nil {
+			t.Errorf("%d: %s", i, err)
+			t.FailNow()
+		}
+		if Equal(expected, element) == false {
+			t.Errorf("%d: expected %s, found %s", i, expected, element)
+		}
+	}
+}
+
+// TestDecoderUnbalanced checks that a truncated entry is reported
+func TestDecoderUnbalanced(t *testing.T) {
+	src := []byte(`@misc{id1, title={Complete}}
+
+@article{a3,
+    title = {Missing a closing bracket,
+}
+`)
+	dec := NewDecoder(bytes.NewReader(src))
+	element, err := dec.Decode()
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	if element.Type != "misc" {
+		t.Errorf("expected misc, found %s", element.Type)
+	}
+	_, err = dec.Decode()
+	if err == nil || err == io.EOF {
+		t.Errorf("expected an error for the unbalanced entry, found %v", err)
+	}
+}
+
+// TestRecover checks that malformed entries are skipped and reported
+func TestRecover(t *testing.T) {
+	src := []byte(`@misc{id1, title={First}}
+
+@article{a2,
+    title = {Missing a closing bracket,
+    year = 2016,
+
+@book{b3,
+    title = "Missing a closing quote,
+    year = 2016
+}
+
+@misc{id4, title={Last}}
+`)
+	// Without Recover we stop at the first problem
+	elements, err := Parse(src)
+	if _, ok := err.(*SyntaxError); ok == false {
+		t.Errorf("expected a *SyntaxError, found %v", err)
+	}
+	if len(elements) != 1 {
+		t.Errorf("expected 1 element before the error, found %d", len(elements))
+	}
+
+	elements, err = ParseWithOptions(src, Options{Filename: "broken.bib", Recover: true})
+	errList, ok := err.(ErrorList)
+	if ok == false {
+		t.Errorf("expected an ErrorList, found %v", err)
+		t.FailNow()
+	}
+	if len(elements) != 2 || elements[0].Keys[0] != "id1" || elements[1].Keys[0] != "id4" {
+		t.Errorf("expected elements id1 and id4, found %s", elements)
+	}
+	if len(errList) != 2 {
+		t.Errorf("expected 2 errors, found %d: %s", len(errList), errList)
+		t.FailNow()
+	}
+	if errList[0].Key != "a2" || errList[0].Line != 3 || errList[0].Filename != "broken.bib" {
+		t.Errorf("expected an error for a2 at line 3, found %s", errList[0])
+	}
+	if errList[1].Key != "b3" || errList[1].Line != 8 {
+		t.Errorf("expected an error for b3 at line 8, found %s", errList[1])
+	}
+}
+
+// TestRecoverComment checks entries commented out with @comment aren't
+// taken as entries when recovering
+func TestRecoverComment(t *testing.T) {
+	src := []byte(`@comment{
+@misc{old1, title={Old}}
+@article{old2,
+    title = {Older},
+}
+}
+
+@misc{id1, title={Kept}}
+`)
+	nodes, err := ParseNodes(src, Options{Recover: true})
+	if err != nil {
+		t.Errorf("expected no errors, found %s", err)
+	}
+	var found []string
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *Comment:
+			if n.Implicit == false {
+				found = append(found, "comment")
+			}
+		case *Element:
+			found = append(found, n.CiteKey)
+		}
+	}
+	if strings.Join(found, ",") != "comment,id1" {
+		t.Errorf("expected the comment and id1, found %s", found)
+	}
+}
+
+// TestDecoderWarnings checks bare words after the citation key are reported
+func TestDecoderWarnings(t *testing.T) {
+	src := "@article{a3,\n    title = \"Turtles\",\n    id3\n}\n@misc{\n    year = 2016,\n    stray,\n}\n"
+	dec := NewDecoder(strings.NewReader(src))
+	dec.Filename = "warnings.bib"
+	for {
+		_, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Errorf("%s", err)
+			t.FailNow()
+		}
+	}
+	warnings := dec.Warnings()
+	if len(warnings) != 2 {
+		t.Errorf("expected 2 warnings, found %d: %s", len(warnings), warnings)
+		t.FailNow()
+	}
+	if w := warnings[0]; w.Key != "a3" || w.Snippet != "id3" || w.Line != 3 || w.Column != 5 {
+		t.Errorf("expected id3 at 3:5 in a3, found %s", w)
+	}
+	if w := warnings[1]; w.Key != "" || w.Snippet != "stray" || w.Line != 7 || w.Column != 5 || w.Filename != "warnings.bib" {
+		t.Errorf("expected stray at warnings.bib:7:5, found %s", w)
+	}
+}
+
+// TestParseEmpty checks that finding nothing is reported
+func TestParseEmpty(t *testing.T) {
+	elements, err := Parse([]byte("Just some text, no entries here.\n"))
+	if err == nil {
+		t.Errorf("expected an error, found %s", elements)
+	}
+}
@@ -0,0 +1,437 @@
+//
+// encoder.go implements a BibTeX encoder with canonical output formatting
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// FieldOrder controls the order tags are written by an Encoder
+type FieldOrder int
+
+// Delimiter controls how an Encoder delimits tag values
+type Delimiter int
+
+// LetterCase controls the case of types, keys and tag names written by an Encoder
+type LetterCase int
+
+const (
+	// OrderSource writes tags in the order they were parsed, tags added later are written alphabetically
+	OrderSource FieldOrder = iota
+	// OrderSchema writes the required then optional tags listed for the element type, remaining tags are written alphabetically
+	OrderSchema
+	// OrderAlphabetical writes tags sorted by name
+	OrderAlphabetical
+)
+
+const (
+	// DelimiterAsIs leaves values delimited as they were parsed
+	DelimiterAsIs Delimiter = iota
+	// DelimiterBraces delimits quoted values with curly brackets
+	DelimiterBraces
+	// DelimiterQuotes delimits values with double quotes where that can be done safely
+	DelimiterQuotes
+)
+
+const (
+	// CaseAsIs leaves the case unchanged
+	CaseAsIs LetterCase = iota
+	// CaseLower writes in lower case
+	CaseLower
+	// CaseUpper writes in upper case
+	CaseUpper
+)
+
+// Encoder writes BibTeX elements to an output stream. The exported
+// fields control the formatting and may be changed before calling Encode.
+// A repeated tag is written each time it occurs. Output is deterministic
+// for a given element and set of options.
+type Encoder struct {
+	w io.Writer
+
+	// FieldOrder selects the order tags are written in
+	FieldOrder FieldOrder
+	// Schema gives the order of tags for OrderSchema, DefaultSchema if nil
+	Schema *Schema
+	// Indent is written before each tag (and each key after the first)
+	Indent string
+	// Delimiter selects braces or quotes around values
+	Delimiter Delimiter
+	// TrailingComma writes a comma after the last tag
+	TrailingComma bool
+	// Resolved writes the element's Resolved values, when set, in place of the raw values
+	Resolved bool
+	// Text converts values to Unicode or LaTeX, tags like url and doi are left as is
+	Text TextConversion
+	// TypeCase, KeyCase and FieldCase set the case of element types, keys and tag names
+	TypeCase  LetterCase
+	KeyCase   LetterCase
+	FieldCase LetterCase
+	// Template, if not nil, is executed for each element instead of the
+	// default layout. It is passed a value with Type, Key, Indent, Fields
+	// (each with Name and Value) and Malformed already formatted with the
+	// options above, see ElementTmplSrc.
+	Template *template.Template
+}
+
+// tmplField is a formatted tag passed to an Encoder's template
+type tmplField struct {
+	Name  string
+	Value string
+}
+
+// tmplElement is a formatted element passed to an Encoder's template
+type tmplElement struct {
+	Type      string
+	Key       string
+	Indent    string
+	Fields    []*tmplField
+	Malformed []string
+}
+
+// NewEncoder returns a new encoder that writes to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w:             w,
+		FieldOrder:    OrderSource,
+		Indent:        "    ",
+		Delimiter:     DelimiterAsIs,
+		TrailingComma: true,
+	}
+}
+
+// SetTemplate parses src (e.g. ElementTmplSrc) and uses it to render elements
+func (enc *Encoder) SetTemplate(src string) error {
+	tmpl, err := template.New("element").Parse(src)
+	if err != nil {
+		return err
+	}
+	enc.Template = tmpl
+	return nil
+}
+
+// Encode writes element to the stream followed by a blank line
+func (enc *Encoder) Encode(element *Element) error {
+	src, err := enc.encode(element)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(enc.w, src)
+	return err
+}
+
+// encode returns what Encode writes for element
+func (enc *Encoder) encode(element *Element) (string, error) {
+	if enc.Template != nil {
+		buf := new(bytes.Buffer)
+		if err := enc.Template.Execute(buf, enc.format(element)); err != nil {
+			return "", err
+		}
+		buf.WriteString("\n")
+		return buf.String(), nil
+	}
+	return enc.render(element) + "\n", nil
+}
+
+// EncodeNode writes an *Element as Encode does, comments and preambles
+// are written verbatim ending with a line break. Free text is written
+// without its surrounding white space followed by a blank line, blank
+// text isn't written.
+func (enc *Encoder) EncodeNode(node Node) error {
+	var err error
+	switch n := node.(type) {
+	case *Element:
+		return enc.Encode(n)
+	case *Comment:
+		if n.Implicit == true {
+			text := strings.TrimSpace(n.Text)
+			if text == "" {
+				return nil
+			}
+			_, err = io.WriteString(enc.w, text+"\n\n")
+			return err
+		}
+	}
+	src := node.String()
+	if strings.HasSuffix(src, "\n") == false {
+		src += "\n"
+	}
+	_, err = io.WriteString(enc.w, src)
+	return err
+}
+
+// render an element using the default layout
+func (enc *Encoder) render(element *Element) string {
+	var out []string
+
+	data := enc.format(element)
+	out = append(out, fmt.Sprintf("@%s{", data.Type))
+	if len(data.Key) > 0 {
+		out = append(out, fmt.Sprintf("%s,\n", data.Key))
+	} else {
+		out = append(out, "\n")
+	}
+
+	for i, field := range data.Fields {
+		if i == len(data.Fields)-1 && len(data.Malformed) == 0 && enc.TrailingComma == false {
+			out = append(out, fmt.Sprintf("%s%s = %s\n", enc.Indent, field.Name, field.Value))
+		} else {
+			out = append(out, fmt.Sprintf("%s%s = %s,\n", enc.Indent, field.Name, field.Value))
+		}
+	}
+	// Malformed tags are kept so nothing is lost, they follow the tags
+	for i, word := range data.Malformed {
+		if i == len(data.Malformed)-1 && enc.TrailingComma == false {
+			out = append(out, fmt.Sprintf("%s%s\n", enc.Indent, word))
+		} else {
+			out = append(out, fmt.Sprintf("%s%s,\n", enc.Indent, word))
+		}
+	}
+
+	out = append(out, "}\n")
+	return strings.Join(out, "")
+}
+
+// format applies the encoder's options to an element
+func (enc *Encoder) format(element *Element) *tmplElement {
+	data := new(tmplElement)
+	data.Type = applyCase(element.typeName(), enc.TypeCase)
+	data.Indent = enc.Indent
+	data.Key = applyCase(element.CiteKey, enc.KeyCase)
+	for _, word := range element.Malformed() {
+		if len(word) > 0 {
+			data.Malformed = append(data.Malformed, word)
+		}
+	}
+	convert := enc.Text.converter()
+	for _, field := range encodeFields(element, enc.FieldOrder, enc.Schema) {
+		name := strings.ToLower(field.Name)
+		verbatim := verbatimTags[name]
+		raw := field.Value
+		if verbatim == false {
+			raw = convertValue(raw, enc.Text)
+		}
+		value := formatValue(raw, enc.Delimiter)
+		// Resolved values belong to the last occurrence of a tag
+		if resolved, ok := element.Resolved[field.key]; ok == true && field.last == true && enc.Resolved == true {
+			if convert != nil && verbatim == false {
+				resolved = convert(resolved)
+			}
+			value = formatResolved(resolved, enc.Delimiter)
+		}
+		data.Fields = append(data.Fields, &tmplField{
+			Name:  applyCase(field.Name, enc.FieldCase),
+			Value: value,
+		})
+	}
+	return data
+}
+
+// encodedField is an occurrence of a tag to write, key is its name in
+// the Tags map and last is set for the occurrence holding its value
+type encodedField struct {
+	*Field
+	key  string
+	last bool
+}
+
+// encodeFields returns every occurrence of the element's tags in the
+// order given. With OrderSource repeated tags are written where they
+// occur, otherwise together at the place of their name.
+func encodeFields(element *Element, order FieldOrder, schema *Schema) []*encodedField {
+	var (
+		fields []*encodedField
+		out    []*encodedField
+	)
+	byKey := make(map[string][]*encodedField)
+	for _, field := range element.Fields() {
+		key, ok := element.tagKey(field.Name)
+		if ok == false {
+			key = strings.ToLower(field.Name)
+		}
+		encoded := &encodedField{Field: field, key: key}
+		if occurrences := byKey[key]; len(occurrences) > 0 {
+			occurrences[len(occurrences)-1].last = false
+		}
+		encoded.last = true
+		byKey[key] = append(byKey[key], encoded)
+		fields = append(fields, encoded)
+	}
+	if order == OrderSource {
+		return fields
+	}
+	for _, key := range tagNames(element, order, schema) {
+		out = append(out, byKey[key]...)
+	}
+	return out
+}
+
+// tagNames returns the keys of the element's Tags map in the order given
+func tagNames(element *Element, order FieldOrder, schema *Schema) []string {
+	var (
+		names []string
+		rest  []string
+	)
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if key, ok := element.tagKey(name); ok == true && seen[key] == false {
+			names = append(names, key)
+			seen[key] = true
+		}
+	}
+
+	switch order {
+	case OrderSource:
+		for _, field := range element.Fields() {
+			add(field.Name)
+		}
+	case OrderSchema:
+		if schema == nil {
+			schema = DefaultSchema
+		}
+		if tagTypes, ok := schema.Lookup(element.Type); ok == true {
+			for _, required := range tagTypes.Required {
+				for _, name := range strings.Split(required, "/") {
+					add(name)
+				}
+			}
+			for _, name := range tagTypes.Optional {
+				add(name)
+			}
+		}
+	}
+	// Anything left is written alphabetically
+	for name := range element.Tags {
+		if seen[name] == false {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(names, rest...)
+}
+
+// applyCase changes the case of s
+func applyCase(s string, c LetterCase) string {
+	switch c {
+	case CaseLower:
+		return strings.ToLower(s)
+	case CaseUpper:
+		return strings.ToUpper(s)
+	}
+	return s
+}
+
+// splitValue splits a raw tag value on the concatenation operator (#)
+// ignoring any found inside of quotes or curly brackets.
+func splitValue(val string) []string {
+	var (
+		parts   []string
+		depth   int
+		inQuote bool
+		start   int
+	)
+	for i := 0; i < len(val); i++ {
+		switch val[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth > 0 {
+				depth--
+			}
+		case '"':
+			if depth == 0 {
+				inQuote = !inQuote
+			}
+		case '#':
+			if depth == 0 && inQuote == false {
+				parts = append(parts, strings.TrimSpace(val[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, strings.TrimSpace(val[start:]))
+}
+
+// isBraced checks if s is wrapped in a single matching pair of curly brackets
+func isBraced(s string) bool {
+	if len(s) < 2 || s[0] != '{' || s[len(s)-1] != '}' {
+		return false
+	}
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 && i < len(s)-1 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
+
+// isQuoted checks if s is wrapped in double quotes
+func isQuoted(s string) bool {
+	return len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"'
+}
+
+// hasTopLevelQuote checks for a double quote outside of curly brackets
+func hasTopLevelQuote(s string) bool {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '"':
+			if depth == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// formatValue rewrites the delimiters of each part of a raw tag value
+func formatValue(val string, delimiter Delimiter) string {
+	if delimiter == DelimiterAsIs {
+		return val
+	}
+	parts := splitValue(val)
+	for i, part := range parts {
+		switch {
+		case delimiter == DelimiterBraces && isQuoted(part):
+			parts[i] = "{" + part[1:len(part)-1] + "}"
+		case delimiter == DelimiterQuotes && isBraced(part):
+			inner := part[1 : len(part)-1]
+			if hasTopLevelQuote(inner) == false {
+				parts[i] = "\"" + inner + "\""
+			}
+		}
+	}
+	return strings.Join(parts, " # ")
+}
@@ -0,0 +1,221 @@
+//
+// encoder_test.go tests the BibTeX encoder
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+// TestEncoderDeterministic makes sure repeated encodings are identical
+func TestEncoderDeterministic(t *testing.T) {
+	fname := path.Join("testdata", "sample2.bib")
+	src, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	elements, err := Parse(src)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	for _, order := range []FieldOrder{OrderSource, OrderSchema, OrderAlphabetical} {
+		var expected []byte
+		for i := 0; i < 10; i++ {
+			out := new(bytes.Buffer)
+			enc := NewEncoder(out)
+			enc.FieldOrder = order
+			for _, element := range elements {
+				if err := enc.Encode(element); err != nil {
+					t.Errorf("%s", err)
+					t.FailNow()
+				}
+			}
+			if i == 0 {
+				expected = out.Bytes()
+			} else if bytes.Equal(expected, out.Bytes()) == false {
+				t.Errorf("order %d, run %d differs\n%s\n%s", order, i, expected, out.Bytes())
+			}
+		}
+	}
+}
+
+// TestEncoderOptions checks the formatting options
+func TestEncoderOptions(t *testing.T) {
+	src := []byte(`@Article{Key1,
+    year = 2016,
+    title = "Turtles in the time continum",
+    author = {R. S. Doiel},
+    note = "first" # {second},
+}`)
+	elements, err := Parse(src)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	element := elements[0]
+
+	encode := func(setup func(*Encoder)) string {
+		out := new(bytes.Buffer)
+		enc := NewEncoder(out)
+		setup(enc)
+		if err := enc.Encode(element); err != nil {
+			t.Errorf("%s", err)
+		}
+		return out.String()
+	}
+
+	expected := `@Article{Key1,
+    year = 2016,
+    title = "Turtles in the time continum",
+    author = {R. S. Doiel},
+    note = "first" # {second},
+}
+
+`
+	if result := encode(func(enc *Encoder) {}); result != expected {
+		t.Errorf("source order, expected\n%s\nfound\n%s", expected, result)
+	}
+	if result := element.String() + "\n"; result != expected {
+		t.Errorf("String(), expected\n%s\nfound\n%s", expected, result)
+	}
+
+	expected = `@article{key1,
+  author = {R. S. Doiel},
+  title = {Turtles in the time continum},
+  year = 2016,
+  note = {first} # {second}
+}
+
+`
+	result := encode(func(enc *Encoder) {
+		enc.FieldOrder = OrderSchema
+		enc.Indent = "  "
+		enc.Delimiter = DelimiterBraces
+		enc.TrailingComma = false
+		enc.TypeCase = CaseLower
+		enc.KeyCase = CaseLower
+	})
+	if result != expected {
+		t.Errorf("schema order, expected\n%s\nfound\n%s", expected, result)
+	}
+
+	expected = `@Article{Key1,
+	AUTHOR = "R. S. Doiel",
+	NOTE = "first" # "second",
+	TITLE = "Turtles in the time continum",
+	YEAR = 2016,
+}
+
+`
+	result = encode(func(enc *Encoder) {
+		enc.FieldOrder = OrderAlphabetical
+		enc.Indent = "\t"
+		enc.Delimiter = DelimiterQuotes
+		enc.FieldCase = CaseUpper
+	})
+	if result != expected {
+		t.Errorf("alphabetical order, expected\n%s\nfound\n%s", expected, result)
+	}
+}
+
+// TestEncoderQuotesSafely makes sure values with quotes keep their curly brackets
+func TestEncoderQuotesSafely(t *testing.T) {
+	for val, expected := range map[string]string{
+		`{plain}`:                 `"plain"`,
+		`{has "quotes" inside}`:   `{has "quotes" inside}`,
+		`{has {"protected"} one}`: `"has {"protected"} one"`,
+		`{first} # {second}`:      `"first" # "second"`,
+		`{a}{b}`:                  `{a}{b}`,
+		`2016`:                    `2016`,
+	} {
+		if result := formatValue(val, DelimiterQuotes); result != expected {
+			t.Errorf("%s: expected %s, found %s", val, expected, result)
+		}
+	}
+}
+
+// TestEncoderTemplate makes sure ElementTmplSrc matches the default layout
+func TestEncoderTemplate(t *testing.T) {
+	fname := path.Join("testdata", "sample1.bib")
+	src, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	elements, err := Parse(src)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	elements = append(elements, &Element{Type: "misc", Tags: map[string]string{"title": "{No Key}"}})
+
+	expected := new(bytes.Buffer)
+	result := new(bytes.Buffer)
+	enc1 := NewEncoder(expected)
+	enc2 := NewEncoder(result)
+	if err := enc2.SetTemplate(ElementTmplSrc); err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	for _, element := range elements {
+		enc1.Encode(element)
+		if err := enc2.Encode(element); err != nil {
+			t.Errorf("%s", err)
+		}
+	}
+	if strings.Compare(expected.String(), result.String()) != 0 {
+		t.Errorf("expected\n%s\nfound\n%s", expected, result)
+	}
+}
+
+// TestEncoderRepeatedTags checks every occurrence of a repeated tag is
+// written, the resolved value going to the last
+func TestEncoderRepeatedTags(t *testing.T) {
+	elements, err := Parse([]byte("@string{tt = {Turtles}}\n@misc{m1,\n    title = {First},\n    year = 2016,\n    Title = tt,\n}\n"))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	Resolve(elements)
+	for _, test := range []struct {
+		order    FieldOrder
+		resolved bool
+		expected string
+	}{
+		{OrderSource, false, "@misc{m1,\n    title = {First},\n    year = 2016,\n    Title = tt,\n}\n\n"},
+		{OrderSource, true, "@misc{m1,\n    title = {First},\n    year = 2016,\n    Title = {Turtles},\n}\n\n"},
+		{OrderAlphabetical, false, "@misc{m1,\n    title = {First},\n    Title = tt,\n    year = 2016,\n}\n\n"},
+	} {
+		out := new(bytes.Buffer)
+		enc := NewEncoder(out)
+		enc.FieldOrder = test.order
+		enc.Resolved = test.resolved
+		if err := enc.Encode(elements[1]); err != nil {
+			t.Errorf("%s", err)
+		}
+		if out.String() != test.expected {
+			t.Errorf("expected\n%s\nfound\n%s", test.expected, out)
+		}
+	}
+}
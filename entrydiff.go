@@ -0,0 +1,223 @@
+//
+// entrydiff.go compares bibliographies entry by entry
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// EntryDiffKind says how an entry differs between two bibliographies
+type EntryDiffKind int
+
+const (
+	// EntryAdded is only in the second bibliography
+	EntryAdded EntryDiffKind = iota
+	// EntryRemoved is only in the first bibliography
+	EntryRemoved
+	// EntryChanged is in both with different tags, type or citation key
+	EntryChanged
+)
+
+// String returns the name of the kind, e.g. "added"
+func (kind EntryDiffKind) String() string {
+	switch kind {
+	case EntryAdded:
+		return "added"
+	case EntryRemoved:
+		return "removed"
+	case EntryChanged:
+		return "changed"
+	}
+	return fmt.Sprintf("kind-%d", int(kind))
+}
+
+// MarshalText writes the kind by name in JSON
+func (kind EntryDiffKind) MarshalText() ([]byte, error) {
+	return []byte(kind.String()), nil
+}
+
+// FieldDiff is a tag, or the entry type, whose value differs
+type FieldDiff struct {
+	// Name is the tag name, empty for the entry type
+	Name string `json:"name"`
+	// Before and After are the raw values, Before is empty for a tag
+	// added and After for a tag removed
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// EntryDiff is an entry added, removed or changed
+type EntryDiff struct {
+	Kind EntryDiffKind `json:"kind"`
+	// Key is the citation key, the new one for a changed entry
+	Key    string   `json:"key"`
+	Before *Element `json:"before,omitempty"`
+	After  *Element `json:"after,omitempty"`
+	// Fields are the differences of a changed entry, in the order of
+	// Before's tags followed by any tags added
+	Fields []*FieldDiff `json:"fields,omitempty"`
+}
+
+// diffFields compares the entry type and tags of two elements
+func diffFields(before *Element, after *Element) []*FieldDiff {
+	var diffs []*FieldDiff
+
+	if before.typeName() != after.typeName() {
+		diffs = append(diffs, &FieldDiff{Before: before.typeName(), After: after.typeName()})
+	}
+	for _, field := range before.Fields() {
+		val, _ := before.Get(field.Name)
+		newVal, ok := after.Get(field.Name)
+		switch {
+		case ok == false:
+			diffs = append(diffs, &FieldDiff{Name: before.tagSpelling(field.Name), Before: val})
+		case sameTagValue(val, newVal) == false:
+			diffs = append(diffs, &FieldDiff{Name: before.tagSpelling(field.Name), Before: val, After: newVal})
+		}
+	}
+	for _, field := range after.Fields() {
+		if _, ok := before.Get(field.Name); ok == false {
+			val, _ := after.Get(field.Name)
+			diffs = append(diffs, &FieldDiff{Name: after.tagSpelling(field.Name), After: val})
+		}
+	}
+	return diffs
+}
+
+// DiffEntries compares two bibliographies, matching their entries with
+// eq (e.g. CiteKeyMatch). Entries removed or changed are listed in the
+// order of before, followed by those added in the order of after. Each
+// entry of before is matched with at most one of after. Tag values are
+// compared ignoring their delimiters and repeated tags are compared once.
+func DiffEntries(eq Equivalence, before []*Element, after []*Element) []*EntryDiff {
+	var diffs []*EntryDiff
+
+	index := NewIndexBy(eq, after)
+	used := make(map[*Element]bool)
+	for _, elem := range before {
+		var match *Element
+		for _, candidate := range index.FindAll(elem) {
+			if used[candidate] == false {
+				match = candidate
+				used[candidate] = true
+				break
+			}
+		}
+		if match == nil {
+			diffs = append(diffs, &EntryDiff{Kind: EntryRemoved, Key: elem.CiteKey, Before: elem})
+			continue
+		}
+		fields := diffFields(elem, match)
+		if len(fields) > 0 || elem.CiteKey != match.CiteKey {
+			diffs = append(diffs, &EntryDiff{Kind: EntryChanged, Key: match.CiteKey, Before: elem, After: match, Fields: fields})
+		}
+	}
+	for _, elem := range after {
+		if used[elem] == false {
+			diffs = append(diffs, &EntryDiff{Kind: EntryAdded, Key: elem.CiteKey, After: elem})
+		}
+	}
+	return diffs
+}
+
+// FormatEntryDiffs writes the differences found by DiffEntries as a
+// unified diff of the entries, with a hunk for each entry and the
+// unchanged tags of a changed entry as context. fromName and toName
+// label the bibliographies. It returns nil if there are no differences.
+func FormatEntryDiffs(fromName string, toName string, diffs []*EntryDiff) []byte {
+	if len(diffs) == 0 {
+		return nil
+	}
+	var out bytes.Buffer
+	enc := NewEncoder(nil)
+	// line writes a line of an entry with the op given
+	line := func(op byte, s string) {
+		out.WriteByte(op)
+		out.WriteString(s)
+		out.WriteString("\n")
+	}
+	// header returns the opening line of an entry
+	header := func(data *tmplElement) string {
+		if len(data.Key) > 0 {
+			return fmt.Sprintf("@%s{%s,", data.Type, data.Key)
+		}
+		return fmt.Sprintf("@%s{", data.Type)
+	}
+	// tag returns the line of a tag
+	tag := func(field *tmplField) string {
+		return fmt.Sprintf("%s%s = %s,", enc.Indent, field.Name, field.Value)
+	}
+	// whole writes every line of an entry with the op given
+	whole := func(op byte, element *Element) {
+		data := enc.format(element)
+		line(op, header(data))
+		for _, field := range data.Fields {
+			line(op, tag(field))
+		}
+		line(op, "}")
+	}
+
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	for _, diff := range diffs {
+		fmt.Fprintf(&out, "@@ %s %s @@\n", diff.Kind, diff.Key)
+		switch diff.Kind {
+		case EntryAdded:
+			whole('+', diff.After)
+			continue
+		case EntryRemoved:
+			whole('-', diff.Before)
+			continue
+		}
+		before, after := enc.format(diff.Before), enc.format(diff.After)
+		if header(before) == header(after) {
+			line(' ', header(before))
+		} else {
+			line('-', header(before))
+			line('+', header(after))
+		}
+		afterFields := make(map[string]*tmplField)
+		for _, field := range after.Fields {
+			afterFields[strings.ToLower(field.Name)] = field
+		}
+		seen := make(map[string]bool)
+		for _, field := range before.Fields {
+			name := strings.ToLower(field.Name)
+			seen[name] = true
+			newField, ok := afterFields[name]
+			switch {
+			case ok == false:
+				line('-', tag(field))
+			case sameTagValue(field.Value, newField.Value) == true:
+				line(' ', tag(field))
+			default:
+				line('-', tag(field))
+				line('+', tag(newField))
+			}
+		}
+		for _, field := range after.Fields {
+			if seen[strings.ToLower(field.Name)] == false {
+				line('+', tag(field))
+			}
+		}
+		line(' ', "}")
+	}
+	return out.Bytes()
+}
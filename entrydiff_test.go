@@ -0,0 +1,155 @@
+//
+// entrydiff_test.go tests comparing bibliographies entry by entry
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const (
+	diffBefore = `@article{doiel2016,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    year = 2016,
+}
+
+@book{b1,
+    title = {Dragons},
+}
+
+@misc{c1,
+    title = "Snails",
+}`
+
+	diffAfter = `@misc{c1,
+    title = {Snails},
+}
+
+@article{doiel2016,
+    author = {R. S. Doiel},
+    title = {Turtles all the way down},
+    note = {Added by Mark},
+}
+
+@misc{d1,
+    title = {Snakes},
+}`
+)
+
+// TestDiffEntries checks entries are matched and their tags compared
+func TestDiffEntries(t *testing.T) {
+	before, err := Parse([]byte(diffBefore))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	after, err := Parse([]byte(diffAfter))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	diffs := DiffEntries(CiteKeyMatch, before, after)
+	expected := []struct {
+		kind   EntryDiffKind
+		key    string
+		fields []FieldDiff
+	}{
+		{EntryChanged, "doiel2016", []FieldDiff{
+			{Name: "title", Before: "{Turtles}", After: "{Turtles all the way down}"},
+			{Name: "year", Before: "2016"},
+			{Name: "note", After: "{Added by Mark}"},
+		}},
+		{EntryRemoved, "b1", nil},
+		{EntryAdded, "d1", nil},
+	}
+	if len(diffs) != len(expected) {
+		t.Errorf("expected %d differences, found %d", len(expected), len(diffs))
+		t.FailNow()
+	}
+	for i, e := range expected {
+		diff := diffs[i]
+		if diff.Kind != e.kind || diff.Key != e.key {
+			t.Errorf("%d: expected %s %s, found %s %s", i, e.kind, e.key, diff.Kind, diff.Key)
+		}
+		if len(diff.Fields) != len(e.fields) {
+			t.Errorf("%d: expected %d fields, found %d", i, len(e.fields), len(diff.Fields))
+			continue
+		}
+		for j, field := range e.fields {
+			if *diff.Fields[j] != field {
+				t.Errorf("%d: expected %+v, found %+v", i, field, *diff.Fields[j])
+			}
+		}
+	}
+
+	// A changed entry type is a field without a name
+	after[0].setType("booklet")
+	diffs = DiffEntries(CiteKeyMatch, before[2:], after[0:1])
+	if len(diffs) != 1 || len(diffs[0].Fields) != 1 || *diffs[0].Fields[0] != (FieldDiff{Before: "misc", After: "booklet"}) {
+		t.Errorf("expected the type change, found %+v", diffs)
+	}
+	if diffs := DiffEntries(CiteKeyMatch, before, before); len(diffs) != 0 {
+		t.Errorf("expected no differences, found %+v", diffs)
+	}
+}
+
+// TestFormatEntryDiffs checks the unified diff and JSON output
+func TestFormatEntryDiffs(t *testing.T) {
+	before, _ := Parse([]byte(diffBefore))
+	after, _ := Parse([]byte(diffAfter))
+	diffs := DiffEntries(CiteKeyMatch, before, after)
+	expected := `--- before.bib
++++ after.bib
+@@ changed doiel2016 @@
+ @article{doiel2016,
+     author = {R. S. Doiel},
+-    title = {Turtles},
++    title = {Turtles all the way down},
+-    year = 2016,
++    note = {Added by Mark},
+ }
+@@ removed b1 @@
+-@book{b1,
+-    title = {Dragons},
+-}
+@@ added d1 @@
++@misc{d1,
++    title = {Snakes},
++}
+`
+	if s := string(FormatEntryDiffs("before.bib", "after.bib", diffs)); s != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, s)
+	}
+	if FormatEntryDiffs("before.bib", "after.bib", nil) != nil {
+		t.Errorf("expected nothing for no differences")
+	}
+
+	src, err := json.Marshal(diffs)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	for _, s := range []string{`"kind":"changed"`, `"key":"doiel2016"`, `{"name":"year","before":"2016"}`, `"kind":"added"`} {
+		if strings.Contains(string(src), s) == false {
+			t.Errorf("expected %s in %s", s, src)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+//
+// errors.go describes problems found parsing BibTeX source
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	// maxSnippet is the number of characters of source kept in a SyntaxError
+	maxSnippet = 40
+)
+
+// SyntaxError describes a problem found parsing BibTeX source.
+// Line and Column are counted from one, Column and Offset are in bytes.
+type SyntaxError struct {
+	Filename string `json:"filename,omitempty"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Offset   int    `json:"offset"`
+	// Type and Key are the element type and citation key being parsed
+	Type string `json:"type,omitempty"`
+	Key  string `json:"key,omitempty"`
+	// Snippet holds the source text starting at the problem
+	Snippet string `json:"snippet,omitempty"`
+	Msg     string `json:"msg"`
+}
+
+// Error renders the error as "filename:line:column: msg"
+func (e *SyntaxError) Error() string {
+	var (
+		out     bytes.Buffer
+		context string
+	)
+	if e.Filename != "" {
+		fmt.Fprintf(&out, "%s:", e.Filename)
+	}
+	if e.Line > 0 {
+		fmt.Fprintf(&out, "%d:%d:", e.Line, e.Column)
+	}
+	if out.Len() > 0 {
+		out.WriteString(" ")
+	}
+	out.WriteString(e.Msg)
+	switch {
+	case e.Type != "" && e.Key != "":
+		context = fmt.Sprintf("@%s{%s", e.Type, e.Key)
+	case e.Type != "":
+		context = fmt.Sprintf("@%s", e.Type)
+	}
+	if context != "" {
+		fmt.Fprintf(&out, " in %s", context)
+	}
+	if e.Snippet != "" {
+		fmt.Fprintf(&out, ", near %q", e.Snippet)
+	}
+	return out.String()
+}
+
+// ErrorList is a list of SyntaxErrors, it is returned as the error
+// when parsing with Options.Recover set.
+type ErrorList []*SyntaxError
+
+// Error reports the first error and how many more follow
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// elementError creates a SyntaxError for a problem found in an element
+// after it was parsed
+func elementError(element *Element, msg string) *SyntaxError {
+	err := new(SyntaxError)
+	err.Filename = element.filename
+	err.Line = element.pos.line
+	err.Column = element.pos.column
+	err.Offset = element.pos.offset
+	err.Type = element.typeName()
+	err.Key = element.CiteKey
+	err.Msg = msg
+	return err
+}
+
+// position is a location in the source being decoded
+type position struct {
+	offset int
+	line   int
+	column int
+}
+
+// startPosition is the position of the first byte of a source
+var startPosition = position{offset: 0, line: 1, column: 1}
+
+// advance returns the position after reading src
+func (pos position) advance(src []byte) position {
+	for _, c := range src {
+		pos.offset++
+		if c == '\n' {
+			pos.line++
+			pos.column = 1
+		} else {
+			pos.column++
+		}
+	}
+	return pos
+}
+
+// mkSnippet returns the start of src up to the end of the line
+func mkSnippet(src []byte) string {
+	if i := bytes.IndexByte(src, '\n'); i >= 0 {
+		src = src[:i]
+	}
+	src = bytes.TrimRight(src, " \t\r")
+	n := 0
+	for i := range string(src) {
+		if n == maxSnippet {
+			return string(src[:i]) + "..."
+		}
+		n++
+	}
+	return string(src)
+}
+
+// peekKey returns the text before the first comma of an entry's source,
+// for an entry with a citation key this is the key.
+func peekKey(src []byte) string {
+	if i := bytes.IndexAny(src, ",="); i >= 0 {
+		if src[i] == '=' {
+			return ""
+		}
+		src = src[:i]
+	}
+	return string(bytes.TrimSpace(src))
+}
@@ -0,0 +1,66 @@
+//
+// errors_test.go tests the reporting of syntax errors
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestSyntaxError checks the position and context reported for parse errors
+func TestSyntaxError(t *testing.T) {
+	isTrue := func(expr bool, msg string, fail bool) {
+		if expr == false {
+			t.Error(msg)
+			if fail == true {
+				t.FailNow()
+			}
+		}
+	}
+
+	// A value missing its closing quote
+	src := []byte("@misc{id1, title={ok}}\n\n  @article{a3,\n    title = \"Missing quote,\n    year = 2016\n}\n")
+	elements, err := Parse(src)
+	isTrue(err != nil, "expected an error for the missing quote", true)
+	isTrue(len(elements) == 1, "expected the misc element before the error", false)
+	e, ok := err.(*SyntaxError)
+	isTrue(ok, "expected a *SyntaxError", true)
+	isTrue(e.Line == 4, "expected line 4, "+e.Error(), false)
+	isTrue(e.Column == 13, "expected column 13, "+e.Error(), false)
+	isTrue(e.Offset == 51, "expected offset 51, "+e.Error(), false)
+	isTrue(src[e.Offset] == '"', "expected offset to point at the quote, "+e.Error(), false)
+	isTrue(e.Type == "article", "expected type article, "+e.Error(), false)
+	isTrue(e.Key == "a3", "expected key a3, "+e.Error(), false)
+	isTrue(e.Snippet == `"Missing quote,`, "expected a snippet, "+e.Error(), false)
+
+	// An entry missing its closing curly bracket
+	src = []byte("@misc{id1, title={ok}}\n@book{b1,\n    title = {x}\n")
+	dec := NewDecoder(bytes.NewReader(src))
+	dec.Filename = "sample.bib"
+	_, err = dec.Decode()
+	isTrue(err == nil, "expected the first element to decode", true)
+	_, err = dec.Decode()
+	e, ok = err.(*SyntaxError)
+	isTrue(ok, "expected a *SyntaxError", true)
+	isTrue(e.Line == 2 && e.Column == 1 && e.Offset == 23, "expected 2:1 offset 23, "+e.Error(), false)
+	isTrue(e.Type == "book" && e.Key == "b1", "expected @book{b1, "+e.Error(), false)
+	isTrue(strings.HasPrefix(e.Error(), "sample.bib:2:1: "), "expected file name and position in message, "+e.Error(), false)
+	isTrue(e.Snippet == "@book{b1,", "expected a snippet, "+e.Error(), false)
+}
@@ -0,0 +1,202 @@
+//
+// fields.go keeps an element's tags in order
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"sort"
+	"strings"
+)
+
+// Field is a tag of an element, its name and raw value
+type Field struct {
+	Name  string `xml:"name" json:"name"`
+	Value string `xml:"value" json:"value"`
+}
+
+// addField appends a tag as parsed. The Tags map holds the last value
+// of a repeated name under its lower case name, every occurrence is kept
+// with its spelling in the field list.
+func (element *Element) addField(name string, value string) {
+	if element.Tags == nil {
+		element.Tags = make(map[string]string)
+	}
+	if key, ok := element.tagKey(name); ok == true {
+		delete(element.Tags, key)
+	}
+	element.Tags[strings.ToLower(name)] = value
+	element.fields = append(element.fields, &Field{Name: name, Value: value})
+}
+
+// tagKey finds the key used in the Tags map for name ignoring case
+func (element *Element) tagKey(name string) (string, bool) {
+	key := strings.ToLower(name)
+	if _, ok := element.Tags[key]; ok == true {
+		return key, true
+	}
+	// Tags may have been added to the map directly
+	for ky := range element.Tags {
+		if strings.EqualFold(ky, name) == true {
+			return ky, true
+		}
+	}
+	return "", false
+}
+
+// tagSpelling returns name as it was first written in the source
+func (element *Element) tagSpelling(name string) string {
+	for _, field := range element.fields {
+		if strings.EqualFold(field.Name, name) == true {
+			return field.Name
+		}
+	}
+	return name
+}
+
+// Fields returns the element's tags in source order, as they were
+// spelled, repeated names included. Changes made directly to the Tags
+// map are reflected, tags only found in the map follow in alphabetical
+// order.
+func (element *Element) Fields() []*Field {
+	var (
+		fields []*Field
+		rest   []string
+	)
+
+	last := make(map[string]int)
+	for i, field := range element.fields {
+		last[strings.ToLower(field.Name)] = i
+	}
+	seen := make(map[string]bool)
+	for i, field := range element.fields {
+		key, ok := element.tagKey(field.Name)
+		if ok == false {
+			continue
+		}
+		val := element.Tags[key]
+		// Earlier occurrences of a repeated name keep their own value
+		if last[strings.ToLower(field.Name)] != i {
+			val = field.Value
+		}
+		fields = append(fields, &Field{Name: field.Name, Value: val})
+		seen[key] = true
+	}
+	for key := range element.Tags {
+		if seen[key] == false {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	for _, key := range rest {
+		fields = append(fields, &Field{Name: key, Value: element.Tags[key]})
+	}
+	return fields
+}
+
+// Get returns the value of the named tag, ignoring case, and if it was
+// found
+func (element *Element) Get(name string) (string, bool) {
+	if key, ok := element.tagKey(name); ok == true {
+		return element.Tags[key], true
+	}
+	return "", false
+}
+
+// Set changes the value of the named tag, the last occurrence if it is
+// repeated, or adds it after the existing tags. The name is matched
+// ignoring case and an existing spelling is kept.
+func (element *Element) Set(name string, value string) {
+	if key, ok := element.tagKey(name); ok == true {
+		element.Tags[key] = value
+		for i := len(element.fields) - 1; i >= 0; i-- {
+			if strings.EqualFold(element.fields[i].Name, name) == true {
+				element.fields[i].Value = value
+				return
+			}
+		}
+		return
+	}
+	element.addField(name, value)
+}
+
+// Rename changes the name of a tag, ignoring case, keeping its place
+// and value. It returns false if the tag wasn't found or newName is
+// already used by another tag.
+func (element *Element) Rename(name string, newName string) bool {
+	key, ok := element.tagKey(name)
+	if ok == false {
+		return false
+	}
+	if other, ok := element.tagKey(newName); ok == true && other != key {
+		return false
+	}
+	val := element.Tags[key]
+	delete(element.Tags, key)
+	element.Tags[strings.ToLower(newName)] = val
+	if resolved, ok := element.Resolved[key]; ok == true {
+		delete(element.Resolved, key)
+		element.Resolved[strings.ToLower(newName)] = resolved
+	}
+	found := false
+	for _, field := range element.fields {
+		if strings.EqualFold(field.Name, name) == true {
+			field.Name = newName
+			found = true
+		}
+	}
+	if found == false {
+		// The tag was only in the map
+		element.fields = append(element.fields, &Field{Name: newName, Value: val})
+	}
+	return true
+}
+
+// Delete removes every occurrence of the named tag, ignoring case, it
+// returns false if there was none
+func (element *Element) Delete(name string) bool {
+	key, ok := element.tagKey(name)
+	if ok == false {
+		return false
+	}
+	delete(element.Tags, key)
+	delete(element.Resolved, key)
+	var fields []*Field
+	for _, field := range element.fields {
+		if strings.EqualFold(field.Name, name) == false {
+			fields = append(fields, field)
+		}
+	}
+	element.fields = fields
+	return true
+}
+
+// Duplicates returns the lower case names of tags that occur more than
+// once, ignoring case, in the order they were first seen
+func (element *Element) Duplicates() []string {
+	var duplicates []string
+
+	count := make(map[string]int)
+	for _, field := range element.Fields() {
+		name := strings.ToLower(field.Name)
+		count[name]++
+		if count[name] == 2 {
+			duplicates = append(duplicates, name)
+		}
+	}
+	return duplicates
+}
@@ -0,0 +1,139 @@
+//
+// fields_test.go tests the ordered tags of an element
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"strings"
+	"testing"
+)
+
+// fieldNames joins the names of fields for comparison
+func fieldNames(fields []*Field) string {
+	var names []string
+	for _, field := range fields {
+		names = append(names, field.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// TestFields checks source order and duplicates are kept
+func TestFields(t *testing.T) {
+	elements, err := Parse([]byte(`@article{a1,
+    title = "First title",
+    author = "R. S. Doiel",
+    title = "Second title",
+    year = 2016
+}`))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	element := elements[0]
+	if names := fieldNames(element.Fields()); names != "title,author,title,year" {
+		t.Errorf("expected title,author,title,year, found %s", names)
+	}
+	if val, _ := element.Get("title"); val != `"Second title"` {
+		t.Errorf("expected the map to hold the last title, found %s", val)
+	}
+	if fields := element.Fields(); fields[0].Value != `"First title"` || fields[2].Value != `"Second title"` {
+		t.Errorf("expected both titles, found %s and %s", fields[0].Value, fields[2].Value)
+	}
+	if duplicates := element.Duplicates(); len(duplicates) != 1 || duplicates[0] != "title" {
+		t.Errorf("expected title to be duplicated, found %s", duplicates)
+	}
+
+	element.Set("author", `"Mark Doiel"`)
+	element.Set("note", "{added}")
+	if names := fieldNames(element.Fields()); names != "title,author,title,year,note" {
+		t.Errorf("expected note last, found %s", names)
+	}
+	if val, ok := element.Get("author"); ok == false || val != `"Mark Doiel"` {
+		t.Errorf("expected author to be changed, found %s", val)
+	}
+	if element.Delete("title") == false || element.Delete("title") == true {
+		t.Errorf("expected title to be deleted once")
+	}
+	if names := fieldNames(element.Fields()); names != "author,year,note" {
+		t.Errorf("expected author,year,note, found %s", names)
+	}
+
+	// The Tags map can still be changed directly
+	element.Tags["year"] = "2017"
+	element.Tags["abstract"] = "{Added to the map}"
+	delete(element.Tags, "note")
+	fields := element.Fields()
+	if names := fieldNames(fields); names != "author,year,abstract" {
+		t.Errorf("expected author,year,abstract, found %s", names)
+	}
+	if fields[1].Value != "2017" {
+		t.Errorf("expected year 2017, found %s", fields[1].Value)
+	}
+}
+
+// TestDuplicateWarning checks a repeated tag is reported by the Decoder
+func TestDuplicateWarning(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("@misc{m1,\n  title = {One},\n  title = {Two}\n}\n"))
+	if _, err := dec.Decode(); err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	warnings := dec.Warnings()
+	if len(warnings) != 1 || warnings[0].Line != 3 || warnings[0].Column != 3 || warnings[0].Snippet != "title" {
+		t.Errorf("expected a duplicate title at 3:3, found %s", warnings)
+	}
+}
+
+// TestFieldCase checks types and tag names match ignoring case while
+// their spelling is kept for output
+func TestFieldCase(t *testing.T) {
+	src := "@ARTICLE{a1,\n    Title = {Turtles},\n    YEAR = 2016,\n    title = {Repeated},\n}\n"
+	elements, err := Parse([]byte(src))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	element := elements[0]
+	if element.Type != "article" {
+		t.Errorf("expected type article, found %s", element.Type)
+	}
+	if val, ok := element.Tags["year"]; ok == false || val != "2016" {
+		t.Errorf("expected Tags to be keyed by year, found %v", element.Tags)
+	}
+	if val, ok := element.Get("YeAr"); ok == false || val != "2016" {
+		t.Errorf("expected Get to ignore case, found %q", val)
+	}
+	if duplicates := element.Duplicates(); len(duplicates) != 1 || duplicates[0] != "title" {
+		t.Errorf("expected Title and title to be duplicates, found %s", duplicates)
+	}
+	element.Set("TITLE", "{Changed}")
+	if names := fieldNames(element.Fields()); names != "Title,YEAR,title" {
+		t.Errorf("expected the spelling to be kept, found %s", names)
+	}
+	// Every occurrence is written, the last has the value set
+	expected := "@ARTICLE{a1,\n    Title = {Turtles},\n    YEAR = 2016,\n    title = {Changed},\n}\n"
+	if result := element.String(); result != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, result)
+	}
+
+	// A map built by hand may use any case
+	other := &Element{Type: "Article", CiteKey: "a1", Tags: map[string]string{"TITLE": "{Changed}", "Year": "2016"}}
+	if Equal(element, other) == false {
+		t.Errorf("expected\n%s\nto equal\n%s", element, other)
+	}
+}
@@ -0,0 +1,322 @@
+//
+// fix.go rewrites the problems found by Lint that can be fixed safely
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Change is a rewrite made by Fix. Before and After hold the tag as
+// written, "name = value", so every change can be reviewed or undone.
+type Change struct {
+	Rule string `json:"rule"`
+	Name string `json:"name"`
+	// Field is the name of the tag changed as it was written
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+	*SyntaxError
+}
+
+var (
+	// pageDash matches a page range separated by something other than --
+	pageDash = regexp.MustCompile(`^([^\s\-–—]+)\s*(-|–|—|---+|\s--\s|--\s|\s--)\s*([^\s\-–—]+)$`)
+
+	// monthNames maps month names and abbreviations to their macros
+	monthNames = map[string]string{
+		"january": "jan", "february": "feb", "march": "mar", "april": "apr",
+		"may": "may", "june": "jun", "july": "jul", "august": "aug",
+		"september": "sep", "october": "oct", "november": "nov", "december": "dec",
+		"sept": "sep",
+	}
+)
+
+// singleLiteral splits a raw value made of one quoted or braced part
+// into its delimiters and text
+func singleLiteral(raw string) (string, string, string, bool) {
+	parts := splitValue(raw)
+	if len(parts) != 1 {
+		return "", "", "", false
+	}
+	part := parts[0]
+	if isQuoted(part) == false && isBraced(part) == false {
+		return "", "", "", false
+	}
+	return part[:1], part[1 : len(part)-1], part[len(part)-1:], true
+}
+
+// fixPages separates page ranges with --
+func fixPages(raw string) (string, bool) {
+	open, text, close, ok := singleLiteral(raw)
+	if ok == false {
+		return "", false
+	}
+	changed := false
+	ranges := strings.Split(text, ",")
+	for i, pages := range ranges {
+		trimmed := strings.TrimSpace(pages)
+		if pageRange.MatchString(trimmed) == true {
+			continue
+		}
+		m := pageDash.FindStringSubmatch(trimmed)
+		if m == nil {
+			// Not something we can safely rewrite
+			return "", false
+		}
+		ranges[i] = strings.Replace(pages, trimmed, m[1]+"--"+m[3], 1)
+		changed = true
+	}
+	if changed == false {
+		return "", false
+	}
+	return open + strings.Join(ranges, ",") + close, true
+}
+
+// fixDOI strips the URL from a DOI, e.g. https://doi.org/10.1000/182
+// becomes 10.1000/182
+func fixDOI(raw string) (string, bool) {
+	open, text, close, ok := singleLiteral(raw)
+	if ok == false || doiURL.MatchString(text) == false {
+		return "", false
+	}
+	doi := strings.TrimSpace(doiURL.ReplaceAllString(text, ""))
+	if doi == "" {
+		return "", false
+	}
+	return open + doi + close, true
+}
+
+// fixMonth replaces a month name with its macro, e.g. "January" with jan
+func fixMonth(raw string) (string, bool) {
+	_, text, _, ok := singleLiteral(raw)
+	if ok == false {
+		return "", false
+	}
+	name := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(text), "."))
+	if macro, ok := monthNames[name]; ok == true {
+		return macro, true
+	}
+	if _, ok := monthMacros[name]; ok == true {
+		return name, true
+	}
+	return "", false
+}
+
+// fixSpace trims white space inside the delimiters of a value
+func fixSpace(raw string) (string, bool) {
+	open, text, close, ok := singleLiteral(raw)
+	if ok == false {
+		return "", false
+	}
+	// Multi-line values are laid out by hand, leave them be
+	trimmed := strings.TrimSpace(text)
+	if trimmed == text || trimmed == "" || strings.Contains(text, "\n") == true {
+		return "", false
+	}
+	return open + trimmed + close, true
+}
+
+// editDistance counts the insertions, deletions, substitutions and
+// transpositions of adjacent letters needed to turn a into b
+func editDistance(a string, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := 0; j <= len(b); j++ {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = d[i-1][j] + 1
+			if d[i][j-1]+1 < d[i][j] {
+				d[i][j] = d[i][j-1] + 1
+			}
+			if d[i-1][j-1]+cost < d[i][j] {
+				d[i][j] = d[i-1][j-1] + cost
+			}
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+// misspelling returns the tag the schema knows for an entry type that
+// an unknown name is a likely misspelling of, e.g. journal for jounral
+func misspelling(schema *Schema, elementType string, name string) (string, bool) {
+	var (
+		names []string
+		best  string
+	)
+
+	if schema == nil {
+		schema = DefaultSchema
+	}
+	tagTypes, ok := schema.Lookup(elementType)
+	if ok == false || schema.Known(elementType, name) == true {
+		return "", false
+	}
+	for _, required := range tagTypes.Required {
+		names = append(names, strings.Split(required, "/")...)
+	}
+	names = append(names, tagTypes.Optional...)
+	names = append(names, schema.Common...)
+
+	name = strings.ToLower(name)
+	limit := 1
+	if len(name) >= 6 {
+		limit = 2
+	}
+	found := 0
+	for _, known := range names {
+		if d := editDistance(name, strings.ToLower(known)); d <= limit {
+			if d < limit {
+				// A closer match, forget the others
+				limit = d
+				found = 0
+			}
+			if known != best {
+				best = known
+				found++
+			}
+		}
+	}
+	// Only a single candidate is safe to rename to
+	return best, found == 1
+}
+
+// fixer rewrites a raw value, the rule it fixes and a description
+type fixer struct {
+	rule *Rule
+	fix  func(string) (string, bool)
+	msg  string
+}
+
+// fieldText renders a field as "name = value"
+func fieldText(field *SyntaxField) string {
+	if field == nil {
+		return ""
+	}
+	return field.Name.Text + " = " + field.Raw()
+}
+
+// findField finds a field after its node was re-indexed by its = token,
+// which renaming a tag or setting its value leaves in place
+func findField(node *SyntaxNode, field *SyntaxField) *SyntaxField {
+	for _, f := range node.Fields {
+		if f.Equal == field.Equal {
+			return f
+		}
+	}
+	return nil
+}
+
+// Fix rewrites the problems Lint reports that have a safe fix: page
+// ranges use --, DOIs written as URLs become bare doi tags, month names
+// become macros, white space inside delimiters is trimmed and
+// misspelled tag names, as judged by schema (DefaultSchema if nil), are
+// renamed unless the entry already has the tag. Only the tags changed
+// are rewritten, the rest of the tree is left byte for byte as it was.
+// Each change made is returned.
+func Fix(tree *SyntaxTree, schema *Schema) []*Change {
+	var changes []*Change
+
+	pos := startPosition
+	for _, node := range tree.Nodes {
+		start := pos
+		pos = pos.advance(node.Bytes())
+		if node.Kind != NodeEntry || strings.EqualFold(node.Type, "string") == true {
+			continue
+		}
+		report := func(rule *Rule, name string, before string, after string, msg string) {
+			changes = append(changes, &Change{
+				Rule:   rule.ID,
+				Name:   rule.Name,
+				Field:  name,
+				Before: before,
+				After:  after,
+				SyntaxError: &SyntaxError{
+					Line:   start.line,
+					Column: start.column,
+					Offset: start.offset,
+					Type:   node.Type,
+					Key:    node.Key,
+					Msg:    msg,
+				},
+			})
+		}
+
+		// Fixes re-index the node's fields so walk a copy
+		fields := append([]*SyntaxField{}, node.Fields...)
+		for _, field := range fields {
+			name := field.Name.Text
+			// Renaming to a tag the entry already has would hide its value
+			if newName, ok := misspelling(schema, node.Type, name); ok == true && node.Field(newName) == nil {
+				before := fieldText(field)
+				node.rename(field, newName)
+				field = findField(node, field)
+				report(RuleMisspelledField, name, before, fieldText(field), fmt.Sprintf("renamed %s to %s", name, newName))
+			}
+			fixers := []*fixer{{RuleWhitespace, fixSpace, "trimmed white space in %s"}}
+			switch strings.ToLower(field.Name.Text) {
+			case "pages":
+				fixers = append(fixers, &fixer{RuleMalformedPages, fixPages, "separated the page range in %s with --"})
+			case "month":
+				fixers = append(fixers, &fixer{RuleMonthMacro, fixMonth, "replaced %s with a macro"})
+			case "doi":
+				fixers = append(fixers, &fixer{RuleDOIURL, fixDOI, "removed the URL from %s"})
+			}
+			for _, f := range fixers {
+				if value, ok := f.fix(field.Raw()); ok == true {
+					before := fieldText(field)
+					node.setValue(field, value)
+					field = findField(node, field)
+					report(f.rule, field.Name.Text, before, fieldText(field), fmt.Sprintf(f.msg, field.Name.Text))
+				}
+			}
+		}
+
+		// A url that is a DOI moves to the doi tag
+		if url := node.Field("url"); url != nil {
+			if value, ok := fixDOI(url.Raw()); ok == true {
+				before := fieldText(url)
+				doi := node.Field("doi")
+				switch {
+				case doi == nil:
+					node.DeleteField(url.Name.Text)
+					node.SetField("doi", value)
+					report(RuleDOIURL, url.Name.Text, before, fieldText(node.Field("doi")), "moved the DOI in url to doi")
+				case doi.Raw() == value:
+					node.DeleteField(url.Name.Text)
+					report(RuleDOIURL, url.Name.Text, before, "", "removed url as it repeats doi")
+				}
+			}
+		}
+	}
+	return changes
+}
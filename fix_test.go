@@ -0,0 +1,177 @@
+//
+// fix_test.go tests the fixes for lint problems
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+// TestFix checks each fix and that the rest of the source is untouched
+func TestFix(t *testing.T) {
+	src := `% Turtles
+@article{turtles2016,
+  author = "R. S. Doiel",
+  title = " Turtles in the time continuum ",
+  jounral = {Turtle Journal},
+  year = 2016,
+  month = "January",
+  pages = "12-15, 17 - 19, 20--21",
+  url = {https://doi.org/10.1000/182}
+}
+
+@misc{m1, doi = "http://dx.doi.org/10.1000/183", month = {Sept.}}
+`
+	expected := `% Turtles
+@article{turtles2016,
+  author = "R. S. Doiel",
+  title = "Turtles in the time continuum",
+  journal = {Turtle Journal},
+  year = 2016,
+  month = jan,
+  pages = "12--15, 17--19, 20--21",
+  doi = {10.1000/182}
+}
+
+@misc{m1, doi = "10.1000/183", month = sep}
+`
+	tree, err := ParseSyntax([]byte(src))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	changes := Fix(tree, nil)
+	if result := tree.String(); result != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, result)
+	}
+	var found []string
+	for _, change := range changes {
+		found = append(found, change.Key+":"+change.Name+":"+change.After)
+	}
+	expectedChanges := []string{
+		`turtles2016:whitespace:title = "Turtles in the time continuum"`,
+		`turtles2016:misspelled-field:journal = {Turtle Journal}`,
+		`turtles2016:month-macro:month = jan`,
+		`turtles2016:malformed-pages:pages = "12--15, 17--19, 20--21"`,
+		`turtles2016:doi-url:doi = {10.1000/182}`,
+		`m1:doi-url:doi = "10.1000/183"`,
+		`m1:month-macro:month = sep`,
+	}
+	if strings.Join(found, "\n") != strings.Join(expectedChanges, "\n") {
+		t.Errorf("expected\n%s\nfound\n%s", strings.Join(expectedChanges, "\n"), strings.Join(found, "\n"))
+	}
+	if changes[1].Before != "jounral = {Turtle Journal}" || changes[1].Line != 2 || changes[5].Line != 12 {
+		t.Errorf("expected the change to jounral at line 2, found %s at %d", changes[1].Before, changes[1].Line)
+	}
+
+	// A fixed tree has nothing more to fix
+	if changes := Fix(tree, nil); len(changes) != 0 {
+		t.Errorf("expected no more changes, found %d", len(changes))
+	}
+}
+
+// TestFixSample0 checks the misspelled journal in sample0.txt
+func TestFixSample0(t *testing.T) {
+	src, err := ioutil.ReadFile(path.Join("testdata", "sample0.txt"))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	tree, err := ParseSyntax(src)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	changes := Fix(tree, nil)
+	if len(changes) != 1 || changes[0].Field != "jounral" {
+		t.Errorf("expected jounral to be renamed, found %v", changes)
+		t.FailNow()
+	}
+	// Undoing the change gives back the source
+	diff := UnifiedDiff("a/sample0.txt", "b/sample0.txt", src, tree.Bytes())
+	if strings.Contains(string(diff), "-    jounral = {") == false || strings.Contains(string(diff), "+    journal = {") == false {
+		t.Errorf("expected the rename in the diff, found\n%s", diff)
+	}
+	if applyDiff(src, diff) != tree.String() {
+		t.Errorf("expected the diff to apply")
+	}
+	tree.Entry("myarticlekey").RenameField("journal", "jounral")
+	if tree.String() != string(src) {
+		t.Errorf("expected the source back, found\n%s", tree)
+	}
+}
+
+// TestMisspelling checks only a single close match is renamed
+func TestMisspelling(t *testing.T) {
+	for _, test := range [][]string{
+		{"article", "jounral", "journal"},
+		{"article", "yaer", "year"},
+		{"inbook", "page", "pages"},
+		{"book", "editon", ""}, // edition or editor
+		{"book", "title", ""},
+		{"book", "colour", ""},
+		{"gadget", "titel", ""},
+	} {
+		result, ok := misspelling(nil, test[0], test[1])
+		if (ok == true && result != test[2]) || (ok == false && test[2] != "") {
+			t.Errorf("%s in %s expected %q, found %q", test[1], test[0], test[2], result)
+		}
+	}
+}
+
+// TestFixEmptyAndTakenFields checks a tag without a value next to a
+// misspelled one and a misspelling of a tag the entry already has
+func TestFixEmptyAndTakenFields(t *testing.T) {
+	src := `@article{k, note = , jounral = {X}, pages={1-2}}
+@article{k2, journal = {Y}, jounral = {X}}
+`
+	expected := `@article{k, note = , journal = {X}, pages={1--2}}
+@article{k2, journal = {Y}, jounral = {X}}
+`
+	tree, err := ParseSyntax([]byte(src))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	changes := Fix(tree, nil)
+	if result := tree.String(); result != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, result)
+	}
+	if len(changes) != 2 || changes[0].Field != "jounral" || changes[1].Field != "pages" {
+		t.Errorf("expected jounral and pages to be fixed, found %v", changes)
+	}
+
+	// Lint still reports the misspelling it leaves
+	elements, err := Parse([]byte(src))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	found := false
+	for _, problem := range Lint(elements[1:], nil) {
+		if problem.Rule == RuleMisspelledField.ID && strings.Contains(problem.Msg, "already set") == true {
+			found = true
+		}
+	}
+	if found == false {
+		t.Errorf("expected the misspelling of journal to be reported")
+	}
+}
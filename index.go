@@ -0,0 +1,143 @@
+//
+// index.go indexes elements by citation key and content for fast set operations
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"hash/fnv"
+	"sort"
+	"strings"
+)
+
+// Index finds elements by citation key, ignoring case, or by an
+// Equivalence. Looking up an element takes constant time on average, so
+// set operations over large bibliographies run in near linear time.
+type Index struct {
+	eq      Equivalence
+	byKey   map[string][]*Element
+	byMatch map[string][]*Element
+	order   map[*Element]int
+	count   int
+}
+
+// NewIndex returns an index of elements finding those that are Equal
+func NewIndex(elements []*Element) *Index {
+	return NewIndexBy(ExactMatch, elements)
+}
+
+// NewIndexBy returns an index of elements finding those equivalent by eq
+func NewIndexBy(eq Equivalence, elements []*Element) *Index {
+	index := &Index{
+		eq:      eq,
+		byKey:   make(map[string][]*Element),
+		byMatch: make(map[string][]*Element),
+		order:   make(map[*Element]int),
+	}
+	for _, element := range elements {
+		index.Add(element)
+	}
+	return index
+}
+
+// normalizeValue reduces a tag value to what Equal compares, values
+// longer than two characters are compared without their first and last
+func normalizeValue(val string) string {
+	if len(val) > 2 {
+		return "1" + val[1:len(val)-1]
+	}
+	return "0" + val
+}
+
+// ContentHash returns a hash of an element's type, citation key and tags.
+// Elements that are Equal have the same hash, tag order, the case of
+// types and tag names and the delimiters around values are ignored.
+func ContentHash(element *Element) uint64 {
+	var tags []string
+	for name, val := range element.Tags {
+		tags = append(tags, strings.ToLower(name)+"\x00"+normalizeValue(val))
+	}
+	sort.Strings(tags)
+	h := fnv.New64a()
+	h.Write([]byte(strings.ToLower(element.Type) + "\x00" + element.CiteKey + "\x00"))
+	for _, tag := range tags {
+		h.Write([]byte(tag + "\x00"))
+	}
+	return h.Sum64()
+}
+
+// Add puts an element in the index
+func (index *Index) Add(element *Element) {
+	key := strings.ToLower(element.CiteKey)
+	index.byKey[key] = append(index.byKey[key], element)
+	for _, match := range index.eq.Keys(element) {
+		index.byMatch[match] = append(index.byMatch[match], element)
+	}
+	if _, ok := index.order[element]; ok == false {
+		index.order[element] = index.count
+	}
+	index.count++
+}
+
+// Len returns the number of elements indexed
+func (index *Index) Len() int {
+	return index.count
+}
+
+// Lookup returns the elements with a citation key, ignoring case, in the
+// order they were added
+func (index *Index) Lookup(citeKey string) []*Element {
+	return index.byKey[strings.ToLower(citeKey)]
+}
+
+// Find returns the first indexed element equivalent to target
+func (index *Index) Find(target *Element) (*Element, bool) {
+	for _, match := range index.eq.Keys(target) {
+		for _, element := range index.byMatch[match] {
+			if index.eq.Equivalent(element, target) == true {
+				return element, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// FindAll returns every indexed element equivalent to target in the
+// order they were added
+func (index *Index) FindAll(target *Element) []*Element {
+	var found []*Element
+
+	seen := make(map[*Element]bool)
+	for _, match := range index.eq.Keys(target) {
+		for _, element := range index.byMatch[match] {
+			if seen[element] == false && index.eq.Equivalent(element, target) == true {
+				seen[element] = true
+				found = append(found, element)
+			}
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return index.order[found[i]] < index.order[found[j]]
+	})
+	return found
+}
+
+// Contains checks if an element equivalent to target has been indexed
+func (index *Index) Contains(target *Element) bool {
+	_, ok := index.Find(target)
+	return ok
+}
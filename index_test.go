@@ -0,0 +1,194 @@
+//
+// index_test.go tests and benchmarks the index behind the set operations
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"fmt"
+	"testing"
+)
+
+// mkElements builds n articles numbered from start, every third one
+// delimits its title with quotes instead of curly brackets
+func mkElements(start int, n int) []*Element {
+	var elements []*Element
+	for i := start; i < start+n; i++ {
+		element := new(Element)
+		element.setType("article")
+		element.CiteKey = fmt.Sprintf("doiel%d", i)
+		element.Keys = []string{element.CiteKey}
+		element.addField("author", "{R. S. Doiel}")
+		if i%3 == 0 {
+			element.addField("title", fmt.Sprintf("\"Turtles %d\"", i))
+		} else {
+			element.addField("title", fmt.Sprintf("{Turtles %d}", i))
+		}
+		element.addField("year", fmt.Sprintf("%d", 1900+i%100))
+		elements = append(elements, element)
+	}
+	return elements
+}
+
+// The linear versions are the set operations as they were before Index,
+// they are the reference for the tests and benchmarks
+func linearJoin(elemList1, elemList2 []*Element) []*Element {
+	result := append([]*Element{}, elemList1...)
+	for _, elem := range elemList2 {
+		if Contains(result, elem) == false {
+			result = append(result, elem)
+		}
+	}
+	return result
+}
+
+func linearDiff(elemList1, elemList2 []*Element) []*Element {
+	var result []*Element
+	for _, elem := range elemList1 {
+		if Contains(elemList2, elem) == false {
+			result = append(result, elem)
+		}
+	}
+	return result
+}
+
+func linearIntersect(elemList1, elemList2 []*Element) []*Element {
+	var result []*Element
+	for _, elem := range elemList1 {
+		if Contains(elemList2, elem) == true {
+			result = append(result, elem)
+		}
+	}
+	return result
+}
+
+// sameElements checks two lists hold the same elements in the same order
+func sameElements(a []*Element, b []*Element) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestIndex checks lookups by citation key and by content
+func TestIndex(t *testing.T) {
+	elements := mkElements(0, 10)
+	index := NewIndex(elements)
+	if index.Len() != 10 {
+		t.Errorf("expected 10 elements, found %d", index.Len())
+	}
+	if found := index.Lookup("DOIEL3"); len(found) != 1 || found[0] != elements[3] {
+		t.Errorf("expected to find doiel3 ignoring case, found %v", found)
+	}
+	if found := index.Lookup("doiel10"); len(found) != 0 {
+		t.Errorf("expected no doiel10, found %v", found)
+	}
+
+	// A copy with curly brackets in place of quotes is Equal
+	elem := Clone(elements[3])
+	elem.Set("title", "{Turtles 3}")
+	elem.setType("ARTICLE")
+	if found, ok := index.Find(elem); ok == false || found != elements[3] {
+		t.Errorf("expected to find doiel3 by content")
+	}
+	if ContentHash(elem) != ContentHash(elements[3]) {
+		t.Errorf("expected Equal elements to have the same hash")
+	}
+	elem.Set("note", "{Added}")
+	if index.Contains(elem) == true {
+		t.Errorf("expected a changed element not to be found")
+	}
+	elem = Clone(elements[4])
+	elem.CiteKey = "Doiel4"
+	if index.Contains(elem) == true {
+		t.Errorf("expected Contains to compare citation keys exactly as Equal does")
+	}
+}
+
+// TestIndexedSetOperations checks the set operations agree with the
+// linear versions
+func TestIndexedSetOperations(t *testing.T) {
+	a := mkElements(0, 200)
+	b := mkElements(150, 200)
+	// Equal copies with a different delimiter and a duplicate in b
+	for i := 0; i < 200; i += 7 {
+		elem := Clone(b[i])
+		title, _ := elem.Get("title")
+		elem.Set("title", "{"+title[1:len(title)-1]+"}")
+		b[i] = elem
+	}
+	b = append(b, b[0])
+	if result, expected := Join(a, b), linearJoin(a, b); sameElements(result, expected) == false {
+		t.Errorf("Join: expected %d elements, found %d", len(expected), len(result))
+	}
+	if result, expected := Diff(a, b), linearDiff(a, b); sameElements(result, expected) == false {
+		t.Errorf("Diff: expected %d elements, found %d", len(expected), len(result))
+	}
+	if result, expected := Intersect(a, b), linearIntersect(a, b); sameElements(result, expected) == false {
+		t.Errorf("Intersect: expected %d elements, found %d", len(expected), len(result))
+	}
+	expected := linearJoin(linearDiff(a, b), linearDiff(b, a))
+	if result := Exclusive(a, b); sameElements(result, expected) == false {
+		t.Errorf("Exclusive: expected %d elements, found %d", len(expected), len(result))
+	}
+}
+
+// benchmarkSetOperation times op over two overlapping lists of n elements
+func benchmarkSetOperation(b *testing.B, op func([]*Element, []*Element) []*Element) {
+	for _, n := range []int{100, 1000, 5000} {
+		list1 := mkElements(0, n)
+		list2 := mkElements(n/2, n)
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				op(list1, list2)
+			}
+		})
+	}
+}
+
+func BenchmarkJoin(b *testing.B) {
+	benchmarkSetOperation(b, Join)
+}
+
+func BenchmarkJoinLinear(b *testing.B) {
+	benchmarkSetOperation(b, linearJoin)
+}
+
+func BenchmarkDiff(b *testing.B) {
+	benchmarkSetOperation(b, Diff)
+}
+
+func BenchmarkDiffLinear(b *testing.B) {
+	benchmarkSetOperation(b, linearDiff)
+}
+
+func BenchmarkIntersect(b *testing.B) {
+	benchmarkSetOperation(b, Intersect)
+}
+
+func BenchmarkIntersectLinear(b *testing.B) {
+	benchmarkSetOperation(b, linearIntersect)
+}
+
+func BenchmarkExclusive(b *testing.B) {
+	benchmarkSetOperation(b, Exclusive)
+}
@@ -0,0 +1,322 @@
+//
+// json.go implements the JSON form of BibTeX nodes, see JSON.md
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The kinds of node in JSON
+const (
+	jsonEntry    = "entry"
+	jsonComment  = "comment"
+	jsonPreamble = "preamble"
+	jsonText     = "text"
+)
+
+// jsonField is a tag in JSON, Value is the raw value as written in
+// BibTeX including delimiters, macros and concatenations
+type jsonField struct {
+	Name     string  `json:"name"`
+	Value    string  `json:"value"`
+	Resolved *string `json:"resolved,omitempty"`
+}
+
+// jsonNode is any node in JSON, Kind says which
+type jsonNode struct {
+	Kind      string       `json:"kind"`
+	Type      string       `json:"type,omitempty"`
+	Key       string       `json:"key,omitempty"`
+	Fields    []*jsonField `json:"fields,omitempty"`
+	Malformed []string     `json:"malformed,omitempty"`
+	Text      *string      `json:"text,omitempty"`
+	Space     string       `json:"space,omitempty"`
+	Newline   bool         `json:"newline,omitempty"`
+}
+
+// MarshalJSON writes an element with its tags in order, each with its
+// raw value and its resolved value if it has been resolved
+func (element *Element) MarshalJSON() ([]byte, error) {
+	node := &jsonNode{
+		Kind:      jsonEntry,
+		Type:      element.typeName(),
+		Key:       element.CiteKey,
+		Malformed: element.Malformed(),
+	}
+	fields := element.Fields()
+	last := make(map[string]int)
+	for i, field := range fields {
+		last[strings.ToLower(field.Name)] = i
+	}
+	for i, field := range fields {
+		f := &jsonField{Name: field.Name, Value: field.Value}
+		// The resolved value belongs to the last of a repeated tag
+		if resolved, ok := element.Resolved[strings.ToLower(field.Name)]; ok == true && last[strings.ToLower(field.Name)] == i {
+			f.Resolved = &resolved
+		}
+		node.Fields = append(node.Fields, f)
+	}
+	if node.Fields == nil {
+		node.Fields = []*jsonField{}
+	}
+	return json.Marshal(node)
+}
+
+// UnmarshalJSON reads an element written by MarshalJSON
+func (element *Element) UnmarshalJSON(src []byte) error {
+	node := new(jsonNode)
+	if err := json.Unmarshal(src, node); err != nil {
+		return err
+	}
+	if node.Kind != jsonEntry {
+		return fmt.Errorf("expected an entry, found %q", node.Kind)
+	}
+	if node.Type == "" {
+		return fmt.Errorf("entry %q is missing its type", node.Key)
+	}
+	*element = Element{}
+	element.setType(node.Type)
+	element.CiteKey = node.Key
+	if node.Key != "" {
+		element.Keys = append(element.Keys, node.Key)
+	}
+	element.Keys = append(element.Keys, node.Malformed...)
+	element.Tags = make(map[string]string)
+	for _, field := range node.Fields {
+		if field.Name == "" {
+			return fmt.Errorf("entry %q has a tag without a name", node.Key)
+		}
+		element.addField(field.Name, field.Value)
+		if field.Resolved != nil {
+			if element.Resolved == nil {
+				element.Resolved = make(map[string]string)
+			}
+			element.Resolved[strings.ToLower(field.Name)] = *field.Resolved
+		}
+	}
+	return nil
+}
+
+// MarshalJSON writes a comment, free text between entries has the kind
+// text
+func (comment *Comment) MarshalJSON() ([]byte, error) {
+	node := &jsonNode{Kind: jsonComment, Type: comment.Type, Text: &comment.Text, Space: comment.Space, Newline: comment.Newline}
+	if comment.Implicit == true {
+		node.Kind = jsonText
+	}
+	return json.Marshal(node)
+}
+
+// UnmarshalJSON reads a comment or free text written by MarshalJSON
+func (comment *Comment) UnmarshalJSON(src []byte) error {
+	node := new(jsonNode)
+	if err := json.Unmarshal(src, node); err != nil {
+		return err
+	}
+	if node.Kind != jsonComment && node.Kind != jsonText {
+		return fmt.Errorf("expected a comment, found %q", node.Kind)
+	}
+	*comment = Comment{Type: node.Type, Implicit: node.Kind == jsonText, Space: node.Space, Newline: node.Newline}
+	if node.Text != nil {
+		comment.Text = *node.Text
+	}
+	return nil
+}
+
+// MarshalJSON writes a preamble
+func (preamble *Preamble) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&jsonNode{Kind: jsonPreamble, Type: preamble.Type, Text: &preamble.Text, Space: preamble.Space, Newline: preamble.Newline})
+}
+
+// UnmarshalJSON reads a preamble written by MarshalJSON
+func (preamble *Preamble) UnmarshalJSON(src []byte) error {
+	node := new(jsonNode)
+	if err := json.Unmarshal(src, node); err != nil {
+		return err
+	}
+	if node.Kind != jsonPreamble {
+		return fmt.Errorf("expected a preamble, found %q", node.Kind)
+	}
+	*preamble = Preamble{Type: node.Type, Space: node.Space, Newline: node.Newline}
+	if node.Text != nil {
+		preamble.Text = *node.Text
+	}
+	return nil
+}
+
+// MarshalNodes writes nodes, e.g. from ParseNodes, as a JSON array. The
+// output is indented and the same nodes always give the same bytes.
+func MarshalNodes(nodes []Node) ([]byte, error) {
+	if nodes == nil {
+		nodes = []Node{}
+	}
+	return json.MarshalIndent(nodes, "", "  ")
+}
+
+// UnmarshalNode reads one node written by MarshalJSON, returning an
+// *Element, *Comment or *Preamble by its kind
+func UnmarshalNode(src []byte) (Node, error) {
+	var (
+		peek struct {
+			Kind string `json:"kind"`
+		}
+		node interface {
+			Node
+			json.Unmarshaler
+		}
+	)
+
+	if err := json.Unmarshal(src, &peek); err != nil {
+		return nil, err
+	}
+	switch peek.Kind {
+	case jsonEntry:
+		node = new(Element)
+	case jsonComment, jsonText:
+		node = new(Comment)
+	case jsonPreamble:
+		node = new(Preamble)
+	default:
+		return nil, fmt.Errorf("unknown kind of node %q", peek.Kind)
+	}
+	if err := node.UnmarshalJSON(src); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// UnmarshalNodes reads a JSON array written by MarshalNodes
+func UnmarshalNodes(src []byte) ([]Node, error) {
+	var nodes []Node
+
+	dec := NewJSONDecoder(bytes.NewReader(src))
+	for {
+		node, err := dec.Decode()
+		switch {
+		case err == io.EOF:
+			return nodes, nil
+		case err == io.ErrUnexpectedEOF || err == errNotArray || err == errTrailingData:
+			return nodes, err
+		case err != nil:
+			return nodes, fmt.Errorf("node %d: %s", len(nodes)+1, err)
+		}
+		nodes = append(nodes, node)
+	}
+}
+
+// JSONEncoder writes nodes to an output stream one at a time as a JSON
+// array, the output is the same as MarshalNodes followed by a new line
+type JSONEncoder struct {
+	w     io.Writer
+	count int
+}
+
+// NewJSONEncoder returns a new encoder that writes to w
+func NewJSONEncoder(w io.Writer) *JSONEncoder {
+	return &JSONEncoder{w: w}
+}
+
+// Encode writes a node as the next item of the array
+func (enc *JSONEncoder) Encode(node Node) error {
+	src, err := json.MarshalIndent(node, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	sep := ",\n  "
+	if enc.count == 0 {
+		sep = "[\n  "
+	}
+	enc.count++
+	_, err = enc.w.Write(append([]byte(sep), src...))
+	return err
+}
+
+// Close ends the array, call it once every node has been written
+func (enc *JSONEncoder) Close() error {
+	end := "\n]\n"
+	if enc.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(enc.w, end)
+	return err
+}
+
+// errNotArray is returned when the JSON read isn't an array of nodes
+var errNotArray = errors.New("expected a JSON array of nodes")
+
+// errTrailingData is returned when something follows the array of nodes
+var errTrailingData = errors.New("unexpected data after the JSON array of nodes")
+
+// JSONDecoder reads the nodes of a JSON array one at a time from an
+// input stream
+type JSONDecoder struct {
+	dec     *json.Decoder
+	started bool
+	done    bool
+}
+
+// NewJSONDecoder returns a new decoder that reads from r
+func NewJSONDecoder(r io.Reader) *JSONDecoder {
+	return &JSONDecoder{dec: json.NewDecoder(r)}
+}
+
+// Decode reads the next node of the array, it returns io.EOF after
+// the last one. Input that ends before the array is closed returns
+// io.ErrUnexpectedEOF, anything but white space after it is an error.
+func (dec *JSONDecoder) Decode() (Node, error) {
+	if dec.done == true {
+		return nil, io.EOF
+	}
+	if dec.started == false {
+		token, err := dec.dec.Token()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if delim, ok := token.(json.Delim); ok == false || delim != '[' {
+			return nil, errNotArray
+		}
+		dec.started = true
+	}
+	if dec.dec.More() == false {
+		// Read the closing square bracket
+		if _, err := dec.dec.Token(); err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		if _, err := dec.dec.Token(); err != io.EOF {
+			return nil, errTrailingData
+		}
+		dec.done = true
+		return nil, io.EOF
+	}
+	var src json.RawMessage
+	if err := dec.dec.Decode(&src); err != nil {
+		return nil, err
+	}
+	return UnmarshalNode(src)
+}
@@ -0,0 +1,238 @@
+//
+// json_test.go tests the JSON form of BibTeX nodes
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+const jsonSample = `Free text before the entries
+
+@string{rsdoiel = "R. S. Doiel"}
+@preamble{"\newcommand{\noop}[1]{}"}
+@comment{Not an entry}
+
+@Article{doiel2016,
+    author = rsdoiel # " and Mark Doiel",
+    title = {Turtles},
+    title = {Turtles all the way down},
+    stray,
+}
+`
+
+// TestMarshalNodes checks the JSON form of each kind of node
+func TestMarshalNodes(t *testing.T) {
+	nodes, err := ParseNodes([]byte(jsonSample), Options{})
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	var elements []*Element
+	for _, node := range nodes {
+		if element, ok := node.(*Element); ok == true {
+			elements = append(elements, element)
+		}
+	}
+	Resolve(elements)
+	src, err := MarshalNodes(nodes)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	expected := `[
+  {
+    "kind": "text",
+    "text": "Free text before the entries\n\n"
+  },
+  {
+    "kind": "entry",
+    "type": "string",
+    "fields": [
+      {
+        "name": "rsdoiel",
+        "value": "\"R. S. Doiel\"",
+        "resolved": "R. S. Doiel"
+      }
+    ]
+  },
+  {
+    "kind": "text",
+    "text": "\n"
+  },
+  {
+    "kind": "preamble",
+    "type": "preamble",
+    "text": "\"\\newcommand{\\noop}[1]{}\"",
+    "newline": true
+  },
+  {
+    "kind": "comment",
+    "type": "comment",
+    "text": "Not an entry",
+    "newline": true
+  },
+  {
+    "kind": "text",
+    "text": "\n"
+  },
+  {
+    "kind": "entry",
+    "type": "Article",
+    "key": "doiel2016",
+    "fields": [
+      {
+        "name": "author",
+        "value": "rsdoiel # \" and Mark Doiel\"",
+        "resolved": "R. S. Doiel and Mark Doiel"
+      },
+      {
+        "name": "title",
+        "value": "{Turtles}"
+      },
+      {
+        "name": "title",
+        "value": "{Turtles all the way down}",
+        "resolved": "Turtles all the way down"
+      }
+    ],
+    "malformed": [
+      "stray"
+    ]
+  },
+  {
+    "kind": "text",
+    "text": "\n"
+  }
+]`
+	if string(src) != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, src)
+	}
+
+	// Streaming gives the same JSON
+	var buf bytes.Buffer
+	enc := NewJSONEncoder(&buf)
+	for _, node := range nodes {
+		enc.Encode(node)
+	}
+	enc.Close()
+	if buf.String() != expected+"\n" {
+		t.Errorf("expected the JSONEncoder to match, found\n%s", buf.String())
+	}
+	buf.Reset()
+	NewJSONEncoder(&buf).Close()
+	if buf.String() != "[]\n" {
+		t.Errorf("expected an empty array, found %s", buf.String())
+	}
+
+	for _, bad := range []string{`[{"kind": "book"}]`, `[{"kind": "entry", "key": "k1"}]`, `[{"kind": "entry", "type": "misc", "fields": [{"value": "1"}]}]`, `{}`, `[{"kind": "comment"}`} {
+		if _, err := UnmarshalNodes([]byte(bad)); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+	element := new(Element)
+	if err := json.Unmarshal([]byte(`{"kind": "entry", "type": "misc", "key": "m1", "fields": [{"name": "Title", "value": "{Snails}"}]}`), element); err != nil {
+		t.Errorf("%s", err)
+	}
+	if val, _ := element.Get("title"); element.CiteKey != "m1" || val != "{Snails}" || element.Resolved != nil {
+		t.Errorf("expected misc m1 with a title, found %+v", element)
+	}
+}
+
+// TestJSONRoundTrip checks the samples come back from JSON unchanged
+func TestJSONRoundTrip(t *testing.T) {
+	fnames := []string{"sample1.bib", "sample2.bib", "sample3a.bib", "sample3b.bib", "lint.bib"}
+	for _, fname := range fnames {
+		src, err := ioutil.ReadFile(path.Join("testdata", fname))
+		if err != nil {
+			t.Errorf("%s", err)
+			t.FailNow()
+		}
+		nodes, _ := ParseNodes(src, Options{Recover: true})
+		var elements []*Element
+		for _, node := range nodes {
+			if element, ok := node.(*Element); ok == true {
+				elements = append(elements, element)
+			}
+		}
+		Resolve(elements)
+
+		data, err := MarshalNodes(nodes)
+		if err != nil {
+			t.Errorf("%s: %s", fname, err)
+			continue
+		}
+		decoded, err := UnmarshalNodes(data)
+		if err != nil {
+			t.Errorf("%s: %s", fname, err)
+			continue
+		}
+		again, _ := MarshalNodes(decoded)
+		if bytes.Equal(data, again) == false {
+			t.Errorf("%s: expected the same JSON after a round trip, found\n%s\n%s", fname, data, again)
+		}
+		if len(decoded) != len(nodes) {
+			t.Errorf("%s: expected %d nodes, found %d", fname, len(nodes), len(decoded))
+			continue
+		}
+		for i, node := range nodes {
+			if node.String() != decoded[i].String() {
+				t.Errorf("%s: node %d expected\n%s\nfound\n%s", fname, i, node, decoded[i])
+			}
+			if element, ok := node.(*Element); ok == true {
+				if Equal(element, decoded[i].(*Element)) == false {
+					t.Errorf("%s: expected %s to be equal after a round trip", fname, element.CiteKey)
+				}
+			}
+		}
+	}
+}
+
+// TestUnmarshalNodesErrors checks empty, truncated and trailing input
+// are reported
+func TestUnmarshalNodesErrors(t *testing.T) {
+	for _, test := range []struct {
+		src string
+		err error
+	}{
+		{"", io.ErrUnexpectedEOF},
+		{"  \n", io.ErrUnexpectedEOF},
+		{"[] garbage", errTrailingData},
+		{"[] []", errTrailingData},
+		{"{}", errNotArray},
+	} {
+		if _, err := UnmarshalNodes([]byte(test.src)); err != test.err {
+			t.Errorf("%q expected %v, found %v", test.src, test.err, err)
+		}
+	}
+	for _, src := range []string{"[", `[{"kind": "comment", "text": "hello"}`, `[{"kind": "comment", "text": "hello"},`} {
+		if _, err := UnmarshalNodes([]byte(src)); err == nil {
+			t.Errorf("%q expected an error for the truncated array", src)
+		}
+	}
+	for _, src := range []string{"[]", " [ ] \n"} {
+		if nodes, err := UnmarshalNodes([]byte(src)); err != nil || len(nodes) != 0 {
+			t.Errorf("%q expected no nodes, found %v, %v", src, nodes, err)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+//
+// Package latex converts BibTeX field values between LaTeX markup and
+// Unicode text
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package latex
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// accents maps LaTeX accent commands to Unicode combining characters
+	accents = map[string]rune{
+		"'":  '\u0301',
+		"`":  '\u0300',
+		"^":  '\u0302',
+		"\"": '\u0308',
+		"~":  '\u0303',
+		"=":  '\u0304',
+		".":  '\u0307',
+		"u":  '\u0306',
+		"v":  '\u030c',
+		"H":  '\u030b',
+		"c":  '\u0327',
+		"k":  '\u0328',
+		"r":  '\u030a',
+		"d":  '\u0323',
+	}
+
+	// composed lists the precomposed letters for each accent command as
+	// pairs of base letter and accented letter
+	composed = map[string]string{
+		"'":  "AÁaáCĆcćEÉeéGǴgǵIÍiíKḰkḱLĹlĺNŃnńOÓoóRŔrŕSŚsśUÚuúWẂwẃYÝyýZŹzźMḾmḿPṔpṕ",
+		"`":  "AÀaàEÈeèIÌiìNǸnǹOÒoòUÙuùWẀwẁYỲyỳ",
+		"^":  "AÂaâCĈcĉEÊeêGĜgĝHĤhĥIÎiîJĴjĵOÔoôSŜsŝUÛuûWŴwŵYŶyŷZẐzẑ",
+		"\"": "AÄaäEËeëHḦhḧIÏiïOÖoötẗUÜuüWẄwẅYŸyÿXẌxẍ",
+		"~":  "AÃaãEẼeẽIĨiĩNÑnñOÕoõUŨuũYỸyỹVṼvṽ",
+		"=":  "AĀaāEĒeēGḠgḡIĪiīOŌoōUŪuūYȲyȳ",
+		".":  "AȦaȧCĊcċDḊdḋEĖeėGĠgġHḢhḣIİNṄnṅOȮoȯRṘrṙSṠsṡTṪtṫWẆwẇYẎyẏZŻzżBḂbḃFḞfḟMṀmṁPṖpṗXẊxẋ",
+		"u":  "AĂaăEĔeĕGĞgğIĬiĭOŎoŏUŬuŭ",
+		"v":  "AǍaǎCČcčDĎdďEĚeěGǦgǧHȞhȟIǏiǐjǰKǨkǩLĽlľNŇnňOǑoǒRŘrřSŠsšTŤtťUǓuǔZŽzž",
+		"H":  "OŐoőUŰuű",
+		"c":  "CÇcçDḐdḑEȨeȩGĢgģHḨhḩKĶkķLĻlļNŅnņRŖrŗSŞsşTŢtţ",
+		"k":  "AĄaąEĘeęIĮiįOǪoǫUŲuų",
+		"r":  "AÅaåUŮuůwẘyẙ",
+		"d":  "AẠaạDḌdḍEẸeẹHḤhḥIỊiịKḲkḳLḶlḷNṆnṇOỌoọRṚrṛSṢsṣTṬtṭUỤuụWẈwẉYỴyỵZẒzẓBḄbḅMṂmṃVṾvṿ",
+	}
+
+	// symbols are text mode commands without arguments, the first
+	// command listed for a character is used when converting to LaTeX
+	symbols = [][2]string{
+		{"ss", "ß"}, {"ae", "æ"}, {"AE", "Æ"}, {"oe", "œ"}, {"OE", "Œ"},
+		{"aa", "å"}, {"AA", "Å"}, {"o", "ø"}, {"O", "Ø"}, {"l", "ł"}, {"L", "Ł"},
+		{"i", "ı"}, {"j", "ȷ"}, {"dh", "ð"}, {"DH", "Ð"}, {"th", "þ"}, {"TH", "Þ"},
+		{"ng", "ŋ"}, {"NG", "Ŋ"},
+		{"ldots", "…"}, {"dots", "…"}, {"textellipsis", "…"},
+		{"textendash", "–"}, {"textemdash", "—"},
+		{"copyright", "©"}, {"textcopyright", "©"}, {"textregistered", "®"},
+		{"texttrademark", "™"}, {"S", "§"}, {"P", "¶"}, {"dag", "†"}, {"ddag", "‡"},
+		{"pounds", "£"}, {"textsterling", "£"}, {"euro", "€"}, {"texteuro", "€"},
+		{"textdegree", "°"}, {"textbullet", "•"}, {"textperiodcentered", "·"},
+		{"guillemotleft", "«"}, {"guillemotright", "»"},
+		{"textquoteleft", "‘"}, {"textquoteright", "’"},
+		{"textquotedblleft", "“"}, {"textquotedblright", "”"},
+		{"textexclamdown", "¡"}, {"textquestiondown", "¿"},
+	}
+
+	// ligatures are the character sequences TeX fonts turn into
+	// punctuation, they are preferred to symbols when converting to LaTeX
+	ligatures = [][2]string{
+		{"---", "—"}, {"--", "–"}, {"``", "“"}, {"''", "”"}, {"!`", "¡"}, {"?`", "¿"},
+	}
+
+	// mathSymbols are math mode commands, converted when a formula has
+	// nothing else that needs LaTeX
+	mathSymbols = [][2]string{
+		{"alpha", "α"}, {"beta", "β"}, {"gamma", "γ"}, {"delta", "δ"},
+		{"varepsilon", "ε"}, {"epsilon", "ϵ"}, {"zeta", "ζ"}, {"eta", "η"},
+		{"theta", "θ"}, {"vartheta", "ϑ"}, {"iota", "ι"}, {"kappa", "κ"},
+		{"lambda", "λ"}, {"mu", "μ"}, {"nu", "ν"}, {"xi", "ξ"}, {"pi", "π"},
+		{"varpi", "ϖ"}, {"rho", "ρ"}, {"varrho", "ϱ"}, {"sigma", "σ"},
+		{"varsigma", "ς"}, {"tau", "τ"}, {"upsilon", "υ"}, {"varphi", "φ"},
+		{"phi", "ϕ"}, {"chi", "χ"}, {"psi", "ψ"}, {"omega", "ω"},
+		{"Gamma", "Γ"}, {"Delta", "Δ"}, {"Theta", "Θ"}, {"Lambda", "Λ"},
+		{"Xi", "Ξ"}, {"Pi", "Π"}, {"Sigma", "Σ"}, {"Upsilon", "Υ"},
+		{"Phi", "Φ"}, {"Psi", "Ψ"}, {"Omega", "Ω"},
+		{"pm", "±"}, {"mp", "∓"}, {"times", "×"}, {"div", "÷"}, {"cdot", "⋅"},
+		{"leq", "≤"}, {"le", "≤"}, {"geq", "≥"}, {"ge", "≥"}, {"neq", "≠"},
+		{"ne", "≠"}, {"approx", "≈"}, {"sim", "∼"}, {"equiv", "≡"},
+		{"infty", "∞"}, {"partial", "∂"}, {"nabla", "∇"}, {"sum", "∑"},
+		{"prod", "∏"}, {"int", "∫"}, {"surd", "√"}, {"circ", "∘"},
+		{"ell", "ℓ"}, {"hbar", "ℏ"}, {"in", "∈"}, {"notin", "∉"},
+		{"subset", "⊂"}, {"subseteq", "⊆"}, {"cup", "∪"}, {"cap", "∩"},
+		{"forall", "∀"}, {"exists", "∃"}, {"emptyset", "∅"}, {"neg", "¬"},
+		{"rightarrow", "→"}, {"to", "→"}, {"leftarrow", "←"},
+		{"leftrightarrow", "↔"}, {"Rightarrow", "⇒"}, {"Leftarrow", "⇐"},
+		{"Leftrightarrow", "⇔"},
+	}
+
+	// escaped are LaTeX's special characters, written with a backslash
+	// outside of math
+	escaped = "&%#_"
+
+	textToLaTeX     = make(map[rune]string)
+	mathToLaTeX     = make(map[rune]string)
+	symbolToUnicode = make(map[string]string)
+	mathToUnicode   = make(map[string]string)
+	combining       = make(map[rune]string)
+)
+
+func init() {
+	for cmd, mark := range accents {
+		combining[mark] = cmd
+		for _, pair := range pairs(composed[cmd]) {
+			textToLaTeX[pair[1]] = accentCommand(cmd, string(pair[0]))
+		}
+	}
+	for _, symbol := range symbols {
+		r, _ := utf8.DecodeRuneInString(symbol[1])
+		if _, ok := textToLaTeX[r]; ok == false {
+			textToLaTeX[r] = "{\\" + symbol[0] + "}"
+		}
+		symbolToUnicode[symbol[0]] = symbol[1]
+	}
+	for _, ligature := range ligatures {
+		r, _ := utf8.DecodeRuneInString(ligature[1])
+		textToLaTeX[r] = ligature[0]
+	}
+	// A tie is a no-break space
+	textToLaTeX['\u00a0'] = "~"
+	for _, symbol := range mathSymbols {
+		r, _ := utf8.DecodeRuneInString(symbol[1])
+		if _, ok := mathToLaTeX[r]; ok == false {
+			mathToLaTeX[r] = "\\" + symbol[0]
+		}
+		mathToUnicode[symbol[0]] = symbol[1]
+	}
+}
+
+// pairs splits a string of base and accented letters into rune pairs
+func pairs(s string) [][2]rune {
+	var out [][2]rune
+	runes := []rune(s)
+	for i := 0; i+1 < len(runes); i += 2 {
+		out = append(out, [2]rune{runes[i], runes[i+1]})
+	}
+	return out
+}
+
+// accentCommand writes an accented letter in LaTeX, e.g. {\"o} or {\c{c}}
+func accentCommand(cmd string, base string) string {
+	if isLetter(cmd[0]) == true {
+		return "{\\" + cmd + "{" + base + "}}"
+	}
+	return "{\\" + cmd + base + "}"
+}
+
+// isLetter checks for an ASCII letter
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isSpace checks for white space TeX skips after a command
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// matchBrace returns the index of the curly bracket closing the one at
+// start or -1
+func matchBrace(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// compose applies an accent to a letter, falling back to a combining
+// character when there is no precomposed letter
+func compose(cmd string, base rune) string {
+	for _, pair := range pairs(composed[cmd]) {
+		if pair[0] == base {
+			return string(pair[1])
+		}
+	}
+	return string(base) + string(accents[cmd])
+}
+
+// accentArgument reads the letter an accent applies to, e.g. the o in
+// {o}, o or {\i}, returning it and the length read
+func accentArgument(s string) (rune, int, bool) {
+	i := 0
+	for i < len(s) && isSpace(s[i]) == true {
+		i++
+	}
+	arg := s[i:]
+	n := i
+	if strings.HasPrefix(arg, "{") == true {
+		end := matchBrace(arg, 0)
+		if end < 0 {
+			return 0, 0, false
+		}
+		arg = strings.TrimSpace(arg[1:end])
+		n += end + 1
+		if arg == "\\i" || arg == "\\j" {
+			return rune(arg[1]), n, true
+		}
+		if r, size := utf8.DecodeRuneInString(arg); size > 0 && size == len(arg) && r != '\\' {
+			return r, n, true
+		}
+		return 0, 0, false
+	}
+	if (strings.HasPrefix(arg, "\\i") || strings.HasPrefix(arg, "\\j")) && (len(arg) == 2 || isLetter(arg[2]) == false) {
+		return rune(arg[1]), n + 2, true
+	}
+	if len(arg) > 0 && isLetter(arg[0]) == true {
+		return rune(arg[0]), n + 1, true
+	}
+	return 0, 0, false
+}
+
+// command converts the LaTeX command at the start of s, returning the
+// text and the length read. Commands it doesn't know are kept as is.
+func command(s string) (string, int) {
+	if len(s) < 2 {
+		return s, len(s)
+	}
+	name := s[1:2]
+	if isLetter(s[1]) == true {
+		i := 1
+		for i < len(s) && isLetter(s[i]) == true {
+			i++
+		}
+		name = s[1:i]
+	}
+	n := 1 + len(name)
+	if _, ok := accents[name]; ok == true {
+		if r, size, ok := accentArgument(s[n:]); ok == true {
+			return compose(name, r), n + size
+		}
+		return s[:n], n
+	}
+	text, ok := symbolToUnicode[name]
+	if ok == false {
+		return s[:n], n
+	}
+	// TeX skips the space or empty group following a command name
+	if strings.HasPrefix(s[n:], "{}") == true {
+		n += 2
+	} else {
+		for n < len(s) && isSpace(s[n]) == true {
+			n++
+		}
+	}
+	return text, n
+}
+
+// mathToText converts a formula, without its dollar signs, that only
+// uses known symbols, letters, digits and simple operators
+func mathToText(s string) (string, bool) {
+	var out bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\':
+			j := i + 1
+			for j < len(s) && isLetter(s[j]) == true {
+				j++
+			}
+			text, ok := mathToUnicode[s[i+1:j]]
+			if ok == false {
+				return "", false
+			}
+			out.WriteString(text)
+			for j < len(s) && isSpace(s[j]) == true {
+				j++
+			}
+			i = j - 1
+		case c == '^' || c == '_' || c == '{' || c == '}' || c == '&':
+			return "", false
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String(), true
+}
+
+// ToUnicode converts the LaTeX in a value to Unicode, e.g. {\"o} and
+// \"{o} become ö, \ss{} becomes ß, -- becomes an en dash and $\alpha$
+// becomes α. Curly brackets protecting text from case changes and
+// LaTeX's escaped special characters, e.g. \&, are kept so the result
+// is still valid in a BibTeX file. Commands and formulas it doesn't know
+// are left as is.
+func ToUnicode(s string) string {
+	var out bytes.Buffer
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte(escaped+"${}\\", s[i+1]) >= 0:
+			out.WriteString(s[i : i+2])
+			i += 2
+		case c == '\\':
+			text, n := command(s[i:])
+			out.WriteString(text)
+			i += n
+		case c == '{':
+			end := matchBrace(s, i)
+			if end < 0 {
+				out.WriteString(s[i:])
+				i = len(s)
+				continue
+			}
+			inner := s[i+1 : end]
+			text := ToUnicode(inner)
+			// A group holding a special character, e.g. {\"o}, is
+			// replaced by the character
+			if strings.HasPrefix(inner, "\\") == true && strings.ContainsAny(text, "\\{}") == false {
+				out.WriteString(text)
+			} else {
+				out.WriteString("{" + text + "}")
+			}
+			i = end + 1
+		case c == '$':
+			end := strings.IndexByte(s[i+1:], '$')
+			if end < 0 {
+				out.WriteString(s[i:])
+				i = len(s)
+				continue
+			}
+			formula := s[i : i+end+2]
+			if text, ok := mathToText(formula[1 : len(formula)-1]); ok == true {
+				out.WriteString(text)
+			} else {
+				out.WriteString(formula)
+			}
+			i += len(formula)
+		default:
+			found := false
+			for _, ligature := range ligatures {
+				if strings.HasPrefix(s[i:], ligature[0]) == true {
+					out.WriteString(ligature[1])
+					i += len(ligature[0])
+					found = true
+					break
+				}
+			}
+			if found == false {
+				out.WriteByte(c)
+				i++
+			}
+		}
+	}
+	return out.String()
+}
+
+// ToLaTeX converts a value to ASCII, writing accented letters, ligatures,
+// dashes, quotes and symbols as LaTeX commands, e.g. ö becomes {\"o} and
+// α becomes $\alpha$. &, %, # and _ outside of math are escaped, LaTeX
+// already in the value is kept. Characters it has no command for are
+// left as is.
+func ToLaTeX(s string) string {
+	var out bytes.Buffer
+
+	math := false
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		next, nextSize := utf8.DecodeRuneInString(s[i+size:])
+		switch {
+		case r == '\\' && i+1 < len(s):
+			// Keep commands and escapes
+			_, n := utf8.DecodeRuneInString(s[i+1:])
+			out.WriteString(s[i : i+1+n])
+			i += 1 + n
+			continue
+		case r == '$':
+			math = !math
+			out.WriteRune(r)
+		case combining[next] != "" && r != utf8.RuneError:
+			base := string(r)
+			if r == 'i' || r == 'j' {
+				base = "\\" + base
+			}
+			out.WriteString(accentCommand(combining[next], base))
+			i += size + nextSize
+			continue
+		case r < utf8.RuneSelf && math == false && strings.ContainsRune(escaped, r) == true:
+			out.WriteString("\\" + string(r))
+		case r < utf8.RuneSelf:
+			out.WriteRune(r)
+		case textToLaTeX[r] != "":
+			out.WriteString(textToLaTeX[r])
+		case mathToLaTeX[r] != "":
+			var formula []string
+			for ; i < len(s); i += size {
+				r, size = utf8.DecodeRuneInString(s[i:])
+				if mathToLaTeX[r] == "" {
+					break
+				}
+				formula = append(formula, mathToLaTeX[r])
+			}
+			if math == true {
+				out.WriteString(strings.Join(formula, " ") + " ")
+			} else {
+				out.WriteString("$" + strings.Join(formula, "") + "$")
+			}
+			continue
+		default:
+			out.WriteRune(r)
+		}
+		i += size
+	}
+	return out.String()
+}
+
+// ToText converts a value to plain Unicode text for searching or
+// export, as ToUnicode does, then drops the curly brackets and the
+// backslash of escaped characters and turns ties into spaces
+func ToText(s string) string {
+	var out bytes.Buffer
+
+	s = ToUnicode(s)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte(escaped+"${}\\", s[i+1]) >= 0:
+			out.WriteByte(s[i+1])
+			i++
+		case c == '{' || c == '}':
+		case c == '~':
+			out.WriteByte(' ')
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,125 @@
+//
+// latex_test.go tests converting between LaTeX and Unicode
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package latex
+
+import (
+	"testing"
+)
+
+// TestToUnicode checks accents, ligatures, dashes, symbols and math
+func TestToUnicode(t *testing.T) {
+	testData := map[string]string{
+		`G{\"o}del`:                "Gödel",
+		`G\"{o}del`:                "Gödel",
+		`G\"odel`:                  "Gödel",
+		`{\'E}cole`:                "École",
+		`\'{E}cole`:                "École",
+		`Stra\ss{}e`:               "Straße",
+		`Stra{\ss}e`:               "Straße",
+		`\c{c}a`:                   "ça",
+		`\c ca`:                    "ça",
+		`Ant\'{\i}o`:               "Antío",
+		`{\o}re`:                   "øre",
+		`Erd\H{o}s`:                "Erdős",
+		`\v{S}koda`:                "Škoda",
+		`\"q`:                      "q\u0308",
+		`pages 1--10`:              "pages 1–10",
+		`yes---no`:                 "yes—no",
+		"``quoted''":               "“quoted”",
+		`$\alpha$-helix`:           "α-helix",
+		`$\alpha \beta$ and $x^2$`: "αβ and $x^2$",
+		`Turtles \& {DNA}`:         `Turtles \& {DNA}`,
+		`{Barnes and Noble}`:       "{Barnes and Noble}",
+		`{\em Turtles}`:            `{\em Turtles}`,
+		`\ldots and \ldots{} or`:   "…and … or",
+		`\unknown{x}`:              `\unknown{x}`,
+		`Donald~E. Knuth`:          "Donald~E. Knuth",
+		`\~{}rsdoiel`:              `\~{}rsdoiel`,
+		`{\"{O}}zt{\"u}rk {IEEE}`:  "Öztürk {IEEE}",
+		`unbalanced {\"o`:          `unbalanced {\"o`,
+		`costs \$5 and $\pm$ 5\%`:  `costs \$5 and ± 5\%`,
+		`\copyright\ 2016 \S 3`:    `©\ 2016 §3`,
+	}
+	for src, expected := range testData {
+		if result := ToUnicode(src); result != expected {
+			t.Errorf("%q: expected %q, found %q", src, expected, result)
+		}
+	}
+}
+
+// TestToLaTeX checks Unicode is written as ASCII LaTeX
+func TestToLaTeX(t *testing.T) {
+	testData := map[string]string{
+		"Gödel":             `G{\"o}del`,
+		"École":             `{\'E}cole`,
+		"Straße":            `Stra{\ss}e`,
+		"ça":                `{\c{c}}a`,
+		"Antío":             `Ant{\'i}o`,
+		"q\u0308":           `{\"q}`,
+		"i\u0301":           `{\'\i}`,
+		"1–10":              "1--10",
+		"yes—no":            "yes---no",
+		"“quoted”":          "``quoted''",
+		"α-helix":           `$\alpha$-helix`,
+		"αβ and $x^2$":      `$\alpha\beta$ and $x^2$`,
+		"$α + β_1$":         `$\alpha  + \beta _1$`,
+		"Turtles & {DNA}":   `Turtles \& {DNA}`,
+		`Turtles \& 5\%`:    `Turtles \& 5\%`,
+		"50% of #1_a":       `50\% of \#1\_a`,
+		"Donald\u00a0Knuth": "Donald~Knuth",
+		"© 2016":            `{\copyright} 2016`,
+		"Plain ASCII":       "Plain ASCII",
+		"日本":                "日本",
+	}
+	for src, expected := range testData {
+		if result := ToLaTeX(src); result != expected {
+			t.Errorf("%q: expected %q, found %q", src, expected, result)
+		}
+	}
+}
+
+// TestRoundTrip checks converting to Unicode and back keeps the text
+func TestRoundTrip(t *testing.T) {
+	for _, src := range []string{
+		`G{\"o}del, Escher, Bach`,
+		`{\'E}cole Polytechnique \& {DNA}`,
+		`Stra{\ss}e 1--10 $\alpha$`,
+		`{\v{S}}koda and Erd{\H{o}}s`,
+	} {
+		text := ToUnicode(src)
+		if ToUnicode(ToLaTeX(text)) != text {
+			t.Errorf("%q: expected %q to survive a round trip, found %q", src, text, ToUnicode(ToLaTeX(text)))
+		}
+	}
+}
+
+// TestToText checks braces and escapes are dropped for plain text
+func TestToText(t *testing.T) {
+	testData := map[string]string{
+		`{\"O}zt{\"u}rk and {DNA}`: "Öztürk and DNA",
+		`Turtles \& \{snails\}`:    "Turtles & {snails}",
+		`Donald~E. Knuth`:          "Donald E. Knuth",
+		`$x^2$`:                    "$x^2$",
+	}
+	for src, expected := range testData {
+		if result := ToText(src); result != expected {
+			t.Errorf("%q: expected %q, found %q", src, expected, result)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+//
+// lint.go checks elements for schema and style problems
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Severity ranks a lint Problem
+type Severity int
+
+const (
+	// SeverityInfo is a matter of style
+	SeverityInfo Severity = iota
+	// SeverityWarning is likely to give poor output
+	SeverityWarning
+	// SeverityError is likely to give wrong output or none
+	SeverityError
+)
+
+// String returns the name of the severity
+func (severity Severity) String() string {
+	switch severity {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("severity-%d", int(severity))
+}
+
+// MarshalText writes the severity by name, e.g. in JSON
+func (severity Severity) MarshalText() ([]byte, error) {
+	return []byte(severity.String()), nil
+}
+
+// ParseSeverity returns the Severity named by s, e.g. "warning"
+func ParseSeverity(s string) (Severity, error) {
+	for _, severity := range []Severity{SeverityInfo, SeverityWarning, SeverityError} {
+		if strings.EqualFold(s, severity.String()) == true {
+			return severity, nil
+		}
+	}
+	return SeverityInfo, fmt.Errorf("unknown severity %q", s)
+}
+
+// Rule describes a lint check
+type Rule struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Severity    Severity `json:"severity"`
+	Description string   `json:"description"`
+}
+
+// The lint rules, see LintRules
+var (
+	RuleSyntaxError         = &Rule{"BL001", "syntax-error", SeverityError, "the entry could not be parsed"}
+	RuleUnbalancedBraces    = &Rule{"BL002", "unbalanced-braces", SeverityError, "curly brackets are not balanced"}
+	RuleMissingRequired     = &Rule{"BL003", "missing-required", SeverityError, "a tag required by the entry type is missing"}
+	RuleUnknownField        = &Rule{"BL004", "unknown-field", SeverityInfo, "the tag is not used by the entry type"}
+	RuleEmptyCiteKey        = &Rule{"BL005", "empty-citekey", SeverityError, "the entry has no citation key"}
+	RuleUnknownType         = &Rule{"BL006", "unknown-type", SeverityWarning, "the entry type is not in the schema"}
+	RuleMalformedTag        = &Rule{"BL007", "malformed-tag", SeverityWarning, "a bare word where name = value is expected"}
+	RuleDuplicateCiteKey    = &Rule{"BL008", "duplicate-citekey", SeverityError, "the citation key is used by an earlier entry"}
+	RuleDuplicateField      = &Rule{"BL009", "duplicate-field", SeverityError, "the tag is repeated in the entry"}
+	RuleNonNumericYear      = &Rule{"BL010", "non-numeric-year", SeverityWarning, "the year is not a number"}
+	RuleMalformedPages      = &Rule{"BL011", "malformed-pages", SeverityWarning, "a page range should be separated by --"}
+	RuleUnprotectedCapitals = &Rule{"BL012", "unprotected-capitals", SeverityWarning, "capitals in a title may be lower cased unless wrapped in curly brackets"}
+	RuleDOIURL              = &Rule{"BL013", "doi-url", SeverityWarning, "a DOI should be given as a bare doi tag, not a URL"}
+	RuleNonASCII            = &Rule{"BL014", "non-ascii", SeverityWarning, "non-ASCII characters should be wrapped in curly brackets or written as LaTeX"}
+	RuleMonthMacro          = &Rule{"BL015", "month-macro", SeverityInfo, "a month name should be written with the macros jan to dec"}
+	RuleWhitespace          = &Rule{"BL016", "whitespace", SeverityInfo, "a value has white space inside its delimiters"}
+	RuleMisspelledField     = &Rule{"BL017", "misspelled-field", SeverityWarning, "the tag looks like a misspelling of a tag the entry type uses"}
+
+	// LintRules lists every rule Lint may report
+	LintRules = []*Rule{
+		RuleSyntaxError,
+		RuleUnbalancedBraces,
+		RuleMissingRequired,
+		RuleUnknownField,
+		RuleEmptyCiteKey,
+		RuleUnknownType,
+		RuleMalformedTag,
+		RuleDuplicateCiteKey,
+		RuleDuplicateField,
+		RuleNonNumericYear,
+		RuleMalformedPages,
+		RuleUnprotectedCapitals,
+		RuleDOIURL,
+		RuleNonASCII,
+		RuleMonthMacro,
+		RuleWhitespace,
+		RuleMisspelledField,
+	}
+)
+
+// Problem is something found by Lint, the SyntaxError locates it
+type Problem struct {
+	Rule     string   `json:"rule"`
+	Name     string   `json:"name"`
+	Severity Severity `json:"severity"`
+	// Field is the tag concerned, if any
+	Field string `json:"field,omitempty"`
+	*SyntaxError
+}
+
+// mkProblem creates a Problem reported by rule
+func mkProblem(rule *Rule, field string, err *SyntaxError) *Problem {
+	return &Problem{
+		Rule:        rule.ID,
+		Name:        rule.Name,
+		Severity:    rule.Severity,
+		Field:       field,
+		SyntaxError: err,
+	}
+}
+
+// SyntaxProblems reports the errors collected by a Decoder as Problems
+func SyntaxProblems(errList ErrorList) []*Problem {
+	var problems []*Problem
+	for _, err := range errList {
+		if strings.Contains(err.Msg, "curly bracket") == true {
+			problems = append(problems, mkProblem(RuleUnbalancedBraces, "", err))
+		} else {
+			problems = append(problems, mkProblem(RuleSyntaxError, "", err))
+		}
+	}
+	return problems
+}
+
+var (
+	// pageRange is a page, or a pair of pages separated by --
+	pageRange = regexp.MustCompile(`^[^\s\-–—]+(\s*--\s*[^\s\-–—]+)?$`)
+	// doiURL matches a DOI written as a URL
+	doiURL = regexp.MustCompile(`(?i)^\s*(https?://)?(dx\.)?doi\.org/`)
+)
+
+// Lint checks elements against a schema, DefaultSchema if nil, and for
+// common style problems. Duplicate citation keys are looked for across
+// all the elements.
+func Lint(elements []*Element, schema *Schema) []*Problem {
+	var problems []*Problem
+
+	seen := make(map[string]*Element)
+	for _, element := range elements {
+		if strings.EqualFold(element.Type, "string") == true {
+			continue
+		}
+		problems = append(problems, lintElement(element, schema)...)
+		if element.CiteKey == "" {
+			continue
+		}
+		key := strings.ToLower(element.CiteKey)
+		if first, ok := seen[key]; ok == true {
+			msg := fmt.Sprintf("duplicate citation key %q, first used at %s", element.CiteKey, location(first))
+			problems = append(problems, mkProblem(RuleDuplicateCiteKey, "", elementError(element, msg)))
+		} else {
+			seen[key] = element
+		}
+	}
+	return problems
+}
+
+// location returns where an element was found as "filename:line:column"
+func location(element *Element) string {
+	if element.filename == "" {
+		return fmt.Sprintf("%d:%d", element.pos.line, element.pos.column)
+	}
+	return fmt.Sprintf("%s:%d:%d", element.filename, element.pos.line, element.pos.column)
+}
+
+// lintElement checks a single element
+func lintElement(element *Element, schema *Schema) []*Problem {
+	var problems []*Problem
+
+	add := func(rule *Rule, field string, msg string) {
+		problems = append(problems, mkProblem(rule, field, elementError(element, msg)))
+	}
+
+	for _, finding := range element.Validate(schema) {
+		var rule *Rule
+		switch finding.Kind {
+		case MissingRequired:
+			rule = RuleMissingRequired
+		case UnknownField:
+			rule = RuleUnknownField
+		case EmptyCiteKey:
+			rule = RuleEmptyCiteKey
+		default:
+			rule = RuleUnknownType
+		}
+		problems = append(problems, mkProblem(rule, finding.Field, finding.SyntaxError))
+	}
+	for _, word := range element.Malformed() {
+		add(RuleMalformedTag, "", fmt.Sprintf("malformed tag %q, expected name = value", word))
+	}
+	for _, name := range element.Duplicates() {
+		add(RuleDuplicateField, name, fmt.Sprintf("duplicate tag %q", name))
+	}
+
+	for _, field := range element.Fields() {
+		name := strings.ToLower(field.Name)
+		literals := literalParts(field.Value)
+		if balanced(literals) == false {
+			add(RuleUnbalancedBraces, field.Name, fmt.Sprintf("unbalanced curly brackets in %s", field.Name))
+			continue
+		}
+		if newName, ok := misspelling(schema, element.Type, field.Name); ok == true {
+			if _, found := element.Tags[newName]; found == true {
+				add(RuleMisspelledField, field.Name, fmt.Sprintf("%s looks like a misspelling of %s, which is already set", field.Name, newName))
+			} else {
+				add(RuleMisspelledField, field.Name, fmt.Sprintf("%s looks like a misspelling of %s", field.Name, newName))
+			}
+		}
+		if _, ok := fixSpace(field.Value); ok == true {
+			add(RuleWhitespace, field.Name, fmt.Sprintf("white space inside the delimiters of %s", field.Name))
+		}
+		switch name {
+		case "month":
+			if _, ok := fixMonth(field.Value); ok == true {
+				add(RuleMonthMacro, field.Name, fmt.Sprintf("month %s should be a macro", field.Value))
+			}
+		case "year":
+			if isNumber(strings.TrimSpace(strings.Join(literals, ""))) == false && isNumber(field.Value) == false {
+				add(RuleNonNumericYear, field.Name, fmt.Sprintf("year %s is not a number", field.Value))
+			}
+		case "pages":
+			for _, pages := range strings.Split(strings.Join(literals, ""), ",") {
+				if pages = strings.TrimSpace(pages); pages != "" && pageRange.MatchString(pages) == false {
+					add(RuleMalformedPages, field.Name, fmt.Sprintf("malformed page range %q, use -- between pages", pages))
+				}
+			}
+		case "title":
+			if words := unprotectedCapitals(strings.Join(literals, "")); len(words) > 0 {
+				add(RuleUnprotectedCapitals, field.Name, fmt.Sprintf("unprotected capitals in %s: %s", field.Name, strings.Join(words, ", ")))
+			}
+		case "doi":
+			if doiURL.MatchString(strings.Join(literals, "")) == true {
+				add(RuleDOIURL, field.Name, "doi is written as a URL, give the bare DOI")
+			}
+		case "url":
+			if doiURL.MatchString(strings.Join(literals, "")) == true {
+				add(RuleDOIURL, field.Name, "url is a DOI, use the doi tag")
+			}
+		}
+		for _, literal := range literals {
+			if c, ok := topLevelNonASCII(literal); ok == true {
+				add(RuleNonASCII, field.Name, fmt.Sprintf("non-ASCII character %q outside curly brackets in %s", c, field.Name))
+				break
+			}
+		}
+	}
+	return problems
+}
+
+// literalParts returns the text inside the quoted or braced parts of a
+// raw tag value, macro references and numbers are left out
+func literalParts(val string) []string {
+	var literals []string
+	for _, part := range splitValue(val) {
+		if isQuoted(part) || (len(part) >= 2 && part[0] == '{' && part[len(part)-1] == '}') {
+			literals = append(literals, part[1:len(part)-1])
+		}
+	}
+	return literals
+}
+
+// balanced checks the curly brackets of each literal pair up
+func balanced(literals []string) bool {
+	for _, literal := range literals {
+		depth := 0
+		for _, c := range literal {
+			switch c {
+			case '{':
+				depth++
+			case '}':
+				depth--
+			}
+			if depth < 0 {
+				return false
+			}
+		}
+		if depth != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// topLevelNonASCII returns the first non-ASCII character outside of
+// curly brackets
+func topLevelNonASCII(s string) (rune, bool) {
+	depth := 0
+	for _, c := range s {
+		switch {
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+		case c > unicode.MaxASCII && depth == 0:
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// unprotectedCapitals returns the words of a title with capitals outside
+// of curly brackets, the first letter of the title and of each subtitle
+// are left as is by BibTeX and so are not reported.
+func unprotectedCapitals(title string) []string {
+	var (
+		words   []string
+		word    []rune
+		flagged bool
+		depth   int
+		command bool
+	)
+	start := true
+	flush := func() {
+		if flagged == true {
+			words = append(words, string(word))
+		}
+		word = nil
+		flagged = false
+	}
+	for _, c := range title {
+		switch {
+		case c == '{':
+			depth++
+			command = false
+		case c == '}':
+			depth--
+		case c == '\\':
+			command = true
+		case unicode.IsSpace(c):
+			flush()
+			command = false
+			continue
+		case unicode.IsLetter(c) == false:
+			command = false
+			if c == ':' || c == '?' || c == '!' || c == '.' {
+				start = true
+			}
+		case command == true:
+			// LaTeX command names are not text
+		case depth == 0 && unicode.IsUpper(c) && start == false:
+			flagged = true
+		}
+		if unicode.IsLetter(c) == true && command == false {
+			start = false
+		}
+		word = append(word, c)
+	}
+	flush()
+	return words
+}
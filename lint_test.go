@@ -0,0 +1,111 @@
+//
+// lint_test.go tests the lint rules
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+// TestLint checks each rule is reported where expected
+func TestLint(t *testing.T) {
+	fname := path.Join("testdata", "lint.bib")
+	src, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	elements, err := ParseWithOptions(src, Options{Filename: fname})
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	var found []string
+	for _, problem := range Lint(elements, nil) {
+		found = append(found, problem.Key+":"+problem.Name+":"+problem.Field)
+	}
+	expected := []string{
+		"turtles2016:unprotected-capitals:title",
+		"turtles2016:non-numeric-year:year",
+		"turtles2016:malformed-pages:pages",
+		"turtles2016:doi-url:doi",
+		"turtles2016:malformed-tag:",
+		"turtles2016:duplicate-field:year",
+		"turtles2016:non-ascii:note",
+		"turtles2016:duplicate-citekey:",
+		"dragons:doi-url:url",
+	}
+	if strings.Join(found, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected\n%s\nfound\n%s", strings.Join(expected, "\n"), strings.Join(found, "\n"))
+	}
+}
+
+// TestLintSyntax checks decoder errors are reported as problems
+func TestLintSyntax(t *testing.T) {
+	src := []byte("@misc{m1,\n  title = \"Unbalanced {brace\"\n}\n\n@misc{m2, title = {ok}}\n")
+	elements, err := ParseWithOptions(src, Options{Recover: true})
+	errList, ok := err.(ErrorList)
+	if ok == false {
+		t.Errorf("expected an ErrorList, found %v", err)
+		t.FailNow()
+	}
+	problems := SyntaxProblems(errList)
+	if len(problems) != 1 || problems[0].Rule != RuleUnbalancedBraces.ID || problems[0].Severity != SeverityError {
+		t.Errorf("expected unbalanced braces, found %v", problems)
+	}
+	// m2 has a key and is a valid misc entry
+	if problems := Lint(elements, nil); len(problems) != 0 {
+		t.Errorf("expected no problems in m2, found %v", problems)
+	}
+
+	element := &Element{Type: "misc", CiteKey: "m3", Tags: map[string]string{"title": "\"a } b {\""}}
+	if problems := Lint([]*Element{element}, nil); len(problems) != 1 || problems[0].Rule != RuleUnbalancedBraces.ID {
+		t.Errorf("expected unbalanced braces in m3, found %v", problems)
+	}
+}
+
+// TestUnprotectedCapitals checks which capitals BibTeX may lower case
+func TestUnprotectedCapitals(t *testing.T) {
+	for title, expected := range map[string]string{
+		"Turtles in the time continuum":     "",
+		"Turtles in the Applied Sciences":   "Applied,Sciences",
+		"{DNA} and {RNA}: A {LaTeX} primer": "",
+		"DNA and turtles":                   "DNA",
+		"Using \\LaTeX{} with {BibTeX}":     "",
+		"{T}urtles and {D}ragons":           "",
+	} {
+		if result := strings.Join(unprotectedCapitals(title), ","); result != expected {
+			t.Errorf("%q expected %q, found %q", title, expected, result)
+		}
+	}
+}
+
+// TestParseSeverity checks severities are named
+func TestParseSeverity(t *testing.T) {
+	for _, severity := range []Severity{SeverityInfo, SeverityWarning, SeverityError} {
+		if s, err := ParseSeverity(strings.ToUpper(severity.String())); err != nil || s != severity {
+			t.Errorf("expected %s, found %s, %v", severity, s, err)
+		}
+	}
+	if _, err := ParseSeverity("fatal"); err == nil {
+		t.Errorf("expected an error for an unknown severity")
+	}
+}
@@ -0,0 +1,158 @@
+//
+// macros.go expands @string macros and concatenations in tag values
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Macros maps @string names (in lower case) to their expanded values
+type Macros map[string]string
+
+// monthMacros are predefined by BibTeX's standard styles
+var monthMacros = map[string]string{
+	"jan": "January",
+	"feb": "February",
+	"mar": "March",
+	"apr": "April",
+	"may": "May",
+	"jun": "June",
+	"jul": "July",
+	"aug": "August",
+	"sep": "September",
+	"oct": "October",
+	"nov": "November",
+	"dec": "December",
+}
+
+// NewMacros returns a macro table holding the standard month macros
+func NewMacros() Macros {
+	macros := make(Macros)
+	for name, val := range monthMacros {
+		macros[name] = val
+	}
+	return macros
+}
+
+// isNumber checks if s is made up of digits only
+func isNumber(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Expand resolves a raw tag value, expanding macro references,
+// concatenating the parts joined by # and stripping the outer
+// quotes or curly brackets. The names of any undefined macros are
+// returned, they expand to an empty string.
+func (macros Macros) Expand(val string) (string, []string) {
+	var (
+		out       []string
+		undefined []string
+	)
+	for _, part := range splitValue(val) {
+		switch {
+		case part == "":
+		case isQuoted(part) || isBraced(part):
+			out = append(out, part[1:len(part)-1])
+		case isNumber(part):
+			out = append(out, part)
+		default:
+			if expanded, ok := macros[strings.ToLower(part)]; ok == true {
+				out = append(out, expanded)
+			} else {
+				undefined = append(undefined, part)
+			}
+		}
+	}
+	return strings.Join(out, ""), undefined
+}
+
+// Resolve builds a macro table from the @string elements, in order,
+// and sets the Resolved values of each element's tags. The elements
+// are updated in place. Undefined macros are returned as an ErrorList.
+func Resolve(elements []*Element) (Macros, error) {
+	var errList ErrorList
+
+	macros := NewMacros()
+	for _, element := range elements {
+		errList = append(errList, macros.ResolveElement(element)...)
+	}
+	if len(errList) > 0 {
+		return macros, errList
+	}
+	return macros, nil
+}
+
+// ResolveElement sets the Resolved values of an element's tags using
+// the macros defined so far. If element is a @string its definitions
+// are added to the table, this allows resolving while streaming.
+func (macros Macros) ResolveElement(element *Element) ErrorList {
+	var errList ErrorList
+
+	isString := strings.EqualFold(element.Type, "string")
+	if isString == false && len(element.Tags) == 0 {
+		return nil
+	}
+	element.Resolved = make(map[string]string)
+	// Walk the tags in source order so @string entries can build on each other
+	for _, name := range tagNames(element, OrderSource, nil) {
+		expanded, undefined := macros.Expand(element.Tags[name])
+		for _, macro := range undefined {
+			errList = append(errList, elementError(element, fmt.Sprintf("undefined macro %q in %s", macro, name)))
+		}
+		element.Resolved[name] = expanded
+		if isString == true {
+			macros[strings.ToLower(name)] = expanded
+		}
+	}
+	return errList
+}
+
+// expanded returns the value of a tag with macros expanded, its Resolved
+// value if it has one, and if the tag was found
+func (element *Element) expanded(name string) (string, bool) {
+	key, ok := element.tagKey(name)
+	if ok == false {
+		return "", false
+	}
+	if val, ok := element.Resolved[key]; ok == true {
+		return val, true
+	}
+	val, _ := NewMacros().Expand(element.Tags[key])
+	return val, true
+}
+
+// formatResolved delimits an expanded value for output
+func formatResolved(val string, delimiter Delimiter) string {
+	switch {
+	case isNumber(val):
+		return val
+	case delimiter == DelimiterQuotes && hasTopLevelQuote(val) == false:
+		return "\"" + val + "\""
+	}
+	return "{" + val + "}"
+}
@@ -0,0 +1,108 @@
+//
+// macros_test.go tests @string macro expansion
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+// TestExpand tests expanding individual tag values
+func TestExpand(t *testing.T) {
+	macros := NewMacros()
+	macros["rsdoiel"] = "R. S. Doiel"
+	for val, expected := range map[string]string{
+		`"Quoted"`:                      "Quoted",
+		`{Braced {Inner}}`:              "Braced {Inner}",
+		`2016`:                          "2016",
+		`jan`:                           "January",
+		`DEC`:                           "December",
+		`RSDoiel # ", " # {Mark Doiel}`: "R. S. Doiel, Mark Doiel",
+		`"a # b" # {c # d}`:             "a # bc # d",
+	} {
+		result, undefined := macros.Expand(val)
+		if result != expected {
+			t.Errorf("%s: expected %q, found %q", val, expected, result)
+		}
+		if len(undefined) > 0 {
+			t.Errorf("%s: unexpected undefined macros %s", val, undefined)
+		}
+	}
+	result, undefined := macros.Expand(`jan # " " # nosuchmacro`)
+	if result != "January " || len(undefined) != 1 || undefined[0] != "nosuchmacro" {
+		t.Errorf("expected nosuchmacro to be undefined, found %q %s", result, undefined)
+	}
+}
+
+// TestResolve tests resolving the macros in a parsed file
+func TestResolve(t *testing.T) {
+	fname := path.Join("testdata", "sample1.bib")
+	src, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	elements, err := ParseWithOptions(src, Options{Filename: fname})
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	macros, err := Resolve(elements)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	if macros["markdoiel"] != "Mark Doiel" {
+		t.Errorf("expected markdoiel macro, found %q", macros["markdoiel"])
+	}
+	misc, article := elements[1], elements[2]
+	if misc.Tags["author"] != `"Howard" # "Phiby"` || misc.Resolved["author"] != "HowardPhiby" {
+		t.Errorf("expected raw and resolved author, found %q and %q", misc.Tags["author"], misc.Resolved["author"])
+	}
+	if article.Resolved["author"] != "Mark DoielandR. S. Doiel" {
+		t.Errorf("expected resolved author, found %q", article.Resolved["author"])
+	}
+	if article.Resolved["title"] != "Turtles in the time continum" || article.Resolved["year"] != "2016" {
+		t.Errorf("expected outer quotes to be stripped, found %q", article.Resolved)
+	}
+
+	// Undefined macros are reported with the element's position
+	src = []byte(`@string{ann = "Annals"}
+
+@article{a1, journal = ann # " of " # hist, month = jan}
+`)
+	elements, err = ParseWithOptions(src, Options{Filename: "macros.bib"})
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	_, err = Resolve(elements)
+	errList, ok := err.(ErrorList)
+	if ok == false || len(errList) != 1 {
+		t.Errorf("expected one undefined macro, found %v", err)
+		t.FailNow()
+	}
+	if errList[0].Key != "a1" || errList[0].Line != 3 || errList[0].Filename != "macros.bib" {
+		t.Errorf("expected error for a1 at line 3, found %s", errList[0])
+	}
+	if elements[1].Resolved["journal"] != "Annals of " || elements[1].Resolved["month"] != "January" {
+		t.Errorf("expected partial resolution, found %q", elements[1].Resolved)
+	}
+}
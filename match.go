@@ -0,0 +1,265 @@
+//
+// match.go defines strategies for deciding when two elements are the same entry
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	// My packages
+	"github.com/rsdoiel/bibtex/latex"
+)
+
+// Equivalence decides when two elements describe the same entry, e.g.
+// when they share a citation key or a DOI
+type Equivalence interface {
+	// Keys returns the keys an element can be found by, equivalent
+	// elements share at least one. An element without keys is only
+	// equivalent to itself.
+	Keys(element *Element) []string
+	// Equivalent reports whether two elements describe the same entry
+	Equivalent(elem1 *Element, elem2 *Element) bool
+}
+
+type exactMatch struct{}
+type citeKeyMatch struct{}
+type identifierMatch struct{}
+type titleMatch struct{}
+
+var (
+	// ExactMatch treats elements as the same when they are Equal
+	ExactMatch Equivalence = exactMatch{}
+	// CiteKeyMatch treats elements with the same citation key, ignoring
+	// case, as the same
+	CiteKeyMatch Equivalence = citeKeyMatch{}
+	// IdentifierMatch treats elements sharing a DOI or an ISBN as the
+	// same, ISBN-10s are compared as ISBN-13s
+	IdentifierMatch Equivalence = identifierMatch{}
+	// TitleMatch treats elements with the same title, year and last name
+	// of the first author (or editor) as the same. Case, punctuation,
+	// LaTeX markup and spacing are ignored.
+	TitleMatch Equivalence = titleMatch{}
+
+	// Equivalences maps names, e.g. for a command line option, to strategies
+	Equivalences = map[string]Equivalence{
+		"exact": ExactMatch,
+		"key":   CiteKeyMatch,
+		"id":    IdentifierMatch,
+		"title": TitleMatch,
+	}
+)
+
+// EquivalenceByName returns the named strategy from Equivalences
+func EquivalenceByName(name string) (Equivalence, error) {
+	if eq, ok := Equivalences[strings.ToLower(name)]; ok == true {
+		return eq, nil
+	}
+	var names []string
+	for name := range Equivalences {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown match %q, expected %s", name, strings.Join(names, ", "))
+}
+
+// shareKey checks if two elements have a key in common
+func shareKey(eq Equivalence, elem1 *Element, elem2 *Element) bool {
+	keys := make(map[string]bool)
+	for _, key := range eq.Keys(elem1) {
+		keys[key] = true
+	}
+	for _, key := range eq.Keys(elem2) {
+		if keys[key] == true {
+			return true
+		}
+	}
+	return false
+}
+
+func (exactMatch) Keys(element *Element) []string {
+	return []string{fmt.Sprintf("%x", ContentHash(element))}
+}
+
+func (exactMatch) Equivalent(elem1 *Element, elem2 *Element) bool {
+	return Equal(elem1, elem2)
+}
+
+func (citeKeyMatch) Keys(element *Element) []string {
+	if element.CiteKey == "" {
+		return nil
+	}
+	return []string{strings.ToLower(element.CiteKey)}
+}
+
+func (eq citeKeyMatch) Equivalent(elem1 *Element, elem2 *Element) bool {
+	return shareKey(eq, elem1, elem2)
+}
+
+// text returns a tag's value as plain text, resolved if it has been
+func (element *Element) text(name string) string {
+	val, ok := element.expanded(name)
+	if ok == false {
+		return ""
+	}
+	return latex.ToText(val)
+}
+
+// normalizeWords lower cases text keeping only its letters and digits,
+// words are separated by a single space
+func normalizeWords(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return unicode.IsLetter(r) == false && unicode.IsDigit(r) == false
+	}), " ")
+}
+
+// normalizeDOI lower cases a DOI dropping any URL or doi: prefix
+func normalizeDOI(s string) string {
+	s = strings.TrimSpace(doiURL.ReplaceAllString(s, ""))
+	if strings.HasPrefix(strings.ToLower(s), "doi:") == true {
+		s = strings.TrimSpace(s[4:])
+	}
+	return strings.ToLower(s)
+}
+
+// normalizeISBN returns an ISBN as 13 digits or "" if it isn't one
+func normalizeISBN(s string) string {
+	var digits []byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= '0' && c <= '9':
+			digits = append(digits, c)
+		case (c == 'X' || c == 'x') && len(digits) == 9:
+			digits = append(digits, 'X')
+		}
+	}
+	switch len(digits) {
+	case 13:
+		return string(digits)
+	case 10:
+		isbn := "978" + string(digits[:9])
+		sum := 0
+		for i := 0; i < len(isbn); i++ {
+			weight := 1
+			if i%2 == 1 {
+				weight = 3
+			}
+			sum += int(isbn[i]-'0') * weight
+		}
+		return fmt.Sprintf("%s%d", isbn, (10-sum%10)%10)
+	}
+	return ""
+}
+
+func (identifierMatch) Keys(element *Element) []string {
+	var keys []string
+	if doi := normalizeDOI(element.text("doi")); doi != "" {
+		keys = append(keys, "doi:"+doi)
+	}
+	for _, s := range strings.FieldsFunc(element.text("isbn"), func(r rune) bool {
+		return r == ',' || r == ';'
+	}) {
+		if isbn := normalizeISBN(s); isbn != "" {
+			keys = append(keys, "isbn:"+isbn)
+		}
+	}
+	return keys
+}
+
+func (eq identifierMatch) Equivalent(elem1 *Element, elem2 *Element) bool {
+	return shareKey(eq, elem1, elem2)
+}
+
+// year returns an element's year, from date when there is no year tag
+func (element *Element) year() string {
+	if year := strings.TrimSpace(element.text("year")); year != "" {
+		return year
+	}
+	if m := isoDate.FindStringSubmatch(strings.TrimSpace(element.text("date"))); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+func (titleMatch) Keys(element *Element) []string {
+	title := normalizeWords(element.text("title"))
+	if title == "" {
+		return nil
+	}
+	last := ""
+	names := element.Names("author")
+	if len(names) == 0 {
+		names = element.Names("editor")
+	}
+	if len(names) > 0 {
+		last = normalizeWords(latex.ToText(names[0].Last))
+	}
+	return []string{title + "\x00" + element.year() + "\x00" + last}
+}
+
+func (eq titleMatch) Equivalent(elem1 *Element, elem2 *Element) bool {
+	return shareKey(eq, elem1, elem2)
+}
+
+// JoinBy combines two lists without duplicates as Join does, elements
+// of elemList2 equivalent to one already in the result are dropped
+func JoinBy(eq Equivalence, elemList1, elemList2 []*Element) []*Element {
+	result := make([]*Element, 0, len(elemList1)+len(elemList2))
+	result = append(result, elemList1...)
+	index := NewIndexBy(eq, result)
+	for _, elem := range elemList2 {
+		if index.Contains(elem) == false {
+			result = append(result, elem)
+			index.Add(elem)
+		}
+	}
+	return result
+}
+
+// DiffBy returns the elements of elemList1 with no equivalent in elemList2
+func DiffBy(eq Equivalence, elemList1, elemList2 []*Element) []*Element {
+	var result []*Element
+	index := NewIndexBy(eq, elemList2)
+	for _, elem := range elemList1 {
+		if index.Contains(elem) == false {
+			result = append(result, elem)
+		}
+	}
+	return result
+}
+
+// IntersectBy returns the elements of elemList1 with an equivalent in
+// elemList2
+func IntersectBy(eq Equivalence, elemList1, elemList2 []*Element) []*Element {
+	var result []*Element
+	index := NewIndexBy(eq, elemList2)
+	for _, elem := range elemList1 {
+		if index.Contains(elem) == true {
+			result = append(result, elem)
+		}
+	}
+	return result
+}
+
+// ExclusiveBy returns the elements of either list with no equivalent in
+// the other, those from elemList1 first
+func ExclusiveBy(eq Equivalence, elemList1, elemList2 []*Element) []*Element {
+	return JoinBy(eq, DiffBy(eq, elemList1, elemList2), DiffBy(eq, elemList2, elemList1))
+}
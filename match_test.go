@@ -0,0 +1,131 @@
+//
+// match_test.go tests the equivalence strategies
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"strings"
+	"testing"
+)
+
+var matchSrc1 = `@article{doiel2016,
+    author = {R. S. Doiel and Mark Doiel},
+    title = {Turtles All the Way Down},
+    journal = {Turtle Journal},
+    year = 2016,
+    doi = {10.1000/182}
+}
+
+@book{knuth84,
+    author = {Donald E. Knuth},
+    title = {The {\TeX}book},
+    publisher = {Addison-Wesley},
+    year = 1984,
+    isbn = {0-201-13447-0}
+}
+
+@misc{godel31,
+    author = {Kurt G{\"o}del},
+    title = {{\"U}ber formal unentscheidbare S{\"a}tze},
+    year = 1931
+}`
+
+var matchSrc2 = `@article{Doiel2016,
+    author = {Doiel, R. S.},
+    title = {Turtles all the way down.},
+    journal = {Turtle Journal},
+    year = 2016,
+    doi = {https://doi.org/10.1000/182},
+    note = {Added by Mark}
+}
+
+@book{texbook,
+    author = {Knuth, Donald E.},
+    title = {The TeXbook},
+    year = 1984,
+    isbn = {978-0-201-13447-6}
+}
+
+@misc{goedel,
+    author = {Kurt Gödel},
+    title = {Über formal unentscheidbare Sätze},
+    date = {1931-01}
+}`
+
+// TestEquivalences checks which entries each strategy treats as the same
+func TestEquivalences(t *testing.T) {
+	list1, err := Parse([]byte(matchSrc1))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	list2, err := Parse([]byte(matchSrc2))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	expected := map[string]string{
+		"exact": "",
+		"key":   "doiel2016",
+		"id":    "doiel2016,knuth84",
+		"title": "doiel2016,knuth84,godel31",
+	}
+	for name, keys := range expected {
+		eq, err := EquivalenceByName(name)
+		if err != nil {
+			t.Errorf("%s", err)
+			continue
+		}
+		var found []string
+		for _, elem := range IntersectBy(eq, list1, list2) {
+			found = append(found, elem.CiteKey)
+		}
+		if result := strings.Join(found, ","); result != keys {
+			t.Errorf("%s: expected %q, found %q", name, keys, result)
+		}
+		if len(JoinBy(eq, list1, list2)) != 6-len(found) {
+			t.Errorf("%s: expected the join to drop %d entries", name, len(found))
+		}
+		for i, elem := range list1 {
+			if eq.Equivalent(elem, list2[i]) != strings.Contains(","+keys+",", ","+elem.CiteKey+",") {
+				t.Errorf("%s: Equivalent disagrees for %s", name, elem.CiteKey)
+			}
+		}
+	}
+	if _, err := EquivalenceByName("fuzzy"); err == nil {
+		t.Errorf("expected an error for an unknown strategy")
+	}
+	if len(CiteKeyMatch.Keys(&Element{Type: "misc"})) != 0 {
+		t.Errorf("expected no keys for an element without a citation key")
+	}
+}
+
+// TestNormalizeISBN checks ISBN-10s are converted to ISBN-13s
+func TestNormalizeISBN(t *testing.T) {
+	testData := map[string]string{
+		"0-201-13447-0":     "9780201134476",
+		"978-0-201-13447-6": "9780201134476",
+		"0-8044-2957-X":     "9780804429573",
+		"ISBN 123":          "",
+	}
+	for isbn, expected := range testData {
+		if result := normalizeISBN(isbn); result != expected {
+			t.Errorf("%s: expected %q, found %q", isbn, expected, result)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+//
+// merge.go merges matching elements tag by tag reporting conflicts
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// MergePolicy decides which value is kept when merged elements disagree
+type MergePolicy int
+
+const (
+	// PreferLeft keeps the value from the first list
+	PreferLeft MergePolicy = iota
+	// PreferRight keeps the value from the second list
+	PreferRight
+	// PreferLonger keeps the longer value, the left one if they are the
+	// same length
+	PreferLonger
+	// FailOnConflict keeps the left value and makes Merge return an error
+	FailOnConflict
+)
+
+// MergePolicies maps names, e.g. for a command line option, to policies
+var MergePolicies = map[string]MergePolicy{
+	"left":   PreferLeft,
+	"right":  PreferRight,
+	"longer": PreferLonger,
+	"fail":   FailOnConflict,
+}
+
+// Conflict is a tag, or the entry type, two merged elements disagree on
+type Conflict struct {
+	// Key is the citation key of the merged element
+	Key string `json:"key"`
+	// Field is the tag name, empty when the entry types disagree. In a
+	// three way merge an empty Left or Right type means that side deleted
+	// the entry.
+	Field string `json:"field"`
+	Left  string `json:"left"`
+	Right string `json:"right"`
+	// Base is the value both sides started from in a three way merge
+	Base string `json:"base,omitempty"`
+	// Kept is the value the policy chose, empty when it is left for a
+	// human to choose
+	Kept string `json:"kept,omitempty"`
+	// Element is the merged element, nil in a three way merge
+	Element *Element `json:"-"`
+}
+
+// String describes the conflict on one line
+func (conflict *Conflict) String() string {
+	var s string
+
+	switch {
+	case conflict.Field == "" && conflict.Left == "":
+		s = fmt.Sprintf("%s: entry deleted on the left, changed on the right", conflict.Key)
+	case conflict.Field == "" && conflict.Right == "":
+		s = fmt.Sprintf("%s: entry changed on the left, deleted on the right", conflict.Key)
+	case conflict.Field == "":
+		s = fmt.Sprintf("%s: entry type: %q <> %q", conflict.Key, conflict.Left, conflict.Right)
+	default:
+		s = fmt.Sprintf("%s: %s: %q <> %q", conflict.Key, conflict.Field, conflict.Left, conflict.Right)
+	}
+	if conflict.Base != "" {
+		s += fmt.Sprintf(", was %q", conflict.Base)
+	}
+	if conflict.Kept != "" {
+		s += fmt.Sprintf(", kept %q", conflict.Kept)
+	}
+	return s
+}
+
+// choose returns the value a policy keeps
+func (policy MergePolicy) choose(left string, right string) string {
+	switch policy {
+	case PreferRight:
+		return right
+	case PreferLonger:
+		if utf8.RuneCountInString(right) > utf8.RuneCountInString(left) {
+			return right
+		}
+	}
+	return left
+}
+
+// sameTagValue compares two tag values ignoring one level of quotes or
+// braces around them, so {2016}, "2016" and 2016 are the same
+func sameTagValue(val1 string, val2 string) bool {
+	return val1 == val2 || unquote(val1) == unquote(val2)
+}
+
+// unquote drops the quotes or braces around a tag value
+func unquote(val string) string {
+	if isQuoted(val) == true || isBraced(val) == true {
+		return val[1 : len(val)-1]
+	}
+	return val
+}
+
+// MergeElements returns a copy of left with the tags only right has
+// added after its own. Where both have a tag and the values disagree,
+// ignoring their delimiters, the policy picks one and a Conflict is
+// returned. The citation key is taken from left.
+func MergeElements(left *Element, right *Element, policy MergePolicy) (*Element, []*Conflict) {
+	var conflicts []*Conflict
+
+	merged := Clone(left)
+	if strings.EqualFold(left.Type, right.Type) == false {
+		kept := policy.choose(left.typeName(), right.typeName())
+		conflicts = append(conflicts, &Conflict{Key: left.CiteKey, Left: left.typeName(), Right: right.typeName(), Kept: kept, Element: merged})
+		merged.setType(kept)
+	}
+	for _, field := range right.Fields() {
+		rightVal, _ := right.Get(field.Name)
+		leftVal, ok := merged.Get(field.Name)
+		switch {
+		case ok == false:
+			merged.Set(field.Name, rightVal)
+			if resolved, ok := right.Resolved[strings.ToLower(field.Name)]; ok == true && merged.Resolved != nil {
+				merged.Resolved[strings.ToLower(field.Name)] = resolved
+			}
+		case sameTagValue(leftVal, rightVal) == false:
+			// A repeated tag is compared once
+			if _, ok := left.Get(field.Name); ok == false {
+				continue
+			}
+			kept := policy.choose(leftVal, rightVal)
+			conflicts = append(conflicts, &Conflict{
+				Key:     left.CiteKey,
+				Field:   merged.tagSpelling(field.Name),
+				Left:    leftVal,
+				Right:   rightVal,
+				Kept:    kept,
+				Element: merged,
+			})
+			if kept != leftVal {
+				merged.Set(field.Name, kept)
+				if resolved, ok := right.Resolved[strings.ToLower(field.Name)]; ok == true && merged.Resolved != nil {
+					merged.Resolved[strings.ToLower(field.Name)] = resolved
+				}
+			}
+		}
+	}
+	return merged, conflicts
+}
+
+// Merge joins two lists as JoinBy does, except an element of the right
+// list matched by eq to one on the left is merged into it tag by tag
+// with MergeElements. Each left element is merged with at most one right
+// element. With FailOnConflict an error is returned when there are any
+// conflicts, the merged list keeps the left values.
+func Merge(eq Equivalence, elemList1 []*Element, elemList2 []*Element, policy MergePolicy) ([]*Element, []*Conflict, error) {
+	var (
+		result    []*Element
+		conflicts []*Conflict
+	)
+
+	index := NewIndexBy(eq, elemList2)
+	used := make(map[*Element]bool)
+	for _, left := range elemList1 {
+		merged := left
+		for _, right := range index.FindAll(left) {
+			if used[right] == false {
+				used[right] = true
+				var found []*Conflict
+				merged, found = MergeElements(left, right, policy)
+				conflicts = append(conflicts, found...)
+				break
+			}
+		}
+		result = append(result, merged)
+	}
+	var rest []*Element
+	for _, right := range elemList2 {
+		if used[right] == false {
+			rest = append(rest, right)
+		}
+	}
+	result = JoinBy(eq, result, rest)
+	if policy == FailOnConflict && len(conflicts) > 0 {
+		return result, conflicts, fmt.Errorf("%d conflicting tags", len(conflicts))
+	}
+	return result, conflicts, nil
+}
+
+// EncodeConflicts writes a merged element as Encode does, using the
+// encoder's Template if set, but where it has conflicts the lines that
+// differ are written twice, with the left and right values, between
+// conflict markers like git's, e.g.
+//
+//	<<<<<<< ours.bib
+//	    year = 2016,
+//	=======
+//	    year = 2017,
+//	>>>>>>> theirs.bib
+//
+// Only the conflicts returned with element by Merge or MergeElements are
+// marked. The result has to be edited by hand before it can be parsed
+// again.
+func (enc *Encoder) EncodeConflicts(element *Element, conflicts []*Conflict, leftLabel string, rightLabel string) error {
+	var found []*Conflict
+
+	for _, conflict := range conflicts {
+		if conflict.Element == element {
+			found = append(found, conflict)
+		}
+	}
+	if len(found) == 0 {
+		return enc.Encode(element)
+	}
+	left, err := enc.encode(conflictSide(element, found, false))
+	if err != nil {
+		return err
+	}
+	right, err := enc.encode(conflictSide(element, found, true))
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(enc.w, markConflicts(left, right, leftLabel, rightLabel))
+	return err
+}
+
+// conflictSide returns a copy of element with the left, or right, values
+// of its conflicts
+func conflictSide(element *Element, conflicts []*Conflict, right bool) *Element {
+	side := Clone(element)
+	for _, conflict := range conflicts {
+		val := conflict.Left
+		if right == true {
+			val = conflict.Right
+		}
+		if conflict.Field == "" {
+			side.setType(val)
+			continue
+		}
+		side.Set(conflict.Field, val)
+		delete(side.Resolved, strings.ToLower(conflict.Field))
+	}
+	return side
+}
+
+// markConflicts puts the lines that differ between two renderings of an
+// element between conflict markers. Where as many lines were changed on
+// each side they are marked one by one, so each tag gets its own markers.
+func markConflicts(left string, right string, leftLabel string, rightLabel string) string {
+	var (
+		out     []string
+		removed []string
+		added   []string
+	)
+
+	// mark writes the left and right versions of some lines
+	mark := func(leftPart []string, rightPart []string) {
+		out = append(out, "<<<<<<< "+leftLabel+"\n")
+		out = append(out, leftPart...)
+		out = append(out, "=======\n")
+		out = append(out, rightPart...)
+		out = append(out, ">>>>>>> "+rightLabel+"\n")
+	}
+	flush := func() {
+		if len(removed) == len(added) {
+			for i := range removed {
+				mark(removed[i:i+1], added[i:i+1])
+			}
+		} else {
+			mark(removed, added)
+		}
+		removed, added = nil, nil
+	}
+	for _, edit := range diffLines(splitLines([]byte(left)), splitLines([]byte(right))) {
+		switch edit.op {
+		case '-':
+			removed = append(removed, edit.text)
+		case '+':
+			added = append(added, edit.text)
+		default:
+			if len(removed) > 0 || len(added) > 0 {
+				flush()
+			}
+			out = append(out, edit.text)
+		}
+	}
+	if len(removed) > 0 || len(added) > 0 {
+		flush()
+	}
+	return strings.Join(out, "")
+}
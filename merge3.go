@@ -0,0 +1,453 @@
+//
+// merge3.go implements a three way merge of BibTeX sources
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// identify returns the identities of the nodes in a tree and the node
+// with each identity. Entries are known by citation key, @string entries
+// by the macro they define and anything else by its text. The nth repeat
+// of an identity has #n appended. Blank text has no identity.
+func identify(tree *SyntaxTree) ([]string, map[string]*SyntaxNode) {
+	var ids []string
+
+	nodes := make(map[string]*SyntaxNode)
+	seen := make(map[string]int)
+	for _, node := range tree.Nodes {
+		id := nodeIdentity(node)
+		if id == "" {
+			continue
+		}
+		if n := seen[id]; n > 0 {
+			seen[id]++
+			id = fmt.Sprintf("%s#%d", id, n)
+		} else {
+			seen[id] = 1
+		}
+		ids = append(ids, id)
+		nodes[id] = node
+	}
+	return ids, nodes
+}
+
+// nodeIdentity returns what a node is known by in a three way merge
+func nodeIdentity(node *SyntaxNode) string {
+	switch {
+	case node.Kind == NodeEntry && node.Key != "":
+		return "key:" + strings.ToLower(node.Key)
+	case node.Kind == NodeEntry && strings.EqualFold(node.Type, "string") && len(node.Fields) > 0:
+		return "string:" + strings.ToLower(node.Fields[0].Name.Text)
+	}
+	text := strings.TrimSpace(tokensText(node.Tokens))
+	if text == "" {
+		return ""
+	}
+	return "text:" + text
+}
+
+// sameNode checks if a node was left unchanged, entries are compared as
+// Equal does
+func sameNode(node1 *SyntaxNode, node2 *SyntaxNode) bool {
+	if node1.Kind == NodeEntry && node2.Kind == NodeEntry {
+		return Equal(node1.Node().(*Element), node2.Node().(*Element))
+	}
+	return bytes.Equal(bytes.TrimSpace(node1.Bytes()), bytes.TrimSpace(node2.Bytes()))
+}
+
+// sameValue compares two optional tag values ignoring their delimiters
+func sameValue(val1 *string, val2 *string) bool {
+	if val1 == nil || val2 == nil {
+		return val1 == nil && val2 == nil
+	}
+	return sameTagValue(*val1, *val2)
+}
+
+// optional returns a pointer to a tag's value or nil if it isn't set
+func optional(element *Element, name string) *string {
+	if element == nil {
+		return nil
+	}
+	if val, ok := element.Get(name); ok == true {
+		return &val
+	}
+	return nil
+}
+
+// orEmpty dereferences an optional value
+func orEmpty(val *string) string {
+	if val == nil {
+		return ""
+	}
+	return *val
+}
+
+// merger3 holds the state of a three way merge
+type merger3 struct {
+	result      *SyntaxTree
+	oursLabel   string
+	theirsLabel string
+	conflicts   []*Conflict
+	// marked holds the nodes markNode put between conflict markers
+	marked []*SyntaxNode
+}
+
+// indexOf finds a node in the result
+func (m *merger3) indexOf(node *SyntaxNode) int {
+	for i, n := range m.result.Nodes {
+		if n == node {
+			return i
+		}
+	}
+	return -1
+}
+
+// remove takes a node out of the result with the blank text after it,
+// or failing that before it
+func (m *merger3) remove(node *SyntaxNode) {
+	start := m.indexOf(node)
+	end := start + 1
+	nodes := m.result.Nodes
+	switch {
+	case end < len(nodes) && nodeIdentity(nodes[end]) == "":
+		end++
+	case start > 0 && nodeIdentity(nodes[start-1]) == "":
+		start--
+	}
+	m.result.Nodes = append(nodes[:start:start], nodes[end:]...)
+}
+
+// insertAfter adds a node after anchor, or at the start when anchor is
+// nil, separated from it by a blank line
+func (m *merger3) insertAfter(anchor *SyntaxNode, node *SyntaxNode) {
+	blank := &SyntaxNode{Kind: NodeText, Tokens: []*SyntaxToken{{Kind: TokenText, Text: "\n\n", Offset: -1}}}
+	var added []*SyntaxNode
+	at := 0
+	if anchor != nil {
+		at = m.indexOf(anchor) + 1
+		added = []*SyntaxNode{blank, node}
+	} else {
+		added = []*SyntaxNode{node, blank}
+	}
+	var nodes []*SyntaxNode
+	nodes = append(nodes, m.result.Nodes[:at]...)
+	nodes = append(nodes, added...)
+	nodes = append(nodes, m.result.Nodes[at:]...)
+	m.result.Nodes = nodes
+}
+
+// markers joins the left and right lines of a conflict between markers
+func (m *merger3) markers(left string, right string, indent string) string {
+	var out strings.Builder
+	out.WriteString("<<<<<<< " + m.oursLabel + "\n")
+	if left != "" {
+		out.WriteString(indent + left + "\n")
+	}
+	out.WriteString("=======\n")
+	if right != "" {
+		out.WriteString(indent + right + "\n")
+	}
+	out.WriteString(">>>>>>> " + m.theirsLabel)
+	return out.String()
+}
+
+// startsLine checks if text starts with a line break
+func startsLine(text string) bool {
+	return strings.HasPrefix(text, "\n") || strings.HasPrefix(text, "\r\n")
+}
+
+// markTokens replaces the tokens from start to end (exclusive) of a node
+// with the left and right text between conflict markers on lines of
+// their own. The node can't be edited further.
+func (m *merger3) markTokens(node *SyntaxNode, start int, end int, left string, right string) {
+	prefix, indent := "", ""
+	if start > 0 {
+		prefix = "\n"
+		if node.Tokens[start-1].Kind == TokenSpace {
+			space := node.Tokens[start-1].Text
+			if i := strings.LastIndex(space, "\n"); i >= 0 {
+				prefix, indent = space[:i+1], space[i+1:]
+			}
+			start--
+		}
+	}
+	text := prefix + m.markers(left, right, indent)
+	// End the closing marker's line, dropping blanks before a line break
+	if end < len(node.Tokens) && node.Tokens[end].Kind == TokenSpace && strings.ContainsAny(node.Tokens[end].Text, "\r\n") {
+		space := node.Tokens[end].Text
+		text += space[strings.IndexAny(space, "\r\n"):]
+		end++
+	} else {
+		text += "\n"
+	}
+	node.splice(start, end, &SyntaxToken{Kind: TokenText, Text: text, Offset: -1})
+}
+
+// markField puts the ours and theirs versions of a tag between conflict
+// markers, the tag has to be in the node
+func (m *merger3) markField(node *SyntaxNode, name string, ours *string, theirs *string) {
+	field := node.Field(name)
+	start := node.tokenIndex(field.Name)
+	valueStart, end := node.valueSpan(field)
+	comma := ""
+	for i := end; i < len(node.Tokens); i++ {
+		if node.Tokens[i].Kind == TokenComma {
+			comma = tokensText(node.Tokens[end : i+1])
+			end = i + 1
+			break
+		}
+		if node.Tokens[i].Kind != TokenSpace {
+			break
+		}
+	}
+	head := tokensText(node.Tokens[start:valueStart])
+	if len(field.Value) == 0 {
+		// Keep "name = " on one line for a tag without a value
+		head = strings.TrimRight(head, " \t\r\n") + " "
+	}
+	left, right := "", ""
+	if ours != nil {
+		left = strings.TrimRight(head+*ours, " \t") + comma
+	}
+	if theirs != nil {
+		right = head + *theirs + comma
+	}
+	m.markTokens(node, start, end, left, right)
+}
+
+// markType puts the ours and theirs versions of an entry's opening line
+// between conflict markers
+func (m *merger3) markType(node *SyntaxNode, theirsType string) {
+	typeAt, end := -1, len(node.Tokens)
+	for i, token := range node.Tokens {
+		if token.Kind == TokenType {
+			typeAt = i
+		}
+		if token.Kind == TokenComma {
+			end = i + 1
+			break
+		}
+	}
+	left := tokensText(node.Tokens[:end])
+	right := tokensText(node.Tokens[:typeAt]) + theirsType + tokensText(node.Tokens[typeAt+1:end])
+	m.markTokens(node, 0, end, left, right)
+}
+
+// markNode puts a whole node between conflict markers, on the left when
+// ours is true otherwise on the right
+func (m *merger3) markNode(node *SyntaxNode, ours bool) {
+	text := strings.TrimRight(tokensText(node.Tokens), "\n")
+	left, right := text, ""
+	if ours == false {
+		left, right = "", text
+	}
+	node.Tokens = []*SyntaxToken{{Kind: TokenText, Text: m.markers(left, right, ""), Offset: -1}}
+	m.marked = append(m.marked, node)
+}
+
+// endMarkedLines puts the closing marker of each node markNode marked on
+// a line of its own, unless what follows it starts a new line already
+func (m *merger3) endMarkedLines() {
+	for _, node := range m.marked {
+		next := ""
+		if i := m.indexOf(node); i >= 0 && i+1 < len(m.result.Nodes) {
+			next = tokensText(m.result.Nodes[i+1].Tokens)
+		}
+		if startsLine(next) == false {
+			node.Tokens[0].Text += "\n"
+		}
+	}
+}
+
+// rawValues returns the source of the values of a node's tags by name
+func rawValues(node *SyntaxNode) map[string]string {
+	values := make(map[string]string)
+	for _, field := range node.Fields {
+		values[strings.ToLower(field.Name.Text)] = field.Raw()
+	}
+	return values
+}
+
+// mergeEntry merges the changes made to an entry in theirs into the same
+// entry in the result, base is nil when both sides added the entry
+func (m *merger3) mergeEntry(base *SyntaxNode, node *SyntaxNode, theirs *SyntaxNode) {
+	var (
+		baseElem   *Element
+		names      []string
+		conflicted []string
+	)
+
+	if node.Kind != NodeEntry || theirs.Kind != NodeEntry {
+		return
+	}
+	if base != nil && base.Kind == NodeEntry {
+		baseElem = base.Node().(*Element)
+	}
+	oursElem := node.Node().(*Element)
+	theirsElem := theirs.Node().(*Element)
+	key := node.Key
+	if key == "" && len(node.Fields) > 0 {
+		key = node.Fields[0].Name.Text
+	}
+
+	// The entry type
+	baseType := ""
+	if baseElem != nil {
+		baseType = baseElem.typeName()
+	}
+	oursType, theirsType := oursElem.typeName(), theirsElem.typeName()
+	typeConflict := false
+	switch {
+	case oursType == theirsType || theirsType == baseType:
+	case oursType == baseType:
+		node.SetType(theirs.Type)
+	default:
+		typeConflict = true
+		m.conflicts = append(m.conflicts, &Conflict{Key: key, Left: oursType, Right: theirsType, Base: baseType})
+	}
+
+	// The tags, in the order of ours followed by any new ones
+	seen := make(map[string]bool)
+	for _, elem := range []*Element{oursElem, theirsElem, baseElem} {
+		if elem == nil {
+			continue
+		}
+		for _, field := range elem.Fields() {
+			name := strings.ToLower(field.Name)
+			if seen[name] == false {
+				seen[name] = true
+				names = append(names, field.Name)
+			}
+		}
+	}
+	theirsRaw := rawValues(theirs)
+	for _, name := range names {
+		baseVal := optional(baseElem, name)
+		oursVal := optional(oursElem, name)
+		theirsVal := optional(theirsElem, name)
+		switch {
+		case sameValue(oursVal, theirsVal) || sameValue(baseVal, theirsVal):
+		case sameValue(baseVal, oursVal) && theirsVal == nil:
+			node.DeleteField(name)
+		case sameValue(baseVal, oursVal):
+			node.SetField(theirsElem.tagSpelling(name), theirsRaw[strings.ToLower(name)])
+		default:
+			conflicted = append(conflicted, name)
+			m.conflicts = append(m.conflicts, &Conflict{
+				Key:   key,
+				Field: name,
+				Left:  orEmpty(oursVal),
+				Right: orEmpty(theirsVal),
+				Base:  orEmpty(baseVal),
+			})
+			if oursVal == nil {
+				// Add the tag so there is a place to mark
+				node.SetField(theirsElem.tagSpelling(name), theirsRaw[strings.ToLower(name)])
+			}
+		}
+	}
+
+	// Marking conflicts comes last as a marked node can't be edited
+	for _, name := range conflicted {
+		var oursRaw, theirsVal *string
+		if val, ok := rawValues(node)[strings.ToLower(name)]; ok == true && optional(oursElem, name) != nil {
+			oursRaw = &val
+		}
+		if val, ok := theirsRaw[strings.ToLower(name)]; ok == true {
+			theirsVal = &val
+		}
+		m.markField(node, name, oursRaw, theirsVal)
+	}
+	if typeConflict == true {
+		m.markType(node, theirs.Type)
+	}
+}
+
+// Merge3 merges the changes made in theirs since base into ours,
+// working entry by entry and tag by tag. Entries are matched by citation
+// key, so moving them around doesn't matter, and the result keeps the
+// layout of ours with entries added in theirs placed after the entry
+// they follow in theirs. Additions, deletions and changes to different
+// tags on either side merge cleanly. When both sides change the same tag,
+// or one changes an entry the other deletes, a Conflict is returned and
+// both versions are written to the result between conflict markers like
+// git's, labelled oursLabel and theirsLabel. None of the trees passed in
+// are changed.
+func Merge3(base *SyntaxTree, ours *SyntaxTree, theirs *SyntaxTree, oursLabel string, theirsLabel string) (*SyntaxTree, []*Conflict, error) {
+	result, err := ParseSyntax(ours.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+	m := &merger3{result: result, oursLabel: oursLabel, theirsLabel: theirsLabel}
+	_, baseNodes := identify(base)
+	resultIDs, resultNodes := identify(result)
+	theirsIDs, theirsNodes := identify(theirs)
+
+	// Entries in ours
+	for _, id := range resultIDs {
+		node := resultNodes[id]
+		baseNode, inBase := baseNodes[id]
+		theirsNode, inTheirs := theirsNodes[id]
+		switch {
+		case inTheirs == true:
+			m.mergeEntry(baseNode, node, theirsNode)
+		case inBase == false:
+			// Added in ours
+		case sameNode(baseNode, node):
+			// Deleted in theirs
+			m.remove(node)
+			delete(resultNodes, id)
+		default:
+			m.conflicts = append(m.conflicts, &Conflict{Key: node.Key, Left: node.Type})
+			m.markNode(node, true)
+		}
+	}
+
+	// Entries only in theirs
+	var anchor *SyntaxNode
+	for _, id := range theirsIDs {
+		theirsNode := theirsNodes[id]
+		if node, ok := resultNodes[id]; ok == true {
+			anchor = node
+			continue
+		}
+		baseNode, inBase := baseNodes[id]
+		switch {
+		case inBase == false:
+			// Added in theirs
+		case sameNode(baseNode, theirsNode):
+			// Deleted in ours
+			continue
+		default:
+			m.conflicts = append(m.conflicts, &Conflict{Key: theirsNode.Key, Right: theirsNode.Type})
+		}
+		node := &SyntaxNode{Kind: theirsNode.Kind, Type: theirsNode.Type, Key: theirsNode.Key}
+		node.Tokens = append(node.Tokens, theirsNode.Tokens...)
+		if inBase == true {
+			m.markNode(node, false)
+		}
+		m.insertAfter(anchor, node)
+		anchor = node
+	}
+	m.endMarkedLines()
+	return m.result, m.conflicts, nil
+}
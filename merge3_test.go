@@ -0,0 +1,352 @@
+//
+// merge3_test.go tests the three way merge
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"strings"
+	"testing"
+)
+
+const merge3Base = `% Group bibliography
+
+@article{a1,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    year = 2016,
+}
+
+@book{b1,
+    title = {Dragons},
+    year = 2010,
+}
+
+@misc{c1,
+    title = {Snails},
+}
+`
+
+// mustParseSyntax parses source for a test or fails it
+func mustParseSyntax(t *testing.T, src string) *SyntaxTree {
+	tree, err := ParseSyntax([]byte(src))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	return tree
+}
+
+// TestMerge3 checks changes on either side merge cleanly
+func TestMerge3(t *testing.T) {
+	// Ours moves b1 first, changes a1's year and adds d1
+	ours := `% Group bibliography
+
+@book{b1,
+    title = {Dragons},
+    year = 2010,
+}
+
+@article{a1,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    year = 2017,
+}
+
+@misc{c1,
+    title = {Snails},
+}
+
+@misc{d1,
+    title = {Snakes},
+}
+`
+	// Theirs changes a1's title, adds a note and an entry after it,
+	// deletes b1's year and deletes c1
+	theirs := `% Group bibliography
+
+@article{a1,
+    author = {R. S. Doiel},
+    title = {Turtles all the way down},
+    year = 2016,
+    note = {Added by Mark},
+}
+
+@misc{e1,
+    title = {Eels},
+}
+
+@book{b1,
+    title = {Dragons},
+}
+`
+	expected := `% Group bibliography
+
+@book{b1,
+    title = {Dragons},
+}
+
+@article{a1,
+    author = {R. S. Doiel},
+    title = {Turtles all the way down},
+    year = 2017,
+    note = {Added by Mark},
+}
+
+@misc{e1,
+    title = {Eels},
+}
+
+@misc{d1,
+    title = {Snakes},
+}
+`
+	base := mustParseSyntax(t, merge3Base)
+	oursTree := mustParseSyntax(t, ours)
+	theirsTree := mustParseSyntax(t, theirs)
+	result, conflicts, err := Merge3(base, oursTree, theirsTree, "ours.bib", "theirs.bib")
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("expected no conflicts, found %s", conflicts)
+	}
+	if result.String() != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, result.String())
+	}
+	if oursTree.String() != ours || theirsTree.String() != theirs || base.String() != merge3Base {
+		t.Errorf("expected the trees merged to be left unchanged")
+	}
+
+	// Merging with no changes in theirs gives ours back
+	result, conflicts, _ = Merge3(base, oursTree, base, "ours.bib", "theirs.bib")
+	if len(conflicts) != 0 || result.String() != ours {
+		t.Errorf("expected ours back, found %s\n%s", conflicts, result.String())
+	}
+}
+
+// TestMerge3Conflicts checks only true conflicts are marked
+func TestMerge3Conflicts(t *testing.T) {
+	// Both change a1's year, ours changes b1's type and deletes c1
+	ours := `% Group bibliography
+
+@article{a1,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    year = 2017,
+}
+
+@misc{b1,
+    title = {Dragons},
+    year = 2010,
+}
+`
+	// Theirs changes a1's year, b1's type and c1
+	theirs := `% Group bibliography
+
+@article{a1,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    year = 2018,
+}
+
+@inbook{b1,
+    title = {Dragons},
+    year = 2010,
+}
+
+@misc{c1,
+    title = {Snails},
+    note = {Slow},
+}
+`
+	expected := `% Group bibliography
+
+@article{a1,
+    author = {R. S. Doiel},
+    title = {Turtles},
+<<<<<<< ours.bib
+    year = 2017,
+=======
+    year = 2018,
+>>>>>>> theirs.bib
+}
+
+<<<<<<< ours.bib
+@misc{b1,
+=======
+@inbook{b1,
+>>>>>>> theirs.bib
+    title = {Dragons},
+    year = 2010,
+}
+
+<<<<<<< ours.bib
+=======
+@misc{c1,
+    title = {Snails},
+    note = {Slow},
+}
+>>>>>>> theirs.bib
+`
+	result, conflicts, err := Merge3(mustParseSyntax(t, merge3Base), mustParseSyntax(t, ours), mustParseSyntax(t, theirs), "ours.bib", "theirs.bib")
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	if result.String() != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, result.String())
+	}
+	reports := []string{
+		`a1: year: "2017" <> "2018", was "2016"`,
+		`b1: entry type: "misc" <> "inbook", was "book"`,
+		`c1: entry deleted on the left, changed on the right`,
+	}
+	if len(conflicts) != len(reports) {
+		t.Errorf("expected %d conflicts, found %s", len(reports), conflicts)
+		t.FailNow()
+	}
+	for i, report := range reports {
+		if conflicts[i].String() != report {
+			t.Errorf("expected %s, found %s", report, conflicts[i])
+		}
+	}
+
+	// An entry changed in ours and deleted in theirs
+	theirs = `% Group bibliography
+
+@article{a1,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    year = 2016,
+}
+
+@book{b1,
+    title = {Dragons},
+    year = 2010,
+}
+`
+	ours = `% Group bibliography
+
+@article{a1,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    year = 2016,
+}
+
+@book{b1,
+    title = {Dragons},
+    year = 2010,
+}
+
+@misc{c1,
+    title = {Snails},
+    note = {Slow},
+}
+
+@misc{d1,
+    title = {Snakes},
+}
+`
+	result, conflicts, _ = Merge3(mustParseSyntax(t, merge3Base), mustParseSyntax(t, ours), mustParseSyntax(t, theirs), "ours.bib", "theirs.bib")
+	if len(conflicts) != 1 || conflicts[0].String() != `c1: entry changed on the left, deleted on the right` {
+		t.Errorf("expected c1 to conflict, found %s", conflicts)
+	}
+	expected = `@misc{c1,
+    title = {Snails},
+    note = {Slow},
+}
+=======
+>>>>>>> theirs.bib
+
+@misc{d1,`
+	if strings.Contains(result.String(), "<<<<<<< ours.bib\n"+expected) == false {
+		t.Errorf("expected c1 between markers, found\n%s", result.String())
+	}
+}
+
+// TestMerge3OneLine checks conflicts in one line entries and tags
+// without a value are marked on lines of their own
+func TestMerge3OneLine(t *testing.T) {
+	for _, test := range []struct {
+		base, ours, theirs, expected string
+	}{
+		{
+			base:   "@misc{k, title = {T}}\n",
+			ours:   "@misc{k, title = {T}, note = }\n",
+			theirs: "@misc{k, title = {T}, note = {x}}\n",
+			expected: `@misc{k, title = {T},
+<<<<<<< ours.bib
+note =
+=======
+note = {x}
+>>>>>>> theirs.bib
+}
+`,
+		},
+		{
+			base:   "",
+			ours:   "@misc{c, title={C1}}\n",
+			theirs: "@misc{c, title={C2}}\n",
+			expected: `@misc{c,
+<<<<<<< ours.bib
+title={C1}
+=======
+title={C2}
+>>>>>>> theirs.bib
+}
+`,
+		},
+		{
+			base:   "@misc{k,\n  title = {T},\n  note = {a}\n}\n",
+			ours:   "@misc{k,\n  title = {T},\n  note =\n}\n",
+			theirs: "@misc{k,\n  title = {T},\n  note = {b}\n}\n",
+			expected: `@misc{k,
+  title = {T},
+<<<<<<< ours.bib
+  note =
+=======
+  note = {b}
+>>>>>>> theirs.bib
+}
+`,
+		},
+		{
+			base:   "@misc{k, title = {T}}\n",
+			ours:   "@misc{k, title = {U}}",
+			theirs: "",
+			expected: `<<<<<<< ours.bib
+@misc{k, title = {U}}
+=======
+>>>>>>> theirs.bib
+`,
+		},
+	} {
+		result, conflicts, err := Merge3(mustParseSyntax(t, test.base), mustParseSyntax(t, test.ours), mustParseSyntax(t, test.theirs), "ours.bib", "theirs.bib")
+		if err != nil {
+			t.Errorf("%s", err)
+			continue
+		}
+		if len(conflicts) != 1 {
+			t.Errorf("expected a conflict, found %s", conflicts)
+		}
+		if result.String() != test.expected {
+			t.Errorf("expected\n%s\nfound\n%s", test.expected, result)
+		}
+	}
+}
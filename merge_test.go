@@ -0,0 +1,235 @@
+//
+// merge_test.go tests merging elements tag by tag
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var (
+	mergeLeft = `@article{doiel2016,
+    author = {R. S. Doiel},
+    title = {Turtles},
+    year = 2016
+}
+
+@book{b1,
+    title = {Dragons}
+}`
+
+	mergeRight = `@article{Doiel2016,
+    author = "R. S. Doiel",
+    title = {Turtles all the way down},
+    year = 2017,
+    note = {Added by Mark}
+}
+
+@misc{c1,
+    title = {Snails}
+}`
+)
+
+// TestMergeElements checks each policy
+func TestMergeElements(t *testing.T) {
+	left, err := Parse([]byte(mergeLeft))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	right, err := Parse([]byte(mergeRight))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	expected := map[MergePolicy][]string{
+		PreferLeft:     {"{Turtles}", "2016"},
+		PreferRight:    {"{Turtles all the way down}", "2017"},
+		PreferLonger:   {"{Turtles all the way down}", "2016"},
+		FailOnConflict: {"{Turtles}", "2016"},
+	}
+	before := left[0].String()
+	for policy, values := range expected {
+		merged, conflicts := MergeElements(left[0], right[0], policy)
+		if len(conflicts) != 2 {
+			t.Errorf("%d: expected conflicts in title and year, found %s", policy, conflicts)
+			continue
+		}
+		if conflicts[0].Field != "title" || conflicts[1].Field != "year" || conflicts[1].Left != "2016" || conflicts[1].Right != "2017" {
+			t.Errorf("%d: expected conflicts in title and year, found %s", policy, conflicts)
+		}
+		title, _ := merged.Get("title")
+		year, _ := merged.Get("year")
+		if title != values[0] || year != values[1] {
+			t.Errorf("%d: expected %s, found %s and %s", policy, values, title, year)
+		}
+		if conflicts[0].Kept != title {
+			t.Errorf("%d: expected the conflict to record %q kept, found %q", policy, title, conflicts[0].Kept)
+		}
+		if note, _ := merged.Get("note"); note != "{Added by Mark}" {
+			t.Errorf("%d: expected the note to be added, found %q", policy, note)
+		}
+		if merged.CiteKey != "doiel2016" {
+			t.Errorf("%d: expected the left citation key, found %q", policy, merged.CiteKey)
+		}
+	}
+	if left[0].String() != before {
+		t.Errorf("expected the left element to be left as is")
+	}
+
+	// Entry types may disagree too
+	merged, conflicts := MergeElements(left[1], right[1], PreferRight)
+	if len(conflicts) != 2 || conflicts[0].Field != "" || merged.Type != "misc" {
+		t.Errorf("expected a conflict in the entry type, found %s", conflicts)
+	}
+	if s := conflicts[0].String(); s != `b1: entry type: "book" <> "misc", kept "misc"` {
+		t.Errorf("unexpected description %q", s)
+	}
+}
+
+// TestMerge checks lists are merged by citation key
+func TestMerge(t *testing.T) {
+	left, _ := Parse([]byte(mergeLeft))
+	right, _ := Parse([]byte(mergeRight))
+	result, conflicts, err := Merge(CiteKeyMatch, left, right, PreferLeft)
+	if err != nil {
+		t.Errorf("%s", err)
+	}
+	var keys []string
+	for _, elem := range result {
+		keys = append(keys, elem.CiteKey)
+	}
+	if s := strings.Join(keys, ","); s != "doiel2016,b1,c1" {
+		t.Errorf("expected doiel2016,b1,c1, found %s", s)
+	}
+	if len(conflicts) != 2 {
+		t.Errorf("expected 2 conflicts, found %d", len(conflicts))
+	}
+	if _, _, err := Merge(CiteKeyMatch, left, right, FailOnConflict); err == nil {
+		t.Errorf("expected an error with FailOnConflict")
+	}
+	if _, conflicts, err := Merge(CiteKeyMatch, left, left, FailOnConflict); err != nil || len(conflicts) != 0 {
+		t.Errorf("expected no conflicts merging a list with itself, %s", err)
+	}
+
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	if err := enc.EncodeConflicts(result[0], conflicts, "ours.bib", "theirs.bib"); err != nil {
+		t.Errorf("%s", err)
+	}
+	expected := `@article{doiel2016,
+    author = {R. S. Doiel},
+<<<<<<< ours.bib
+    title = {Turtles},
+=======
+    title = {Turtles all the way down},
+>>>>>>> theirs.bib
+<<<<<<< ours.bib
+    year = 2016,
+=======
+    year = 2017,
+>>>>>>> theirs.bib
+    note = {Added by Mark},
+}
+
+`
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, buf.String())
+	}
+}
+
+// TestEncodeConflicts checks the encoder's options apply and only the
+// element a conflict was found in is marked
+func TestEncodeConflicts(t *testing.T) {
+	left, _ := Parse([]byte(`@article{k1, year = 2016}
+@article{k1, year = 2016}`))
+	right, _ := Parse([]byte(`@article{k1, year = 2017}`))
+	result, conflicts, _ := Merge(CiteKeyMatch, left, right, PreferLeft)
+	if len(result) != 2 || len(conflicts) != 1 || conflicts[0].Element != result[0] {
+		t.Errorf("expected one conflict in the first element, found %s", conflicts)
+		t.FailNow()
+	}
+
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	enc.TrailingComma = false
+	for _, elem := range result {
+		if err := enc.EncodeConflicts(elem, conflicts, "ours.bib", "theirs.bib"); err != nil {
+			t.Errorf("%s", err)
+		}
+	}
+	expected := `@article{k1,
+<<<<<<< ours.bib
+    year = 2016
+=======
+    year = 2017
+>>>>>>> theirs.bib
+}
+
+@article{k1,
+    year = 2016
+}
+
+`
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := enc.SetTemplate(`{{.Key}}:{{range .Fields}} {{.Name}}={{.Value}}{{end}}` + "\n"); err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	if err := enc.EncodeConflicts(result[0], conflicts, "ours.bib", "theirs.bib"); err != nil {
+		t.Errorf("%s", err)
+	}
+	expected = `<<<<<<< ours.bib
+k1: year=2016
+=======
+k1: year=2017
+>>>>>>> theirs.bib
+
+`
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, buf.String())
+	}
+}
+
+// TestSameTagValue checks only the delimiters are ignored
+func TestSameTagValue(t *testing.T) {
+	expected := []struct {
+		val1, val2 string
+		same       bool
+	}{
+		{"2016", "2016", true},
+		{"{2016}", "2016", true},
+		{`"2016"`, "{2016}", true},
+		{"{Turtles}", `"Turtles"`, true},
+		{"2016", "2017", false},
+		{"{2016}", "{2017}", false},
+		{"{a} # {b}", "a} # {b", false},
+		{"{Turtles}", "{turtles}", false},
+	}
+	for _, e := range expected {
+		if same := sameTagValue(e.val1, e.val2); same != e.same {
+			t.Errorf("sameTagValue(%q, %q): expected %t, found %t", e.val1, e.val2, e.same, same)
+		}
+	}
+}
@@ -4,7 +4,7 @@
 #
 PROJECT=bibtex
 
-PROG_LIST="bibfilter bibmerge"
+PROG_LIST="bibfilter bibmerge biblint bibdiff bib2json json2bib bib2bibjson bibjson2bib"
 
 VERSION=$(grep -m1 "Version = " $PROJECT.go | cut -d\" -f 2)
 
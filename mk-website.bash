@@ -33,4 +33,6 @@ echo "Generating license.html"
 mkPage nav.md "Experimental prototype BibTeX tools" "markdown:$(cat LICENSE)" license.html
 echo "Generating install.html"
 mkPage nav.md "Experimental prototype BibTeX tools" INSTALL.md install.html
+echo "Generating json.html"
+mkPage nav.md "Experimental prototype BibTeX tools" JSON.md json.html
 
@@ -0,0 +1,248 @@
+//
+// names.go parses author and editor lists into persons following BibTeX's rules
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Person is a name from an author or editor list split into its parts
+// as BibTeX does, e.g. "Ludwig van Beethoven" has the First part
+// Ludwig, Von part van and Last part Beethoven
+type Person struct {
+	First string `xml:"first,omitempty" json:"first,omitempty"`
+	Von   string `xml:"von,omitempty" json:"von,omitempty"`
+	Last  string `xml:"last,omitempty" json:"last,omitempty"`
+	Jr    string `xml:"jr,omitempty" json:"jr,omitempty"`
+}
+
+// String returns the name in BibTeX's "von Last, Jr, First" form
+func (person *Person) String() string {
+	name := person.Last
+	if person.Von != "" {
+		name = person.Von + " " + name
+	}
+	if person.Jr != "" {
+		name += ", " + person.Jr
+		if person.First == "" {
+			// Keep the Jr part from being read as the First part
+			name += ","
+		}
+	}
+	if person.First != "" {
+		name += ", " + person.First
+	}
+	return name
+}
+
+// splitTopLevel splits s at each separator found outside curly brackets,
+// sep reports the length of a separator starting at i or 0
+func splitTopLevel(s string, sep func(s string, i int) int) []string {
+	var parts []string
+
+	depth := 0
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+			continue
+		case '}':
+			depth--
+			continue
+		}
+		if depth == 0 {
+			if n := sep(s, i); n > 0 {
+				parts = append(parts, s[start:i])
+				start = i + n
+				i += n - 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// isNameSpace checks for the characters separating the words of a name
+func isNameSpace(c byte) bool {
+	return c == '~' || isSpaceByte(c)
+}
+
+// andSeparator matches " and " ignoring case
+func andSeparator(s string, i int) int {
+	if i == 0 || isNameSpace(s[i-1]) == false || i+4 > len(s) {
+		return 0
+	}
+	if strings.EqualFold(s[i:i+3], "and") == false || isNameSpace(s[i+3]) == false {
+		return 0
+	}
+	return 3
+}
+
+// commaSeparator matches a comma
+func commaSeparator(s string, i int) int {
+	if s[i] == ',' {
+		return 1
+	}
+	return 0
+}
+
+// spaceSeparator matches a run of white space or ties
+func spaceSeparator(s string, i int) int {
+	n := 0
+	for i+n < len(s) && isNameSpace(s[i+n]) == true {
+		n++
+	}
+	return n
+}
+
+// words splits a part of a name into its words
+func words(s string) []string {
+	var out []string
+	for _, word := range splitTopLevel(strings.TrimSpace(s), spaceSeparator) {
+		if word != "" {
+			out = append(out, word)
+		}
+	}
+	return out
+}
+
+// isLowerWord reports whether a word starts with a lower case letter at
+// the top level. Letters in curly brackets don't count unless the group
+// is a special character such as {\"o}, e.g. {Barnes and Noble} is
+// treated as upper case.
+func isLowerWord(word string) bool {
+	depth := 0
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		switch {
+		case c == '{':
+			if depth == 0 && i+1 < len(word) && word[i+1] == '\\' {
+				return isLowerSpecial(word[i+1:])
+			}
+			depth++
+		case c == '}':
+			depth--
+		case depth == 0 && c < 0x80 && unicode.IsLetter(rune(c)) == true:
+			return unicode.IsLower(rune(c))
+		case depth == 0 && c >= 0x80:
+			r := []rune(word[i:])[0]
+			if unicode.IsLetter(r) == true {
+				return unicode.IsLower(r)
+			}
+		}
+	}
+	return false
+}
+
+// isLowerSpecial reports the case of a special character, e.g. \"o is
+// lower case and \'E upper case. Commands like \ss without a letter
+// following take the case of the command.
+func isLowerSpecial(s string) bool {
+	i := 1
+	for i < len(s) && unicode.IsLetter(rune(s[i])) == true {
+		i++
+	}
+	command := s[1:i]
+	for ; i < len(s) && s[i] != '}'; i++ {
+		if unicode.IsLetter(rune(s[i])) == true {
+			return unicode.IsLower(rune(s[i]))
+		}
+	}
+	return command != "" && unicode.IsLower(rune(command[0]))
+}
+
+// ParseName splits a single name into its parts. It understands the
+// three forms BibTeX does, "First von Last", "von Last, First" and
+// "von Last, Jr, First". Words in curly brackets are kept together.
+func ParseName(name string) *Person {
+	person := new(Person)
+	parts := splitTopLevel(name, commaSeparator)
+	if len(parts) == 1 {
+		w := words(parts[0])
+		if len(w) == 0 {
+			return person
+		}
+		// von starts at the first lower case word and ends at the last,
+		// the Last part always keeps the final word
+		start, end := -1, -1
+		for i, word := range w[:len(w)-1] {
+			if isLowerWord(word) == true {
+				if start < 0 {
+					start = i
+				}
+				end = i + 1
+			}
+		}
+		if start < 0 {
+			person.First = strings.Join(w[:len(w)-1], " ")
+			person.Last = w[len(w)-1]
+			return person
+		}
+		person.First = strings.Join(w[:start], " ")
+		person.Von = strings.Join(w[start:end], " ")
+		person.Last = strings.Join(w[end:], " ")
+		return person
+	}
+	w := words(parts[0])
+	end := 0
+	for i := 0; i < len(w)-1; i++ {
+		if isLowerWord(w[i]) == true {
+			end = i + 1
+		}
+	}
+	person.Von = strings.Join(w[:end], " ")
+	person.Last = strings.Join(w[end:], " ")
+	if len(parts) == 2 {
+		person.First = strings.Join(words(parts[1]), " ")
+		return person
+	}
+	person.Jr = strings.Join(words(parts[1]), " ")
+	// BibTeX rejects more commas, keep what follows as the First part
+	var first []string
+	for _, part := range parts[2:] {
+		first = append(first, strings.Join(words(part), " "))
+	}
+	person.First = strings.Join(first, ", ")
+	return person
+}
+
+// ParseNames splits a list of names joined by "and", e.g. the text of
+// an author or editor tag, and parses each one. An "and" in curly
+// brackets, as in {Barnes and Noble}, doesn't separate names.
+func ParseNames(names string) []*Person {
+	var persons []*Person
+	for _, name := range splitTopLevel(names, andSeparator) {
+		if strings.TrimSpace(name) != "" {
+			persons = append(persons, ParseName(name))
+		}
+	}
+	return persons
+}
+
+// Names parses the names in an element's tag, e.g. author or editor.
+// The resolved value is used when there is one, otherwise the raw value
+// is expanded with the standard macros.
+func (element *Element) Names(name string) []*Person {
+	val, ok := element.expanded(name)
+	if ok == false {
+		return nil
+	}
+	return ParseNames(val)
+}
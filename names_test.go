@@ -0,0 +1,100 @@
+//
+// names_test.go tests parsing names into persons
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"testing"
+)
+
+// TestParseName checks each form of name BibTeX understands
+func TestParseName(t *testing.T) {
+	testData := []struct {
+		name     string
+		expected Person
+	}{
+		{"R. S. Doiel", Person{First: "R. S.", Last: "Doiel"}},
+		{"Doiel", Person{Last: "Doiel"}},
+		{"Ludwig van Beethoven", Person{First: "Ludwig", Von: "van", Last: "Beethoven"}},
+		{"Jean de La Fontaine", Person{First: "Jean", Von: "de", Last: "La Fontaine"}},
+		{"Charles Louis Xavier Joseph de la Vall{\\'e}e Poussin", Person{First: "Charles Louis Xavier Joseph", Von: "de la", Last: "Vall{\\'e}e Poussin"}},
+		{"van Beethoven, Ludwig", Person{First: "Ludwig", Von: "van", Last: "Beethoven"}},
+		{"de la Fontaine, Jean", Person{First: "Jean", Von: "de la", Last: "Fontaine"}},
+		{"Van Beethoven, L.", Person{First: "L.", Last: "Van Beethoven"}},
+		{"Doiel, Jr, Mark", Person{First: "Mark", Last: "Doiel", Jr: "Jr"}},
+		{"Ford, Jr., Henry", Person{First: "Henry", Last: "Ford", Jr: "Jr."}},
+		{"{Barnes and Noble}", Person{Last: "{Barnes and Noble}"}},
+		{"{\\\"O}zt{\\\"u}rk, Ay{\\c{s}}e", Person{First: "Ay{\\c{s}}e", Last: "{\\\"O}zt{\\\"u}rk"}},
+		{"Jean {de la} Fontaine", Person{First: "Jean {de la}", Last: "Fontaine"}},
+		{"Kurt  Gödel", Person{First: "Kurt", Last: "Gödel"}},
+		{"Donald~E. Knuth", Person{First: "Donald E.", Last: "Knuth"}},
+		{"jean de la fontaine", Person{Von: "jean de la", Last: "fontaine"}},
+	}
+	for _, test := range testData {
+		result := ParseName(test.name)
+		if *result != test.expected {
+			t.Errorf("%q: expected %+v, found %+v", test.name, test.expected, *result)
+		}
+	}
+}
+
+// TestParseNames checks name lists are split on and
+func TestParseNames(t *testing.T) {
+	persons := ParseNames("R. S. Doiel and Doiel, Mark AND {Barnes and Noble} and others")
+	expected := []string{"Doiel, R. S.", "Doiel, Mark", "{Barnes and Noble}", "others"}
+	if len(persons) != len(expected) {
+		t.Errorf("expected %d names, found %d", len(expected), len(persons))
+		t.FailNow()
+	}
+	for i, person := range persons {
+		if person.String() != expected[i] {
+			t.Errorf("%d: expected %q, found %q", i, expected[i], person.String())
+		}
+	}
+	if p := (&Person{Last: "Doiel", Jr: "Jr"}).String(); p != "Doiel, Jr," {
+		t.Errorf("expected a trailing comma to keep the Jr part, found %q", p)
+	}
+	if len(ParseNames("")) != 0 {
+		t.Errorf("expected no names in an empty string")
+	}
+
+	elements, err := Parse([]byte(`@string{ rsdoiel = "R. S. Doiel" }
+
+@article{a1,
+    author = rsdoiel # " and " # {van Beethoven, Ludwig},
+    editor = "Mark Doiel",
+    title = {Turtles}
+}`))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	if _, err := Resolve(elements); err != nil {
+		t.Errorf("%s", err)
+	}
+	authors := elements[1].Names("Author")
+	if len(authors) != 2 || authors[0].Last != "Doiel" || authors[1].Von != "van" {
+		t.Errorf("expected Doiel and van Beethoven, found %v", authors)
+	}
+	if editors := elements[1].Names("editor"); len(editors) != 1 || editors[0].First != "Mark" {
+		t.Errorf("expected Mark Doiel, found %v", editors)
+	}
+	if len(elements[1].Names("translator")) != 0 {
+		t.Errorf("expected no translators")
+	}
+}
@@ -0,0 +1,107 @@
+//
+// nodes.go defines the parts of a BibTeX file other than entries
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"bytes"
+	"io"
+)
+
+// Node is a part of a BibTeX file, an *Element, a *Preamble or a *Comment
+type Node interface {
+	String() string
+}
+
+// Comment holds the text of a @comment entry or of free text found
+// between entries (which BibTeX ignores) verbatim.
+type Comment struct {
+	// Type is the entry type as spelled in the source, e.g. comment or Comment
+	Type string `json:"type,omitempty"`
+	// Text is the text between the curly brackets or the free text
+	Text string `json:"text"`
+	// Implicit is true for free text found between entries
+	Implicit bool `json:"implicit,omitempty"`
+	// Space is any white space between the type and the opening curly bracket
+	Space string `json:"space,omitempty"`
+	// Newline is true when the line break after the closing curly
+	// bracket was read with the comment, String writes it back
+	Newline bool `json:"newline,omitempty"`
+}
+
+// Preamble holds the text of a @preamble entry verbatim
+type Preamble struct {
+	// Type is the entry type as spelled in the source, e.g. preamble or PREAMBLE
+	Type string `json:"type,omitempty"`
+	// Text is the text between the curly brackets
+	Text string `json:"text"`
+	// Space and Newline are as for a Comment
+	Space   string `json:"space,omitempty"`
+	Newline bool   `json:"newline,omitempty"`
+}
+
+// verbatimEntry renders a comment or preamble as it was found in the source
+func verbatimEntry(elementType string, space string, text string, newline bool) string {
+	src := "@" + elementType + space + "{" + text + "}"
+	if newline == true {
+		src += "\n"
+	}
+	return src
+}
+
+// String renders the comment as it was found in the source
+func (comment *Comment) String() string {
+	if comment.Implicit == true {
+		return comment.Text
+	}
+	if comment.Type == "" {
+		return verbatimEntry("comment", comment.Space, comment.Text, comment.Newline)
+	}
+	return verbatimEntry(comment.Type, comment.Space, comment.Text, comment.Newline)
+}
+
+// String renders the preamble as it was found in the source
+func (preamble *Preamble) String() string {
+	if preamble.Type == "" {
+		return verbatimEntry("preamble", preamble.Space, preamble.Text, preamble.Newline)
+	}
+	return verbatimEntry(preamble.Type, preamble.Space, preamble.Text, preamble.Newline)
+}
+
+// ParseNodes parses a BibTeX file keeping its comments, preambles and
+// the text between entries along with the elements.
+func ParseNodes(buf []byte, opts Options) ([]Node, error) {
+	var nodes []Node
+
+	dec := NewDecoder(bytes.NewReader(buf))
+	dec.Options = opts
+	for {
+		node, err := dec.DecodeNode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nodes, err
+		}
+		nodes = append(nodes, node)
+	}
+	if errList := dec.Errors(); len(errList) > 0 {
+		return nodes, errList
+	}
+	return nodes, nil
+}
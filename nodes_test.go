@@ -0,0 +1,177 @@
+//
+// nodes_test.go tests parsing comments, preambles and free text
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+// TestParseNodes checks that comments and preambles are kept verbatim
+func TestParseNodes(t *testing.T) {
+	fname := path.Join("testdata", "sample1.bib")
+	src, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	nodes, err := ParseNodes(src, Options{})
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	// Blank text between the entries is kept too
+	if len(nodes) != 10 {
+		t.Errorf("expected 10 nodes, found %d", len(nodes))
+		t.FailNow()
+	}
+	if blank, ok := nodes[1].(*Comment); ok == false || blank.Implicit == false || blank.Text != "\n" {
+		t.Errorf("expected the blank line after the comment, found %q", nodes[1])
+	}
+	comment, ok := nodes[0].(*Comment)
+	if ok == false {
+		t.Errorf("expected a *Comment, found %T", nodes[0])
+		t.FailNow()
+	}
+	expected := `
+    id0,
+    "This is some sort of comment",
+    "Yet another comment line"
+`
+	if comment.Text != expected || comment.Implicit == true {
+		t.Errorf("expected comment text %q, found %q", expected, comment.Text)
+	}
+}
+
+// TestNodesRoundTrip checks nothing is lost when parsing then printing
+func TestNodesRoundTrip(t *testing.T) {
+	src := []byte(`This file was exported by hand.
+
+@preamble{ "\newcommand{\noopsort}[1]{}" # "\noopsort{a}" }
+@Comment{jabref-meta: databaseType:bibtex;}
+@misc{id1, title={Text}, note = {email me at rsdoiel@example.org}}
+
+Trailing notes about the file, user@example.org
+`)
+	nodes, err := ParseNodes(src, Options{})
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	check := func(nodes []Node) {
+		if len(nodes) != 5 {
+			t.Errorf("expected 5 nodes, found %d: %q", len(nodes), nodes)
+			t.FailNow()
+		}
+		if c, ok := nodes[0].(*Comment); ok == false || c.Implicit == false || strings.TrimSpace(c.Text) != "This file was exported by hand." {
+			t.Errorf("expected leading free text, found %q", nodes[0])
+		}
+		if p, ok := nodes[1].(*Preamble); ok == false || p.Text != ` "\newcommand{\noopsort}[1]{}" # "\noopsort{a}" ` {
+			t.Errorf("expected the preamble verbatim, found %q", nodes[1])
+		}
+		if c, ok := nodes[2].(*Comment); ok == false || c.Type != "Comment" || c.Text != "jabref-meta: databaseType:bibtex;" {
+			t.Errorf("expected the comment verbatim, found %q", nodes[2])
+		}
+		if e, ok := nodes[3].(*Element); ok == false || e.Tags["note"] != "{email me at rsdoiel@example.org}" {
+			t.Errorf("expected the misc element, found %q", nodes[3])
+		}
+		if c, ok := nodes[4].(*Comment); ok == false || strings.TrimSpace(c.Text) != "Trailing notes about the file, user@example.org" {
+			t.Errorf("expected trailing free text, found %q", nodes[4])
+		}
+	}
+	check(nodes)
+
+	out := new(bytes.Buffer)
+	enc := NewEncoder(out)
+	for _, node := range nodes {
+		if err := enc.EncodeNode(node); err != nil {
+			t.Errorf("%s", err)
+		}
+	}
+	nodes, err = ParseNodes(out.Bytes(), Options{})
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	check(nodes)
+}
+
+// TestNodesByteExact checks comments, preambles and the text between
+// entries give back their source byte for byte, the elements are
+// compared with the source the SyntaxTree found for them
+func TestNodesByteExact(t *testing.T) {
+	src := "% Notes\n@comment {commented out}\n@PREAMBLE{ \"\\noopsort\" }\n\n\n@Comment\t{last}"
+	nodes, err := ParseNodes([]byte(src), Options{})
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	var out strings.Builder
+	for _, node := range nodes {
+		out.WriteString(node.String())
+	}
+	if out.String() != src {
+		t.Errorf("expected %q, found %q", src, out.String())
+	}
+
+	files, err := ioutil.ReadDir("testdata")
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	for _, info := range files {
+		fname := info.Name()
+		src, err := ioutil.ReadFile(path.Join("testdata", fname))
+		if err != nil {
+			t.Errorf("%s", err)
+			t.FailNow()
+		}
+		nodes, err := ParseNodes(src, Options{})
+		if err != nil {
+			t.Errorf("%s, %s", fname, err)
+			continue
+		}
+		tree, err := ParseSyntax(src)
+		if err != nil {
+			t.Errorf("%s, %s", fname, err)
+			continue
+		}
+		var entries []string
+		for _, node := range tree.Nodes {
+			if node.Kind == NodeEntry {
+				entries = append(entries, string(node.Bytes()))
+			}
+		}
+		out := new(bytes.Buffer)
+		for _, node := range nodes {
+			if _, ok := node.(*Element); ok == true && len(entries) > 0 {
+				out.WriteString(entries[0])
+				entries = entries[1:]
+				continue
+			}
+			out.WriteString(node.String())
+		}
+		if bytes.Equal(src, out.Bytes()) == false {
+			t.Errorf("%s, expected\n%q\nfound\n%q", fname, src, out.Bytes())
+		}
+	}
+}
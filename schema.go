@@ -0,0 +1,187 @@
+//
+// schema.go validates elements against the tags expected for their type
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Schema maps entry types, in lower case, to the tags they use
+type Schema struct {
+	Name  string
+	Types map[string]*TagTypes
+	// Aliases maps other names for entry types to one in Types, e.g.
+	// conference to inproceedings
+	Aliases map[string]string
+	// Common lists tags accepted for any entry type, e.g. doi or url
+	Common []string
+}
+
+// Schemas holds the schemas known by name, see SchemaByName
+var Schemas = map[string]*Schema{
+	"bibtex":   BibTeXSchema,
+	"biblatex": BibLaTeXSchema,
+}
+
+// SchemaByName returns the schema registered in Schemas under name,
+// ignoring case
+func SchemaByName(name string) (*Schema, error) {
+	if schema, ok := Schemas[strings.ToLower(name)]; ok == true {
+		return schema, nil
+	}
+	return nil, fmt.Errorf("unknown schema %q", name)
+}
+
+// TypeNames returns the entry types of the schema, aliases included,
+// in alphabetical order
+func (schema *Schema) TypeNames() []string {
+	var names []string
+	for name := range schema.Types {
+		names = append(names, name)
+	}
+	for name := range schema.Aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FindingKind identifies the problem reported by a Finding
+type FindingKind int
+
+const (
+	// MissingRequired is a required tag, or all its alternatives, missing
+	MissingRequired FindingKind = iota
+	// UnknownField is a tag the schema doesn't list for the entry type
+	UnknownField
+	// EmptyCiteKey is an entry without a citation key
+	EmptyCiteKey
+	// UnknownType is an entry type the schema doesn't know
+	UnknownType
+)
+
+// String returns a short name for the kind of finding
+func (kind FindingKind) String() string {
+	switch kind {
+	case MissingRequired:
+		return "missing-required"
+	case UnknownField:
+		return "unknown-field"
+	case EmptyCiteKey:
+		return "empty-citekey"
+	case UnknownType:
+		return "unknown-type"
+	}
+	return fmt.Sprintf("finding-%d", int(kind))
+}
+
+// Finding is a problem found validating an element, the SyntaxError
+// locates the element and describes the problem
+type Finding struct {
+	Kind FindingKind `json:"kind"`
+	// Field is the tag concerned, alternatives are separated by a slash
+	Field string `json:"field,omitempty"`
+	*SyntaxError
+}
+
+// Lookup returns the tags of an entry type ignoring case
+func (schema *Schema) Lookup(elementType string) (*TagTypes, bool) {
+	elementType = strings.ToLower(elementType)
+	if name, ok := schema.Aliases[elementType]; ok == true {
+		elementType = name
+	}
+	tagTypes, ok := schema.Types[elementType]
+	return tagTypes, ok
+}
+
+// Known checks if the schema lists a tag for the entry type, either as
+// required, optional or common to all types
+func (schema *Schema) Known(elementType string, name string) bool {
+	var names []string
+
+	if tagTypes, ok := schema.Lookup(elementType); ok == true {
+		for _, required := range tagTypes.Required {
+			names = append(names, strings.Split(required, "/")...)
+		}
+		names = append(names, tagTypes.Optional...)
+	}
+	names = append(names, schema.Common...)
+	for _, known := range names {
+		if strings.EqualFold(known, name) == true {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks an element against a schema, DefaultSchema if schema is
+// nil. It returns the missing required tags, unknown tags, an empty
+// citation key or an unknown entry type. @string elements are not checked.
+func (element *Element) Validate(schema *Schema) []*Finding {
+	var findings []*Finding
+
+	if schema == nil {
+		schema = DefaultSchema
+	}
+	if strings.EqualFold(element.Type, "string") == true {
+		return nil
+	}
+	add := func(kind FindingKind, field string, msg string) {
+		findings = append(findings, &Finding{
+			Kind:        kind,
+			Field:       field,
+			SyntaxError: elementError(element, msg),
+		})
+	}
+
+	if element.CiteKey == "" {
+		add(EmptyCiteKey, "", "missing citation key")
+	}
+	tagTypes, ok := schema.Lookup(element.Type)
+	if ok == false {
+		add(UnknownType, "", fmt.Sprintf("unknown entry type %q", element.Type))
+		return findings
+	}
+	for _, required := range tagTypes.Required {
+		found := false
+		for _, name := range strings.Split(required, "/") {
+			if _, ok := element.Get(name); ok == true {
+				found = true
+				break
+			}
+		}
+		if found == false {
+			add(MissingRequired, required, fmt.Sprintf("missing required tag %q", strings.Replace(required, "/", " or ", -1)))
+		}
+	}
+	seen := make(map[string]bool)
+	for _, field := range element.Fields() {
+		name := strings.ToLower(field.Name)
+		if seen[name] == true {
+			continue
+		}
+		seen[name] = true
+		if schema.Known(element.Type, name) == false {
+			add(UnknownField, field.Name, fmt.Sprintf("unknown tag %q", field.Name))
+		}
+	}
+	return findings
+}
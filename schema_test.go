@@ -0,0 +1,110 @@
+//
+// schema_test.go tests validating elements
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"strings"
+	"testing"
+)
+
+// findingKinds summarizes findings for comparison
+func findingKinds(findings []*Finding) string {
+	var out []string
+	for _, finding := range findings {
+		out = append(out, finding.Kind.String()+":"+finding.Field)
+	}
+	return strings.Join(out, ",")
+}
+
+// TestValidate checks the findings for each kind of problem
+func TestValidate(t *testing.T) {
+	src := `@book{b1,
+    editor = "Mark Doiel",
+    title = "Turtles",
+    publisher = "Turtle Press",
+    year = 2016
+}
+
+@inbook{b2,
+    author = "R. S. Doiel",
+    title = "Turtles",
+    publisher = "Turtle Press",
+    year = 2016,
+    colour = "green"
+}
+
+@Article{
+    Author = "Fred Zip",
+    Title = "Dragons",
+    Journal = "Dragons in the Applied Sciences",
+    Year = 2016,
+    DOI = "10.1000/182"
+}
+
+@gadget{g1,
+    title = "Not a type"
+}
+
+@string{turtles = "Turtles"}
+`
+	elements, err := ParseWithOptions([]byte(src), Options{Filename: "validate.bib"})
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	expected := []string{
+		"",
+		"missing-required:chapter/pages,unknown-field:colour",
+		"empty-citekey:",
+		"unknown-type:",
+		"",
+	}
+	for i, element := range elements {
+		findings := element.Validate(nil)
+		if result := findingKinds(findings); result != expected[i] {
+			t.Errorf("%d: expected %q, found %q", i, expected[i], result)
+		}
+	}
+
+	finding := elements[1].Validate(BibTeXSchema)[0]
+	if finding.Line != 8 || finding.Key != "b2" || strings.HasPrefix(finding.Error(), "validate.bib:8:1: missing required tag \"chapter or pages\"") == false {
+		t.Errorf("expected the position of b2, found %s", finding)
+	}
+}
+
+// TestCustomSchema checks teams can add their own entry types
+func TestCustomSchema(t *testing.T) {
+	schema := &Schema{
+		Name: "lab",
+		Types: map[string]*TagTypes{
+			"gadget": &TagTypes{
+				Required: []string{"title", "maker"},
+			},
+		},
+	}
+	element := &Element{Type: "gadget", CiteKey: "g1", Tags: map[string]string{"title": "{Widget}", "url": "{https://example.org}"}}
+	if result := findingKinds(element.Validate(schema)); result != "missing-required:maker,unknown-field:url" {
+		t.Errorf("expected maker to be missing and url unknown, found %q", result)
+	}
+	schema.Common = []string{"url"}
+	element.Set("maker", "{Acme}")
+	if findings := element.Validate(schema); len(findings) != 0 {
+		t.Errorf("expected no findings, found %s", findings)
+	}
+}
@@ -0,0 +1,675 @@
+//
+// syntax.go implements a lossless concrete syntax tree for BibTeX source
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"bytes"
+	"strings"
+)
+
+// TokenKind identifies the kind of a SyntaxToken
+type TokenKind int
+
+const (
+	// TokenText is free text between entries or the body of a comment or preamble
+	TokenText TokenKind = iota
+	// TokenAt is the AtSign starting an entry
+	TokenAt
+	// TokenType is an entry type, e.g. article
+	TokenType
+	// TokenOpen and TokenClose are the curly brackets around an entry's body
+	TokenOpen
+	TokenClose
+	// TokenSpace is a run of white space
+	TokenSpace
+	// TokenComma separates keys and tags
+	TokenComma
+	// TokenEqual separates a tag name from its value
+	TokenEqual
+	// TokenConcat is the concatenation operator (#)
+	TokenConcat
+	// TokenString is a quoted or curly bracketed value including its delimiters
+	TokenString
+	// TokenWord is a key, tag name, number or macro name
+	TokenWord
+)
+
+// NodeKind identifies the kind of a SyntaxNode
+type NodeKind int
+
+const (
+	// NodeText is free text between entries
+	NodeText NodeKind = iota
+	// NodeEntry is an entry with a key and tags, including @string
+	NodeEntry
+	// NodeComment is a @comment entry
+	NodeComment
+	// NodePreamble is a @preamble entry
+	NodePreamble
+)
+
+// SyntaxToken is a piece of BibTeX source, Text holds its original bytes
+type SyntaxToken struct {
+	Kind TokenKind
+	Text string
+	// Offset is the position of the token in the source parsed, tokens
+	// added by an edit have an Offset of -1
+	Offset int
+}
+
+// SyntaxField is a tag found in an entry, Name and Value point into the
+// Tokens of the SyntaxNode holding it
+type SyntaxField struct {
+	Name *SyntaxToken
+	// Equal is the = between the name and the value
+	Equal *SyntaxToken
+	// Value holds the tokens from the start of the value to its end,
+	// including any white space and concatenation operators in between.
+	// It is empty for a tag without a value, e.g. "title = ,".
+	Value []*SyntaxToken
+}
+
+// SyntaxNode is free text or an entry. Tokens hold every byte of the
+// node's source in order.
+type SyntaxNode struct {
+	Kind   NodeKind
+	Tokens []*SyntaxToken
+	// Type and Key are set for entries
+	Type string
+	Key  string
+	// Fields are the tags of a NodeEntry
+	Fields []*SyntaxField
+	// Malformed holds bare words found after the Key
+	Malformed []string
+}
+
+// SyntaxTree is a concrete syntax tree of a BibTeX source. It records the
+// original bytes of every token so writing it out reproduces the source
+// exactly, edits only change the bytes of the tokens they touch.
+type SyntaxTree struct {
+	Nodes []*SyntaxNode
+}
+
+// isSpaceByte checks for ASCII white space
+func isSpaceByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+// syntaxErrorAt creates a SyntaxError at an offset into src
+func syntaxErrorAt(src []byte, offset int, elementType string, key string, msg string) *SyntaxError {
+	pos := startPosition.advance(src[:offset])
+	return &SyntaxError{
+		Line:    pos.line,
+		Column:  pos.column,
+		Offset:  offset,
+		Type:    elementType,
+		Key:     key,
+		Snippet: mkSnippet(src[offset:]),
+		Msg:     msg,
+	}
+}
+
+// ParseSyntax parses BibTeX source into a SyntaxTree. It accepts the same
+// source as the Decoder, its Elements match those Parse returns.
+func ParseSyntax(buf []byte) (*SyntaxTree, error) {
+	tree := new(SyntaxTree)
+	textStart := 0
+	i := 0
+	for i < len(buf) {
+		if buf[i] != '@' {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(buf) && isTypeByte(buf[j]) {
+			j++
+		}
+		k := j
+		for k < len(buf) && isSpaceByte(buf[k]) {
+			k++
+		}
+		if j == i+1 || k >= len(buf) || buf[k] != '{' {
+			i = j
+			continue
+		}
+		// Ok it looks like we have an entry, keep the text before it
+		if i > textStart {
+			tree.Nodes = append(tree.Nodes, &SyntaxNode{
+				Kind:   NodeText,
+				Tokens: []*SyntaxToken{{Kind: TokenText, Text: string(buf[textStart:i]), Offset: textStart}},
+			})
+		}
+		node, end, err := parseSyntaxEntry(buf, i, j, k)
+		if err != nil {
+			return tree, err
+		}
+		tree.Nodes = append(tree.Nodes, node)
+		i = end
+		textStart = end
+	}
+	if len(buf) > textStart {
+		tree.Nodes = append(tree.Nodes, &SyntaxNode{
+			Kind:   NodeText,
+			Tokens: []*SyntaxToken{{Kind: TokenText, Text: string(buf[textStart:]), Offset: textStart}},
+		})
+	}
+	return tree, nil
+}
+
+// matchBracket returns the offset of the curly bracket closing the one at
+// start or -1 if there isn't one
+func matchBracket(buf []byte, start int) int {
+	depth := 0
+	for i := start; i < len(buf); i++ {
+		switch buf[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseSyntaxEntry parses the entry whose AtSign is at, type ends at
+// typeEnd and opening curly bracket is at open. It returns the node and
+// the offset after the entry.
+func parseSyntaxEntry(buf []byte, at int, typeEnd int, open int) (*SyntaxNode, int, error) {
+	node := new(SyntaxNode)
+	node.Type = string(buf[at+1 : typeEnd])
+	add := func(kind TokenKind, start int, end int) *SyntaxToken {
+		token := &SyntaxToken{Kind: kind, Text: string(buf[start:end]), Offset: start}
+		node.Tokens = append(node.Tokens, token)
+		return token
+	}
+	add(TokenAt, at, at+1)
+	add(TokenType, at+1, typeEnd)
+	if open > typeEnd {
+		add(TokenSpace, typeEnd, open)
+	}
+	add(TokenOpen, open, open+1)
+	close := matchBracket(buf, open)
+	if close < 0 {
+		return node, len(buf), syntaxErrorAt(buf, at, node.Type, peekKey(buf[open+1:]), "entry is missing a closing curly bracket")
+	}
+
+	switch {
+	case strings.EqualFold(node.Type, "comment"):
+		node.Kind = NodeComment
+		if close > open+1 {
+			add(TokenText, open+1, close)
+		}
+	case strings.EqualFold(node.Type, "preamble"):
+		node.Kind = NodePreamble
+		if close > open+1 {
+			add(TokenText, open+1, close)
+		}
+	default:
+		node.Kind = NodeEntry
+		if err := lexSyntaxBody(buf, open+1, close, add); err != nil {
+			err.Type = node.Type
+			err.Key = peekKey(buf[open+1 : close])
+			return node, close + 1, err
+		}
+		node.index()
+	}
+	add(TokenClose, close, close+1)
+	return node, close + 1, nil
+}
+
+// lexSyntaxBody breaks the body of an entry into tokens
+func lexSyntaxBody(buf []byte, start int, end int, add func(TokenKind, int, int) *SyntaxToken) *SyntaxError {
+	i := start
+	for i < end {
+		c := buf[i]
+		switch {
+		case isSpaceByte(c):
+			j := i
+			for j < end && isSpaceByte(buf[j]) {
+				j++
+			}
+			add(TokenSpace, i, j)
+			i = j
+		case c == ',':
+			add(TokenComma, i, i+1)
+			i++
+		case c == '=':
+			add(TokenEqual, i, i+1)
+			i++
+		case c == '#':
+			add(TokenConcat, i, i+1)
+			i++
+		case c == '{':
+			j := matchBracket(buf[:end], i)
+			if j < 0 {
+				return syntaxErrorAt(buf, i, "", "", "value is missing a closing curly bracket")
+			}
+			add(TokenString, i, j+1)
+			i = j + 1
+		case c == '"':
+			j, depth := i+1, 0
+			for ; j < end; j++ {
+				if buf[j] == '{' {
+					depth++
+				} else if buf[j] == '}' {
+					depth--
+				} else if buf[j] == '"' && depth == 0 {
+					break
+				}
+			}
+			if j >= end {
+				return syntaxErrorAt(buf, i, "", "", "value is missing a closing double quote")
+			}
+			add(TokenString, i, j+1)
+			i = j + 1
+		default:
+			j := i
+			for j < end && isSpaceByte(buf[j]) == false && strings.IndexByte(",=#{}\"", buf[j]) < 0 {
+				j++
+			}
+			add(TokenWord, i, j)
+			i = j
+		}
+	}
+	return nil
+}
+
+// index finds the key and fields in an entry's tokens
+func (node *SyntaxNode) index() {
+	var segment []*SyntaxToken
+
+	node.Key = ""
+	node.Fields = nil
+	node.Malformed = nil
+	first := true
+	flush := func() {
+		defer func() {
+			segment = nil
+			first = false
+		}()
+		for i, token := range segment {
+			if token.Kind != TokenEqual {
+				continue
+			}
+			field := new(SyntaxField)
+			field.Equal = token
+			for _, t := range segment[:i] {
+				if t.Kind == TokenWord {
+					field.Name = t
+					break
+				}
+			}
+			for _, t := range segment[i+1:] {
+				if t.Kind != TokenSpace || len(field.Value) > 0 {
+					field.Value = append(field.Value, t)
+				}
+			}
+			// Trim trailing white space from the value
+			for len(field.Value) > 0 && field.Value[len(field.Value)-1].Kind == TokenSpace {
+				field.Value = field.Value[:len(field.Value)-1]
+			}
+			if field.Name != nil {
+				node.Fields = append(node.Fields, field)
+			}
+			return
+		}
+		word := strings.TrimSpace(tokensText(segment))
+		if first == true {
+			node.Key = word
+		} else if word != "" {
+			node.Malformed = append(node.Malformed, word)
+		}
+	}
+	inBody := false
+	for _, token := range node.Tokens {
+		switch {
+		case token.Kind == TokenOpen:
+			inBody = true
+		case token.Kind == TokenClose || inBody == false:
+			// Skip the AtSign, type and any space before the opening curly bracket
+		case token.Kind == TokenComma:
+			flush()
+		default:
+			segment = append(segment, token)
+		}
+	}
+	flush()
+}
+
+// tokensText joins the text of tokens
+func tokensText(tokens []*SyntaxToken) string {
+	var out strings.Builder
+	for _, token := range tokens {
+		out.WriteString(token.Text)
+	}
+	return out.String()
+}
+
+// Bytes returns the source of the node
+func (node *SyntaxNode) Bytes() []byte {
+	return []byte(tokensText(node.Tokens))
+}
+
+// Bytes returns the source of the tree, for an unedited tree this is
+// identical to the source parsed
+func (tree *SyntaxTree) Bytes() []byte {
+	var out bytes.Buffer
+	for _, node := range tree.Nodes {
+		out.Write(node.Bytes())
+	}
+	return out.Bytes()
+}
+
+// String returns the source of the tree
+func (tree *SyntaxTree) String() string {
+	return string(tree.Bytes())
+}
+
+// Entry returns the first entry with the citation key given or nil
+func (tree *SyntaxTree) Entry(key string) *SyntaxNode {
+	for _, node := range tree.Nodes {
+		if node.Kind == NodeEntry && node.Key == key {
+			return node
+		}
+	}
+	return nil
+}
+
+// Raw returns the source of a field's value
+func (field *SyntaxField) Raw() string {
+	return tokensText(field.Value)
+}
+
+// Field returns the tag with the name given, ignoring case, or nil
+func (node *SyntaxNode) Field(name string) *SyntaxField {
+	for _, field := range node.Fields {
+		if strings.EqualFold(field.Name.Text, name) {
+			return field
+		}
+	}
+	return nil
+}
+
+// tokenIndex finds a token in the node
+func (node *SyntaxNode) tokenIndex(token *SyntaxToken) int {
+	for i, t := range node.Tokens {
+		if t == token {
+			return i
+		}
+	}
+	return -1
+}
+
+// valueSpan returns the range of tokens (end exclusive) holding a field's
+// value. An empty value is the empty range after the = and any white
+// space following it.
+func (node *SyntaxNode) valueSpan(field *SyntaxField) (int, int) {
+	if len(field.Value) > 0 {
+		return node.tokenIndex(field.Value[0]), node.tokenIndex(field.Value[len(field.Value)-1]) + 1
+	}
+	i := node.tokenIndex(field.Equal) + 1
+	if i < len(node.Tokens) && node.Tokens[i].Kind == TokenSpace {
+		i++
+	}
+	return i, i
+}
+
+// splice replaces the tokens from start to end (exclusive) with tokens
+func (node *SyntaxNode) splice(start int, end int, tokens ...*SyntaxToken) {
+	var out []*SyntaxToken
+	out = append(out, node.Tokens[:start]...)
+	out = append(out, tokens...)
+	out = append(out, node.Tokens[end:]...)
+	node.Tokens = out
+}
+
+// SetField sets the raw value (including any delimiters) of a tag. An
+// existing value is replaced in place, otherwise the tag is added after
+// the last one following its layout.
+func (node *SyntaxNode) SetField(name string, value string) {
+	if node.Kind != NodeEntry {
+		return
+	}
+	if field := node.Field(name); field != nil {
+		node.setValue(field, value)
+		return
+	}
+
+	// Follow the layout of the last tag, or use our own
+	indent := "\n    "
+	equal := []*SyntaxToken{
+		{Kind: TokenSpace, Text: " ", Offset: -1},
+		{Kind: TokenEqual, Text: "=", Offset: -1},
+		{Kind: TokenSpace, Text: " ", Offset: -1},
+	}
+	insertAt := len(node.Tokens) - 1
+	trailingComma := true
+	if len(node.Fields) > 0 {
+		last := node.Fields[len(node.Fields)-1]
+		nameAt := node.tokenIndex(last.Name)
+		if nameAt > 0 && node.Tokens[nameAt-1].Kind == TokenSpace {
+			indent = node.Tokens[nameAt-1].Text
+		}
+		valueAt, valueEnd := node.valueSpan(last)
+		equal = nil
+		for _, token := range node.Tokens[nameAt+1 : valueAt] {
+			equal = append(equal, &SyntaxToken{Kind: token.Kind, Text: token.Text, Offset: -1})
+		}
+		insertAt = valueEnd
+		trailingComma = false
+		for i := insertAt; i < len(node.Tokens); i++ {
+			if node.Tokens[i].Kind == TokenComma {
+				trailingComma = true
+				insertAt = i + 1
+				break
+			}
+			if node.Tokens[i].Kind != TokenSpace {
+				break
+			}
+		}
+	} else if node.Key != "" {
+		// Add after the key's comma, or a comma after the key
+		insertAt = -1
+		for i, token := range node.Tokens {
+			if token.Kind == TokenComma {
+				insertAt = i + 1
+				break
+			}
+		}
+		if insertAt < 0 {
+			for i, token := range node.Tokens {
+				if token.Kind == TokenWord {
+					insertAt = i + 1
+				}
+			}
+			trailingComma = false
+		}
+	}
+
+	var tokens []*SyntaxToken
+	if trailingComma == false {
+		tokens = append(tokens, &SyntaxToken{Kind: TokenComma, Text: ",", Offset: -1})
+	}
+	tokens = append(tokens,
+		&SyntaxToken{Kind: TokenSpace, Text: indent, Offset: -1},
+		&SyntaxToken{Kind: TokenWord, Text: name, Offset: -1},
+	)
+	tokens = append(tokens, equal...)
+	tokens = append(tokens, &SyntaxToken{Kind: TokenString, Text: value, Offset: -1})
+	if trailingComma == true {
+		tokens = append(tokens, &SyntaxToken{Kind: TokenComma, Text: ",", Offset: -1})
+	}
+	node.splice(insertAt, insertAt, tokens...)
+	node.index()
+}
+
+// setValue replaces the raw value of one of the node's fields
+func (node *SyntaxNode) setValue(field *SyntaxField, value string) {
+	start, end := node.valueSpan(field)
+	node.splice(start, end, &SyntaxToken{Kind: TokenString, Text: value, Offset: -1})
+	node.index()
+}
+
+// SetType changes the entry type, e.g. from misc to book, leaving the
+// rest of the entry as is
+func (node *SyntaxNode) SetType(elementType string) {
+	for i, token := range node.Tokens {
+		if token.Kind == TokenType {
+			node.splice(i, i+1, &SyntaxToken{Kind: TokenType, Text: elementType, Offset: -1})
+			node.Type = elementType
+			return
+		}
+	}
+}
+
+// RenameField changes the name of a tag, ignoring case, leaving its
+// value and layout as is. It returns false if the tag wasn't found.
+func (node *SyntaxNode) RenameField(name string, newName string) bool {
+	field := node.Field(name)
+	if field == nil {
+		return false
+	}
+	node.rename(field, newName)
+	return true
+}
+
+// rename changes the name of one of the node's fields
+func (node *SyntaxNode) rename(field *SyntaxField, newName string) {
+	i := node.tokenIndex(field.Name)
+	node.splice(i, i+1, &SyntaxToken{Kind: TokenWord, Text: newName, Offset: -1})
+	node.index()
+}
+
+// DeleteField removes a tag, with the white space before it and its
+// comma, returning false if it wasn't found
+func (node *SyntaxNode) DeleteField(name string) bool {
+	field := node.Field(name)
+	if field == nil {
+		return false
+	}
+	start := node.tokenIndex(field.Name)
+	if start > 0 && node.Tokens[start-1].Kind == TokenSpace {
+		start--
+	}
+	end := node.tokenIndex(field.Equal) + 1
+	if len(field.Value) > 0 {
+		_, end = node.valueSpan(field)
+	}
+	hasComma := false
+	for i := end; i < len(node.Tokens); i++ {
+		if node.Tokens[i].Kind == TokenComma {
+			end = i + 1
+			hasComma = true
+			break
+		}
+		if node.Tokens[i].Kind != TokenSpace {
+			break
+		}
+	}
+	if hasComma == false {
+		// The last tag without a trailing comma, remove the comma before it
+		for i := start - 1; i >= 0; i-- {
+			if node.Tokens[i].Kind == TokenComma {
+				start = i
+				break
+			}
+			if node.Tokens[i].Kind != TokenSpace {
+				break
+			}
+		}
+	}
+	node.splice(start, end)
+	node.index()
+	return true
+}
+
+// Node converts a SyntaxNode into an *Element, *Comment or *Preamble
+func (node *SyntaxNode) Node() Node {
+	switch node.Kind {
+	case NodeText:
+		return &Comment{Text: tokensText(node.Tokens), Implicit: true}
+	case NodeComment:
+		return &Comment{Type: node.Type, Text: node.body(), Space: node.space()}
+	case NodePreamble:
+		return &Preamble{Type: node.Type, Text: node.body(), Space: node.space()}
+	}
+	element := new(Element)
+	element.setType(node.Type)
+	element.CiteKey = node.Key
+	if node.Key != "" {
+		element.Keys = append(element.Keys, node.Key)
+	}
+	element.Keys = append(element.Keys, node.Malformed...)
+	for _, field := range node.Fields {
+		var parts []string
+		for _, token := range field.Value {
+			switch token.Kind {
+			case TokenSpace:
+			case TokenConcat:
+				parts = append(parts, " # ")
+			default:
+				parts = append(parts, token.Text)
+			}
+		}
+		element.addField(field.Name.Text, strings.Join(parts, ""))
+	}
+	return element
+}
+
+// space returns any white space between an entry's type and its
+// opening curly bracket
+func (node *SyntaxNode) space() string {
+	for i, token := range node.Tokens {
+		if token.Kind == TokenType && i+1 < len(node.Tokens) && node.Tokens[i+1].Kind == TokenSpace {
+			return node.Tokens[i+1].Text
+		}
+	}
+	return ""
+}
+
+// body returns the text between the curly brackets of an entry
+func (node *SyntaxNode) body() string {
+	var out strings.Builder
+	inBody := false
+	for _, token := range node.Tokens {
+		switch {
+		case token.Kind == TokenOpen:
+			inBody = true
+		case token.Kind == TokenClose:
+			inBody = false
+		case inBody == true:
+			out.WriteString(token.Text)
+		}
+	}
+	return out.String()
+}
+
+// Elements returns the entries of the tree as Elements
+func (tree *SyntaxTree) Elements() []*Element {
+	var elements []*Element
+	for _, node := range tree.Nodes {
+		if node.Kind == NodeEntry {
+			elements = append(elements, node.Node().(*Element))
+		}
+	}
+	return elements
+}
@@ -0,0 +1,261 @@
+//
+// syntax_test.go tests the concrete syntax tree
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+)
+
+// TestSyntaxRoundTrip checks the tree reproduces its source exactly
+func TestSyntaxRoundTrip(t *testing.T) {
+	for _, fname := range []string{"sample0.txt", "sample1.bib", "sample2.bib", "sample3a.bib", "sample3b.bib", "sample-plaintext.txt"} {
+		src, err := ioutil.ReadFile(path.Join("testdata", fname))
+		if err != nil {
+			t.Errorf("%s", err)
+			t.FailNow()
+		}
+		tree, err := ParseSyntax(src)
+		if err != nil {
+			t.Errorf("%s, %s", fname, err)
+			continue
+		}
+		if bytes.Equal(src, tree.Bytes()) == false {
+			t.Errorf("%s, round trip differs\n%s", fname, tree.Bytes())
+		}
+	}
+}
+
+// TestSyntaxElements checks the tree agrees with Parse
+func TestSyntaxElements(t *testing.T) {
+	src, err := ioutil.ReadFile(path.Join("testdata", "sample2.bib"))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	tree, err := ParseSyntax(src)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	expected, err := Parse(src)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	elements := tree.Elements()
+	if len(elements) != len(expected) {
+		t.Errorf("expected %d elements, found %d", len(expected), len(elements))
+		t.FailNow()
+	}
+	for i, element := range elements {
+		for name, val := range expected[i].Tags {
+			if element.Tags[name] != val {
+				t.Errorf("%d: %s expected %q, found %q", i, name, val, element.Tags[name])
+			}
+		}
+	}
+	if c, ok := tree.Nodes[0].Node().(*Comment); ok == false || strings.Contains(c.Text, "This is some sort of comment") == false {
+		t.Errorf("expected the @comment, found %s", tree.Nodes[0].Node())
+	}
+}
+
+// TestSyntaxEdits checks edits only touch the affected span
+func TestSyntaxEdits(t *testing.T) {
+	src, err := ioutil.ReadFile(path.Join("testdata", "sample1.bib"))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	tree, err := ParseSyntax(src)
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	entry := tree.Entry("a3")
+	if entry == nil {
+		t.Errorf("expected to find a3")
+		t.FailNow()
+	}
+	if field := entry.Field("author"); field == nil || field.Raw() != `markdoiel # "and" # rsdoiel` {
+		t.Errorf("expected author value, found %v", field)
+	}
+
+	// Replace a value
+	entry.SetField("title", "{Turtles in the Time Continuum}")
+	expected := strings.Replace(string(src), `title = "Turtles in the time continum"`, `title = {Turtles in the Time Continuum}`, 1)
+	if tree.String() != expected {
+		t.Errorf("replace, expected\n%s\nfound\n%s", expected, tree)
+	}
+
+	// Add a value after the last tag (no trailing comma there)
+	entry = tree.Entry("id1")
+	entry.SetField("note", "{added}")
+	expected = strings.Replace(expected, `    year="1958"
+}`, `    year="1958",
+    note={added}
+}`, 1)
+	if tree.String() != expected {
+		t.Errorf("add, expected\n%s\nfound\n%s", expected, tree)
+	}
+
+	// Delete a value
+	if tree.Entry("a3").DeleteField("journal") == false {
+		t.Errorf("expected to delete journal")
+	}
+	expected = strings.Replace(expected, `
+    journal = "Turtles in the Applied Sciences",`, "", 1)
+	if tree.String() != expected {
+		t.Errorf("delete, expected\n%s\nfound\n%s", expected, tree)
+	}
+
+	// Delete the last value without a trailing comma
+	if tree.Entry("id1").DeleteField("note") == false {
+		t.Errorf("expected to delete note")
+	}
+	expected = strings.Replace(expected, `,
+    note={added}`, "", 1)
+	if tree.String() != expected {
+		t.Errorf("delete last, expected\n%s\nfound\n%s", expected, tree)
+	}
+	if strings.Contains(tree.String(), `title={Another test},
+    year="1958"
+}`) == false {
+		t.Errorf("expected id1 to be restored\n%s", tree)
+	}
+}
+
+// TestSyntaxTreeError checks problems are reported with their position
+func TestSyntaxTreeError(t *testing.T) {
+	_, err := ParseSyntax([]byte("@misc{id1, title={ok}}\n@article{a2,\n  title = \"open\n}\n"))
+	e, ok := err.(*SyntaxError)
+	if ok == false {
+		t.Errorf("expected a *SyntaxError, found %v", err)
+		t.FailNow()
+	}
+	if e.Line != 3 || e.Column != 11 || e.Type != "article" || e.Key != "a2" {
+		t.Errorf("expected error at 3:11 in @article{a2, found %s", e)
+	}
+}
+
+// TestSyntaxAgreesWithParse checks ParseSyntax and Parse accept the same
+// files and find the same entries in them
+func TestSyntaxAgreesWithParse(t *testing.T) {
+	sources := map[string][]byte{
+		"empty value":   []byte("@article{k, note = , jounral = {X}, pages={1-2}}"),
+		"concatenation": []byte("@string{tt = {Turtles}}\n@misc{m1, title = tt # \" all the way\" # {down}}"),
+		"malformed":     []byte("@misc{m2, stray, year = 2016}"),
+	}
+	files, err := ioutil.ReadDir("testdata")
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	for _, info := range files {
+		src, err := ioutil.ReadFile(path.Join("testdata", info.Name()))
+		if err != nil {
+			t.Errorf("%s", err)
+			t.FailNow()
+		}
+		sources[info.Name()] = src
+	}
+	for fname, src := range sources {
+		tree, treeErr := ParseSyntax(src)
+		expected, err := Parse(src)
+		if (treeErr == nil) != (err == nil || err.Error() == "no elements found") {
+			t.Errorf("%s, ParseSyntax returned %v, Parse returned %v", fname, treeErr, err)
+			continue
+		}
+		if treeErr != nil {
+			continue
+		}
+		elements := tree.Elements()
+		if len(elements) != len(expected) {
+			t.Errorf("%s, expected %d elements, found %d", fname, len(expected), len(elements))
+			continue
+		}
+		for i, element := range elements {
+			if element.String() != expected[i].String() {
+				t.Errorf("%s, element %d expected\n%s\nfound\n%s", fname, i, expected[i], element)
+			}
+		}
+	}
+}
+
+// TestSyntaxEmptyValue checks a tag without a value can be edited
+func TestSyntaxEmptyValue(t *testing.T) {
+	src := "@article{k, note = , title = {T}}"
+	tree, err := ParseSyntax([]byte(src))
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	entry := tree.Entry("k")
+	if field := entry.Field("note"); field == nil || len(field.Value) != 0 {
+		t.Errorf("expected an empty note, found %v", field)
+		t.FailNow()
+	}
+	entry.SetField("note", "{N}")
+	expected := "@article{k, note = {N}, title = {T}}"
+	if tree.String() != expected {
+		t.Errorf("set, expected %q, found %q", expected, tree)
+	}
+
+	for _, test := range []struct {
+		src      string
+		edit     func(*SyntaxNode) bool
+		expected string
+	}{
+		{
+			src:      "@article{k, note = , title = {T}}",
+			edit:     func(node *SyntaxNode) bool { return node.DeleteField("note") },
+			expected: "@article{k, title = {T}}",
+		},
+		{
+			src:      "@article{k, title = {T}, note =}",
+			edit:     func(node *SyntaxNode) bool { return node.DeleteField("note") },
+			expected: "@article{k, title = {T}}",
+		},
+		{
+			src:      "@article{k, title = {T}, note = }",
+			edit:     func(node *SyntaxNode) bool { return node.RenameField("note", "annote") },
+			expected: "@article{k, title = {T}, annote = }",
+		},
+		{
+			src:      "@article{k, title = {T}, note = }",
+			edit:     func(node *SyntaxNode) bool { node.SetField("year", "2016"); return true },
+			expected: "@article{k, title = {T}, note = , year = 2016}",
+		},
+	} {
+		tree, err := ParseSyntax([]byte(test.src))
+		if err != nil {
+			t.Errorf("%s", err)
+			continue
+		}
+		if test.edit(tree.Entry("k")) == false {
+			t.Errorf("%s, edit failed", test.src)
+		}
+		if tree.String() != test.expected {
+			t.Errorf("expected %q, found %q", test.expected, tree)
+		}
+	}
+}
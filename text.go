@@ -0,0 +1,114 @@
+//
+// text.go converts the text of tag values between LaTeX and Unicode
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"strings"
+
+	// My packages
+	"github.com/rsdoiel/bibtex/latex"
+)
+
+// TextConversion controls how an Encoder or Decoder converts the text
+// of tag values
+type TextConversion int
+
+const (
+	// TextAsIs leaves values unchanged
+	TextAsIs TextConversion = iota
+	// TextUnicode converts LaTeX accents, ligatures, dashes and symbols
+	// to Unicode, see latex.ToUnicode
+	TextUnicode
+	// TextLaTeX converts non-ASCII characters to LaTeX commands, see
+	// latex.ToLaTeX
+	TextLaTeX
+)
+
+// verbatimTags hold identifiers, links and citation keys which are
+// never converted
+var verbatimTags = map[string]bool{
+	"url":      true,
+	"doi":      true,
+	"eprint":   true,
+	"file":     true,
+	"pdf":      true,
+	"crossref": true,
+	"xdata":    true,
+	"ids":      true,
+	"related":  true,
+}
+
+// converter returns the function applying a conversion to text
+func (conversion TextConversion) converter() func(string) string {
+	switch conversion {
+	case TextUnicode:
+		return latex.ToUnicode
+	case TextLaTeX:
+		return latex.ToLaTeX
+	}
+	return nil
+}
+
+// convertValue converts the text in the quoted and braced parts of a raw
+// tag value, macro names and numbers are left alone
+func convertValue(val string, conversion TextConversion) string {
+	convert := conversion.converter()
+	if convert == nil {
+		return val
+	}
+	changed := false
+	parts := splitValue(val)
+	for i, part := range parts {
+		if isQuoted(part) || isBraced(part) {
+			text := convert(part[1 : len(part)-1])
+			if text != part[1:len(part)-1] {
+				parts[i] = part[:1] + text + part[len(part)-1:]
+				changed = true
+			}
+		}
+	}
+	if changed == false {
+		return val
+	}
+	return strings.Join(parts, " # ")
+}
+
+// convertText applies a conversion to the element's tag values, raw
+// and resolved, skipping verbatim tags such as url and doi
+func (element *Element) convertText(conversion TextConversion) {
+	convert := conversion.converter()
+	if convert == nil {
+		return
+	}
+	for _, field := range element.fields {
+		if verbatimTags[strings.ToLower(field.Name)] == false {
+			field.Value = convertValue(field.Value, conversion)
+		}
+	}
+	for key, val := range element.Tags {
+		if verbatimTags[key] == false {
+			element.Tags[key] = convertValue(val, conversion)
+		}
+	}
+	for key, val := range element.Resolved {
+		if verbatimTags[key] == false {
+			element.Resolved[key] = convert(val)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+//
+// text_test.go tests converting tag values as they are decoded and encoded
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"bytes"
+	"testing"
+)
+
+var textSrc = `@string{ kg = "Kurt G{\"o}del" }
+
+@article{g1,
+    author = kg # " and {\'E}mile Borel",
+    title = {{\"U}ber formal unentscheidbare S{\"a}tze},
+    pages = "173--198",
+    url = {http://example.org/~kg/g{\"o}del},
+    year = 1931
+}`
+
+// TestDecodeText checks values are converted as they are decoded
+func TestDecodeText(t *testing.T) {
+	elements, err := ParseWithOptions([]byte(textSrc), Options{Text: TextUnicode})
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	expected := map[string]string{
+		"author": `kg # " and Émile Borel"`,
+		"title":  "{Über formal unentscheidbare Sätze}",
+		"pages":  `"173–198"`,
+		"url":    `{http://example.org/~kg/g{\"o}del}`,
+		"year":   "1931",
+	}
+	for name, val := range expected {
+		if result, _ := elements[1].Get(name); result != val {
+			t.Errorf("%s: expected %q, found %q", name, val, result)
+		}
+	}
+	for _, field := range elements[1].Fields() {
+		if field.Value != expected[field.Name] {
+			t.Errorf("%s: expected the field list to match, found %q", field.Name, field.Value)
+		}
+	}
+	if val, _ := elements[0].Get("kg"); val != `"Kurt Gödel"` {
+		t.Errorf("expected the @string to be converted, found %q", val)
+	}
+	if _, err := Resolve(elements); err != nil {
+		t.Errorf("%s", err)
+	}
+	if author := elements[1].Resolved["author"]; author != "Kurt Gödel and Émile Borel" {
+		t.Errorf("expected resolved names in Unicode, found %q", author)
+	}
+}
+
+// TestEncodeText checks values are converted as they are encoded
+func TestEncodeText(t *testing.T) {
+	elements, err := ParseWithOptions([]byte(textSrc), Options{Text: TextUnicode})
+	if err != nil {
+		t.Errorf("%s", err)
+		t.FailNow()
+	}
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+	enc.Text = TextLaTeX
+	if err := enc.Encode(elements[1]); err != nil {
+		t.Errorf("%s", err)
+	}
+	expected := `@article{g1,
+    author = kg # " and {\'E}mile Borel",
+    title = {{\"U}ber formal unentscheidbare S{\"a}tze},
+    pages = "173--198",
+    url = {http://example.org/~kg/g{\"o}del},
+    year = 1931,
+}
+
+`
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, buf.String())
+	}
+
+	// Resolved values are converted too
+	Resolve(elements)
+	buf.Reset()
+	enc.Resolved = true
+	enc.Delimiter = DelimiterBraces
+	if err := enc.Encode(elements[1]); err != nil {
+		t.Errorf("%s", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte(`author = {Kurt G{\"o}del and {\'E}mile Borel},`)) == false {
+		t.Errorf("expected the resolved author in LaTeX, found\n%s", buf.String())
+	}
+}
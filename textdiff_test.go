@@ -0,0 +1,121 @@
+//
+// textdiff_test.go tests the unified diff
+//
+// @author R. S. Doiel, <rsdoiel@gmail.com>
+//
+// Copyright (c) 2016, R. S. Doiel
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following disclaimer in the documentation and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its contributors may be used to endorse or promote products derived from this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+//
+package bibtex
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// applyDiff applies a unified diff made by UnifiedDiff to from
+func applyDiff(from []byte, diff []byte) string {
+	var out []string
+	lines := splitLines(from)
+	at := 0
+	for _, line := range strings.SplitAfter(string(diff), "\n") {
+		switch {
+		case strings.HasPrefix(line, "---") || strings.HasPrefix(line, "+++") || line == "":
+		case strings.HasPrefix(line, "@@"):
+			fields := strings.Fields(line)
+			start, _ := strconv.Atoi(strings.Split(fields[1][1:], ",")[0])
+			count, _ := strconv.Atoi(strings.Split(fields[1], ",")[1])
+			if count == 0 {
+				start++
+			}
+			for ; at < start-1; at++ {
+				out = append(out, lines[at])
+			}
+		case strings.HasPrefix(line, "\\"):
+			// Drop the new line added after the last line
+			last := len(out) - 1
+			if last >= 0 && strings.HasSuffix(out[last], "\n") {
+				out[last] = strings.TrimSuffix(out[last], "\n")
+			}
+		case line[0] == ' ':
+			out = append(out, lines[at])
+			at++
+		case line[0] == '-':
+			at++
+		case line[0] == '+':
+			out = append(out, line[1:])
+		}
+	}
+	out = append(out, lines[at:]...)
+	return strings.Join(out, "")
+}
+
+// TestUnifiedDiff checks the format of a diff
+func TestUnifiedDiff(t *testing.T) {
+	from := "a\nb\nc\nd\ne\nf\ng\nh\n"
+	to := "a\nb\nc\nD\ne\nf\ng\nh\ni\n"
+	expected := `--- a/x.bib
++++ b/x.bib
+@@ -1,8 +1,9 @@
+ a
+ b
+ c
+-d
++D
+ e
+ f
+ g
+ h
++i
+`
+	if result := string(UnifiedDiff("a/x.bib", "b/x.bib", []byte(from), []byte(to))); result != expected {
+		t.Errorf("expected\n%s\nfound\n%s", expected, result)
+	}
+	if UnifiedDiff("a", "b", []byte(from), []byte(from)) != nil {
+		t.Errorf("expected no diff for the same text")
+	}
+}
+
+// TestUnifiedDiffApplies checks random edits can be applied back
+func TestUnifiedDiffApplies(t *testing.T) {
+	r := rand.New(rand.NewSource(2016))
+	for i := 0; i < 200; i++ {
+		var a, b []string
+		for j := r.Intn(40); j > 0; j-- {
+			line := strconv.Itoa(r.Intn(8)) + "\n"
+			a = append(a, line)
+			switch r.Intn(6) {
+			case 0:
+				// dropped from b
+			case 1:
+				b = append(b, line, "added\n")
+			case 2:
+				b = append(b, "changed\n")
+			default:
+				b = append(b, line)
+			}
+		}
+		from := strings.Join(a, "")
+		to := strings.Join(b, "")
+		if r.Intn(4) == 0 {
+			to = strings.TrimSuffix(to, "\n")
+		}
+		diff := UnifiedDiff("a", "b", []byte(from), []byte(to))
+		if result := applyDiff([]byte(from), diff); result != to {
+			t.Errorf("%d: expected %q, found %q\n%s", i, to, result, diff)
+			t.FailNow()
+		}
+	}
+}